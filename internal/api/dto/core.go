@@ -1,38 +1,141 @@
 package dto
 
-import "github.com/etcdfinder/etcdfinder/internal/customerrors"
+import (
+	"fmt"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/service"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+)
 
 type GetKeyRequest struct {
-	Key string `json:"key"`
+	Key      string `json:"key"`
+	Revision int64  `json:"revision"` // revision to read the key at, defaults to the latest one
 }
 
 func (g *GetKeyRequest) Validate() error {
 	if g.Key == "" {
 		return customerrors.ErrKeyRequired
 	}
+	if g.Revision < 0 {
+		return customerrors.ErrInvalidRevision
+	}
 	return nil
 }
 
 type GetKeyResponse struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+	Key            string     `json:"key"`
+	Value          string     `json:"value"`
+	CreateRevision int64      `json:"create_revision"`      // createdIndex on etcd v2
+	ModRevision    int64      `json:"mod_revision"`         // modifiedIndex on etcd v2
+	Version        int64      `json:"version"`              // etcd v3 only
+	Lease          string     `json:"lease,omitempty"`      // hexadecimal lease ID, etcd v3 only
+	TTL            int64      `json:"ttl,omitempty"`        // remaining seconds to live, -1 if the lease has expired
+	Expiration     *time.Time `json:"expiration,omitempty"` // etcd v2 only
+}
+
+type KeyHistoryRequest struct {
+	Key      string `json:"key"`
+	Revision int64  `json:"revision"` // revision to walk the history back from, defaults to the latest one
+	Limit    int64  `json:"limit"`    // maximum number of versions to return, defaults to 100
+}
+
+func (k *KeyHistoryRequest) Validate() error {
+	if k.Key == "" {
+		return customerrors.ErrKeyRequired
+	}
+	if k.Revision < 0 {
+		return customerrors.ErrInvalidRevision
+	}
+	if k.Limit < 0 || k.Limit > etcd.MaxHistoryLimit {
+		return customerrors.ErrInvalidPagination
+	}
+	return nil
+}
+
+type KeyHistoryResponse struct {
+	Key          string       `json:"key"`
+	Versions     []KeyVersion `json:"versions"`      // newest first
+	NextRevision int64        `json:"next_revision"` // revision to request the next page at, 0 if there is none
+	Compacted    bool         `json:"compacted"`     // whether older versions have been compacted
+}
+
+type KeyVersion struct {
+	Value          string `json:"value"`
+	CreateRevision int64  `json:"create_revision"`
+	ModRevision    int64  `json:"mod_revision"`
+	Version        int64  `json:"version"`
 }
 
 type SearchKeysRequest struct {
 	SearchStr string `json:"search_str"`
+	Mode      string `json:"mode"`   // fuzzy, prefix, glob or regex, defaults to fuzzy
+	Scope     string `json:"scope"`  // keys, values or both, defaults to keys
+	Offset    int64  `json:"offset"` // number of results to skip
+	Limit     int64  `json:"limit"`  // maximum number of results to return, defaults to 100
 }
 
 func (s *SearchKeysRequest) Validate() error {
+	if s.Mode != "" && !kvstore.SearchMode(s.Mode).Valid() {
+		return customerrors.ErrInvalidSearchMode
+	}
+	if s.Scope != "" && !kvstore.SearchScope(s.Scope).Valid() {
+		return customerrors.ErrInvalidSearchScope
+	}
+	if s.Offset < 0 || s.Limit < 0 || s.Limit > kvstore.MaxSearchLimit {
+		return customerrors.ErrInvalidPagination
+	}
 	return nil
 }
 
 type SearchKeysResponse struct {
-	Keys []string `json:"keys"`
+	Keys               []string       `json:"keys"`
+	Results            []SearchResult `json:"results"`
+	Offset             int64          `json:"offset"`
+	Limit              int64          `json:"limit"`
+	EstimatedTotalHits int64          `json:"estimated_total_hits"`
+}
+
+type SearchResult struct {
+	Key           string   `json:"key"`
+	MatchedFields []string `json:"matched_fields"` // key and/or value
+}
+
+type ListChildrenRequest struct {
+	Prefix string `json:"prefix"` // directory to list, followed by "/" if it does not end with one
+	Offset int64  `json:"offset"` // number of children to skip
+	Limit  int64  `json:"limit"`  // maximum number of children to return, defaults to 100
+}
+
+func (l *ListChildrenRequest) Validate() error {
+	if l.Offset < 0 || l.Limit < 0 || l.Limit > etcd.MaxChildrenLimit {
+		return customerrors.ErrInvalidPagination
+	}
+	return nil
+}
+
+type ListChildrenResponse struct {
+	Prefix        string  `json:"prefix"`
+	Children      []Child `json:"children"`
+	Offset        int64   `json:"offset"`
+	Limit         int64   `json:"limit"`
+	TotalChildren int64   `json:"total_children"`
+	TotalKeys     int64   `json:"total_keys"` // keys under the prefix at any depth
+}
+
+type Child struct {
+	Name string `json:"name"` // directories end with "/"
+	Key  string `json:"key"`  // full key of a leaf, or prefix of a directory
+	Dir  bool   `json:"dir"`
+	Keys int64  `json:"keys"` // keys under a directory at any depth, 1 for a leaf
 }
 
 type PutKeyRequest struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
+	Precondition
 }
 
 func (p *PutKeyRequest) Validate() error {
@@ -42,6 +145,23 @@ func (p *PutKeyRequest) Validate() error {
 	if p.Value == "" {
 		return customerrors.ErrValueRequired
 	}
+	return p.Precondition.Validate()
+}
+
+// Precondition makes a write fail with KEY_MODIFIED if the key has changed since it was read
+type Precondition struct {
+	ModRevision *int64  `json:"mod_revision,omitempty"` // mod revision the key must still have, 0 if it must not exist
+	PrevIndex   *int64  `json:"prev_index,omitempty"`   // modified index the key must still have on etcd v2, same as mod_revision
+	PrevValue   *string `json:"prev_value,omitempty"`   // value the key must still have
+}
+
+func (p *Precondition) Validate() error {
+	if p.ModRevision != nil && p.PrevIndex != nil {
+		return fmt.Errorf("%w: mod_revision and prev_index are the same condition, set only one of them", customerrors.ErrInvalidRevision)
+	}
+	if (p.ModRevision != nil && *p.ModRevision < 0) || (p.PrevIndex != nil && *p.PrevIndex < 0) {
+		return customerrors.ErrInvalidRevision
+	}
 	return nil
 }
 
@@ -50,21 +170,202 @@ type PutKeyResponse struct {
 	Value string `json:"value"`
 }
 
+type RollbackKeyRequest struct {
+	Key      string `json:"key"`
+	Revision int64  `json:"revision"` // revision whose value is restored
+}
+
+func (r *RollbackKeyRequest) Validate() error {
+	if r.Key == "" {
+		return customerrors.ErrKeyRequired
+	}
+	if r.Revision <= 0 {
+		return customerrors.ErrInvalidRevision
+	}
+	return nil
+}
+
+type RollbackKeyResponse struct {
+	Key            string `json:"key"`
+	OldValue       string `json:"old_value"`
+	OldModRevision int64  `json:"old_mod_revision"` // 0 if the key did not exist
+	RestoredValue  string `json:"restored_value"`
+	RestoredFrom   int64  `json:"restored_from"` // mod revision the restored value was written at
+	Revision       int64  `json:"revision"`      // revision of the rollback write
+}
+
 type DeleteKeyRequest struct {
 	Key string `json:"key"`
+	Precondition
 }
 
 func (d *DeleteKeyRequest) Validate() error {
 	if d.Key == "" {
 		return customerrors.ErrKeyRequired
 	}
-	return nil
+	return d.Precondition.Validate()
 }
 
 type DeleteKeyResponse struct {
 	Key string `json:"key"`
 }
 
+type DeletePrefixRequest struct {
+	Prefix string `json:"prefix"`
+	Token  string `json:"token"` // token returned by the preview, empty to preview the delete
+}
+
+func (d *DeletePrefixRequest) Validate() error {
+	if d.Prefix == "" {
+		return customerrors.ErrPrefixRequired
+	}
+	return nil
+}
+
+type DeletePrefixResponse struct {
+	Prefix    string     `json:"prefix"`
+	DryRun    bool       `json:"dry_run"`
+	Count     int64      `json:"count"`                // keys under the prefix for a preview, keys deleted otherwise
+	Keys      []string   `json:"keys"`                 // first keys under the prefix for a preview, keys deleted otherwise
+	Token     string     `json:"token,omitempty"`      // token confirming the delete, omitted if there is nothing to delete
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // time the token expires
+}
+
+// TransferRequest is the request of both /v1/copy and /v1/move
+type TransferRequest struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Prefix      bool   `json:"prefix"` // whether source and destination are prefixes rather than single keys
+	Policy      string `json:"policy"` // overwrite or skip_existing, defaults to skip_existing
+}
+
+func (t *TransferRequest) Validate() error {
+	if t.Source == "" {
+		return customerrors.ErrKeyRequired
+	}
+	if t.Destination == "" {
+		return customerrors.ErrInvalidDestination
+	}
+	if t.Policy != "" && !service.CopyPolicy(t.Policy).Valid() {
+		return customerrors.ErrInvalidCopyPolicy
+	}
+	return nil
+}
+
+type TransferResponse struct {
+	Source         string        `json:"source"`
+	Destination    string        `json:"destination"`
+	Succeeded      int           `json:"succeeded"`        // keys copied or moved
+	CopiedNotMoved int           `json:"copied_not_moved"` // keys moved whose source changed after the destination was written
+	Skipped        int           `json:"skipped"`          // keys whose destination already existed
+	Failed         int           `json:"failed"`
+	Results        []KeyTransfer `json:"results"`
+}
+
+type KeyTransfer struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Status      string `json:"status"` // copied, moved, copied_not_moved, skipped or failed
+	Error       string `json:"error,omitempty"`
+}
+
+type ExportRequest struct {
+	Prefix string `json:"prefix"` // directory to export, followed by "/" if it does not end with one
+	Format string `json:"format"` // json, nested_json, yaml, env or etcdctl, defaults to json
+}
+
+func (e *ExportRequest) Validate() error {
+	if e.Format != "" && !service.ExportFormat(e.Format).Valid() {
+		return customerrors.ErrInvalidExportFormat
+	}
+	return nil
+}
+
+type TxnRequest struct {
+	Compare []TxnCompare `json:"compare"` // conditions that must all hold for the then operations to run
+	Then    []TxnOp      `json:"then"`    // operations run if every condition holds
+	Else    []TxnOp      `json:"else"`    // operations run otherwise
+}
+
+type TxnCompare struct {
+	Key    string `json:"key"`
+	Target string `json:"target"` // value, version, mod_revision, create_revision or exists
+	Op     string `json:"op"`     // =, !=, < or >, defaults to =, ignored by the exists target
+	Value  string `json:"value"`  // compared against by the value target
+	Number int64  `json:"number"` // compared against by the version, mod_revision and create_revision targets
+	Exists bool   `json:"exists"` // whether the key must exist, for the exists target
+}
+
+type TxnOp struct {
+	Type  string `json:"type"` // put, delete or get
+	Key   string `json:"key"`
+	Value string `json:"value"` // value written by a put
+}
+
+func (t *TxnRequest) Validate() error {
+	for i, cmp := range t.Compare {
+		if cmp.Key == "" {
+			return fmt.Errorf("%w: compare[%d]: key is required", customerrors.ErrInvalidTxn, i)
+		}
+		if !etcd.CompareTarget(cmp.Target).Valid() {
+			return fmt.Errorf("%w: compare[%d]: target must be one of value, version, mod_revision, create_revision or exists", customerrors.ErrInvalidTxn, i)
+		}
+		if cmp.Op != "" && !etcd.CompareOp(cmp.Op).Valid() {
+			return fmt.Errorf("%w: compare[%d]: op must be one of =, !=, < or >", customerrors.ErrInvalidTxn, i)
+		}
+	}
+	if err := validateTxnOps("then", t.Then); err != nil {
+		return err
+	}
+	return validateTxnOps("else", t.Else)
+}
+
+func validateTxnOps(branch string, ops []TxnOp) error {
+	for i, op := range ops {
+		if op.Key == "" {
+			return fmt.Errorf("%w: %s[%d]: key is required", customerrors.ErrInvalidTxn, branch, i)
+		}
+		if !etcd.OpType(op.Type).Valid() {
+			return fmt.Errorf("%w: %s[%d]: type must be one of put, delete or get", customerrors.ErrInvalidTxn, branch, i)
+		}
+	}
+	return nil
+}
+
+type TxnResponse struct {
+	Succeeded bool          `json:"succeeded"` // whether every condition held and the then operations ran
+	Revision  int64         `json:"revision"`  // revision of the transaction
+	Results   []TxnOpResult `json:"results"`   // results of the operations that ran, in order
+}
+
+type TxnOpResult struct {
+	Type    string      `json:"type"`
+	Key     string      `json:"key"`
+	KV      *KeyVersion `json:"kv,omitempty"`      // key read by a get, omitted if it does not exist
+	Deleted *int64      `json:"deleted,omitempty"` // number of keys removed by a delete
+}
+
 type GetIngestionDelayResponse struct {
-	IngestionDelay int `json:"ingestion_delay"`
+	IngestionDelay      int64  `json:"ingestion_delay"` // in milliseconds
+	RevisionsBehind     int64  `json:"revisions_behind"`
+	EventsQueued        int    `json:"events_queued"`
+	LastAppliedRevision int64  `json:"last_applied_revision"`
+	LastWatchedRevision int64  `json:"last_watched_revision"`
+	EtcdRevision        int64  `json:"etcd_revision"`
+	PendingWrites       int    `json:"pending_writes"`
+	FailedWrites        int64  `json:"failed_writes"`
+	LastWriteError      string `json:"last_write_error,omitempty"`
+	Rebuilding          bool   `json:"rebuilding"`
+}
+
+type GetReconciliationStatusResponse struct {
+	Runs              int64      `json:"runs"`
+	LastRunAt         *time.Time `json:"last_run_at,omitempty"`
+	LastRevision      int64      `json:"last_revision"`
+	LastRepairedKeys  int        `json:"last_repaired_keys"`
+	TotalRepairedKeys int64      `json:"total_repaired_keys"`
+}
+
+type ReindexResponse struct {
+	Status string `json:"status"`
 }
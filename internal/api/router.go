@@ -32,10 +32,20 @@ func NewRouter(handlers Handlers) (*gin.Engine, error) {
 
 	{
 		v1.POST("/get-key", handlers.EtcdFinderHandler.GetKey)
+		v1.POST("/key-history", handlers.EtcdFinderHandler.GetKeyHistory)
 		v1.POST("/search-keys", handlers.EtcdFinderHandler.SearchKeys)
+		v1.POST("/list-children", handlers.EtcdFinderHandler.ListChildren)
 		v1.PUT("/put-key", handlers.EtcdFinderHandler.PutKey)
+		v1.POST("/rollback-key", handlers.EtcdFinderHandler.RollbackKey)
 		v1.DELETE("/delete-key", handlers.EtcdFinderHandler.DeleteKey)
+		v1.DELETE("/delete-prefix", handlers.EtcdFinderHandler.DeletePrefix)
+		v1.POST("/copy", handlers.EtcdFinderHandler.Copy)
+		v1.POST("/move", handlers.EtcdFinderHandler.Move)
+		v1.POST("/txn", handlers.EtcdFinderHandler.Txn)
+		v1.POST("/export", handlers.EtcdFinderHandler.Export)
 		v1.GET("/ingestion-delay", handlers.EtcdFinderHandler.GetIngestionDelay)
+		v1.GET("/reconciliation-status", handlers.EtcdFinderHandler.GetReconciliationStatus)
+		v1.POST("/reindex", handlers.EtcdFinderHandler.Reindex)
 	}
 
 	return router, nil
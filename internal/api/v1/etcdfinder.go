@@ -1,11 +1,17 @@
 package v1
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/etcdfinder/etcdfinder/internal/api/dto"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
 	"github.com/etcdfinder/etcdfinder/internal/service"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
 	"github.com/gin-gonic/gin"
 )
 
@@ -31,16 +37,67 @@ func (e *EtcdfinderHandler) GetKey(c *gin.Context) {
 		return
 	}
 
-	resp, err := e.etcdSvcClt.GetKey(c.Request.Context(), req.Key)
+	kv, err := e.etcdSvcClt.GetKey(c.Request.Context(), req.Key, req.Revision)
 	if err != nil {
 		c.Error(err) //nolint
 		return
 	}
 
-	c.JSON(http.StatusOK, dto.GetKeyResponse{
-		Key:   req.Key,
-		Value: resp,
-	})
+	resp := dto.GetKeyResponse{
+		Key:            req.Key,
+		Value:          kv.Value,
+		CreateRevision: kv.CreateRevision,
+		ModRevision:    kv.ModRevision,
+		Version:        kv.Version,
+		TTL:            kv.TTL,
+		Expiration:     kv.Expiration,
+	}
+	// Lease IDs are shown in hexadecimal like etcdctl does, which also keeps them exact in JavaScript clients
+	if kv.Lease != 0 {
+		resp.Lease = strconv.FormatInt(kv.Lease, 16)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (e *EtcdfinderHandler) GetKeyHistory(c *gin.Context) {
+	var req dto.KeyHistoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(fmt.Errorf("invalid request: %w", err)) //nolint
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = etcd.DefaultHistoryLimit
+	}
+	history, err := e.etcdSvcClt.GetKeyHistory(c.Request.Context(), req.Key, req.Revision, limit)
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	resp := dto.KeyHistoryResponse{
+		Key:          req.Key,
+		Versions:     make([]dto.KeyVersion, 0, len(history.Versions)),
+		NextRevision: history.NextRevision,
+		Compacted:    history.Compacted,
+	}
+	for _, kv := range history.Versions {
+		resp.Versions = append(resp.Versions, dto.KeyVersion{
+			Value:          kv.Value,
+			CreateRevision: kv.CreateRevision,
+			ModRevision:    kv.ModRevision,
+			Version:        kv.Version,
+		})
+	}
+
+	c.JSON(http.StatusOK, resp)
 }
 
 func (e *EtcdfinderHandler) SearchKeys(c *gin.Context) {
@@ -55,15 +112,74 @@ func (e *EtcdfinderHandler) SearchKeys(c *gin.Context) {
 		return
 	}
 
-	resp, err := e.etcdSvcClt.SearchKeys(c.Request.Context(), req.SearchStr)
+	opts := kvstore.SearchOptions{
+		Mode:   kvstore.SearchMode(req.Mode),
+		Scope:  kvstore.SearchScope(req.Scope),
+		Offset: req.Offset,
+		Limit:  req.Limit,
+	}
+	if opts.Limit == 0 {
+		opts.Limit = kvstore.DefaultSearchLimit
+	}
+	results, err := e.etcdSvcClt.SearchKeys(c.Request.Context(), req.SearchStr, opts)
 	if err != nil {
 		c.Error(err) //nolint
 		return
 	}
 
-	c.JSON(http.StatusOK, dto.SearchKeysResponse{
-		Keys: resp,
-	})
+	resp := dto.SearchKeysResponse{
+		Keys:               make([]string, 0, len(results.Hits)),
+		Results:            make([]dto.SearchResult, 0, len(results.Hits)),
+		Offset:             opts.Offset,
+		Limit:              opts.Limit,
+		EstimatedTotalHits: results.EstimatedTotalHits,
+	}
+	for _, hit := range results.Hits {
+		resp.Keys = append(resp.Keys, hit.Key)
+		resp.Results = append(resp.Results, dto.SearchResult{
+			Key:           hit.Key,
+			MatchedFields: hit.MatchedFields,
+		})
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (e *EtcdfinderHandler) ListChildren(c *gin.Context) {
+	var req dto.ListChildrenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(fmt.Errorf("invalid request: %w", err)) //nolint
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = etcd.DefaultChildrenLimit
+	}
+	children, err := e.etcdSvcClt.ListChildren(c.Request.Context(), req.Prefix, req.Offset, limit)
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	resp := dto.ListChildrenResponse{
+		Prefix:        etcd.DirPrefix(req.Prefix),
+		Children:      make([]dto.Child, 0, len(children.Children)),
+		Offset:        req.Offset,
+		Limit:         limit,
+		TotalChildren: children.TotalChildren,
+		TotalKeys:     children.TotalKeys,
+	}
+	for _, child := range children.Children {
+		resp.Children = append(resp.Children, dto.Child(child))
+	}
+
+	c.JSON(http.StatusOK, resp)
 }
 
 func (e *EtcdfinderHandler) PutKey(c *gin.Context) {
@@ -78,12 +194,43 @@ func (e *EtcdfinderHandler) PutKey(c *gin.Context) {
 		return
 	}
 
-	if err := e.etcdSvcClt.PutKey(c.Request.Context(), req.Key, req.Value); err != nil {
+	if err := e.etcdSvcClt.PutKey(c.Request.Context(), req.Key, req.Value, precondition(req.Precondition)); err != nil {
 		c.Error(err) //nolint
 		return
 	}
 
-	c.JSON(http.StatusOK, dto.PutKeyResponse(req))
+	c.JSON(http.StatusOK, dto.PutKeyResponse{
+		Key:   req.Key,
+		Value: req.Value,
+	})
+}
+
+func (e *EtcdfinderHandler) RollbackKey(c *gin.Context) {
+	var req dto.RollbackKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(fmt.Errorf("invalid request: %w", err)) //nolint
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	rollback, err := e.etcdSvcClt.RollbackKey(c.Request.Context(), req.Key, req.Revision)
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.RollbackKeyResponse{
+		Key:            req.Key,
+		OldValue:       rollback.OldValue,
+		OldModRevision: rollback.OldModRevision,
+		RestoredValue:  rollback.RestoredValue,
+		RestoredFrom:   rollback.RestoredFrom,
+		Revision:       rollback.Revision,
+	})
 }
 
 func (e *EtcdfinderHandler) DeleteKey(c *gin.Context) {
@@ -98,16 +245,276 @@ func (e *EtcdfinderHandler) DeleteKey(c *gin.Context) {
 		return
 	}
 
-	if err := e.etcdSvcClt.DeleteKey(c.Request.Context(), req.Key); err != nil {
+	if err := e.etcdSvcClt.DeleteKey(c.Request.Context(), req.Key, precondition(req.Precondition)); err != nil {
 		c.Error(err) //nolint
 		return
 	}
 
-	c.JSON(http.StatusOK, dto.DeleteKeyResponse(req))
+	c.JSON(http.StatusOK, dto.DeleteKeyResponse{
+		Key: req.Key,
+	})
+}
+
+func (e *EtcdfinderHandler) DeletePrefix(c *gin.Context) {
+	var req dto.DeletePrefixRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(fmt.Errorf("invalid request: %w", err)) //nolint
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	result, err := e.etcdSvcClt.DeletePrefix(c.Request.Context(), req.Prefix, req.Token)
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	resp := dto.DeletePrefixResponse{
+		Prefix: req.Prefix,
+		DryRun: result.DryRun,
+		Count:  result.Count,
+		Keys:   result.Keys,
+		Token:  result.Token,
+	}
+	if result.Token != "" {
+		resp.ExpiresAt = &result.ExpiresAt
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (e *EtcdfinderHandler) Copy(c *gin.Context) {
+	e.transfer(c, e.etcdSvcClt.Copy)
+}
+
+func (e *EtcdfinderHandler) Move(c *gin.Context) {
+	e.transfer(c, e.etcdSvcClt.Move)
+}
+
+// transfer handles the requests of both Copy and Move, which only differ in the service method they call
+func (e *EtcdfinderHandler) transfer(
+	c *gin.Context,
+	run func(ctx context.Context, source, destination string, prefix bool, policy service.CopyPolicy) (service.Transfer, error)) {
+	var req dto.TransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(fmt.Errorf("invalid request: %w", err)) //nolint
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	policy := service.CopyPolicy(req.Policy)
+	if policy == "" {
+		policy = service.CopySkipExisting
+	}
+	result, err := run(c.Request.Context(), req.Source, req.Destination, req.Prefix, policy)
+	if err != nil && len(result.Keys) == 0 {
+		c.Error(err) //nolint
+		return
+	}
+
+	resp := dto.TransferResponse{
+		Source:         req.Source,
+		Destination:    req.Destination,
+		Succeeded:      result.Succeeded,
+		CopiedNotMoved: result.CopiedNotMoved,
+		Skipped:        result.Skipped,
+		Failed:         result.Failed,
+		Results:        make([]dto.KeyTransfer, 0, len(result.Keys)),
+	}
+	for _, key := range result.Keys {
+		resp.Results = append(resp.Results, dto.KeyTransfer{
+			Source:      key.Source,
+			Destination: key.Destination,
+			Status:      string(key.Status),
+			Error:       key.Error,
+		})
+	}
+	if err != nil {
+		// The keys transferred before the failure are reported with the error
+		c.Error(customerrors.WithDetails(err, map[string]any{"transfer": resp})) //nolint
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Export streams the keys under a prefix as they are read from etcd
+func (e *EtcdfinderHandler) Export(c *gin.Context) {
+	var req dto.ExportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(fmt.Errorf("invalid request: %w", err)) //nolint
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	format := service.ExportFormat(req.Format)
+	if format == "" {
+		format = service.ExportJSON
+	}
+	c.Header("Content-Type", format.ContentType())
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="export.%s"`, format.FileExtension()))
+
+	if err := e.etcdSvcClt.Export(c.Request.Context(), req.Prefix, format, c.Writer); err != nil {
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Type")
+			c.Writer.Header().Del("Content-Disposition")
+			c.Error(err) //nolint
+			return
+		}
+		// The status has been sent with the first keys, the export can only be cut short
+		logger.Errorf("Export of %s failed after it started: %v", req.Prefix, err)
+		c.Abort()
+	}
+}
+
+func (e *EtcdfinderHandler) Txn(c *gin.Context) {
+	var req dto.TxnRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(fmt.Errorf("invalid request: %w", err)) //nolint
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	txn := etcd.Txn{
+		Compares: make([]etcd.Compare, 0, len(req.Compare)),
+		Then:     txnOps(req.Then),
+		Else:     txnOps(req.Else),
+	}
+	for _, cmp := range req.Compare {
+		op := etcd.CompareOp(cmp.Op)
+		if op == "" {
+			op = etcd.CompareEqual
+		}
+		txn.Compares = append(txn.Compares, etcd.Compare{
+			Key:    cmp.Key,
+			Target: etcd.CompareTarget(cmp.Target),
+			Op:     op,
+			Value:  cmp.Value,
+			Number: cmp.Number,
+			Exists: cmp.Exists,
+		})
+	}
+
+	result, err := e.etcdSvcClt.Txn(c.Request.Context(), txn)
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	resp := dto.TxnResponse{
+		Succeeded: result.Succeeded,
+		Revision:  result.Revision,
+		Results:   make([]dto.TxnOpResult, 0, len(result.Results)),
+	}
+	for _, opResult := range result.Results {
+		r := dto.TxnOpResult{
+			Type: string(opResult.Type),
+			Key:  opResult.Key,
+		}
+		if opResult.KV != nil {
+			r.KV = &dto.KeyVersion{
+				Value:          opResult.KV.Value,
+				CreateRevision: opResult.KV.CreateRevision,
+				ModRevision:    opResult.KV.ModRevision,
+				Version:        opResult.KV.Version,
+			}
+		}
+		if opResult.Type == etcd.OpDelete {
+			r.Deleted = &opResult.Deleted
+		}
+		resp.Results = append(resp.Results, r)
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
 func (e *EtcdfinderHandler) GetIngestionDelay(c *gin.Context) {
+	resp, err := e.etcdSvcClt.GetIngestionDelay(c.Request.Context())
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
 	c.JSON(http.StatusOK, dto.GetIngestionDelayResponse{
-		IngestionDelay: e.etcdSvcClt.GetIngestionDelay(c.Request.Context()),
+		IngestionDelay:      resp.DelayMs,
+		RevisionsBehind:     resp.RevisionsBehind,
+		EventsQueued:        resp.EventsQueued,
+		LastAppliedRevision: resp.LastAppliedRevision,
+		LastWatchedRevision: resp.LastWatchedRevision,
+		EtcdRevision:        resp.EtcdRevision,
+		PendingWrites:       resp.PendingWrites,
+		FailedWrites:        resp.FailedWrites,
+		LastWriteError:      resp.LastWriteError,
+		Rebuilding:          resp.Rebuilding,
+	})
+}
+
+func (e *EtcdfinderHandler) GetReconciliationStatus(c *gin.Context) {
+	status := e.etcdSvcClt.GetReconciliationStatus(c.Request.Context())
+
+	resp := dto.GetReconciliationStatusResponse{
+		Runs:              status.Runs,
+		LastRevision:      status.LastRevision,
+		LastRepairedKeys:  status.LastRepairedKeys,
+		TotalRepairedKeys: status.TotalRepairedKeys,
+	}
+	if !status.LastRunAt.IsZero() {
+		resp.LastRunAt = &status.LastRunAt
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (e *EtcdfinderHandler) Reindex(c *gin.Context) {
+	if err := e.etcdSvcClt.Reindex(c.Request.Context()); err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	c.JSON(http.StatusAccepted, dto.ReindexResponse{
+		Status: "started",
 	})
 }
+
+// precondition converts the precondition of a write request, an expected revision of 0 means the key must not exist
+func precondition(req dto.Precondition) etcd.Precondition {
+	var cond etcd.Precondition
+	revision := req.ModRevision
+	if revision == nil {
+		revision = req.PrevIndex
+	}
+	if revision != nil {
+		if *revision == 0 {
+			cond.Absent = true
+		} else {
+			cond.ModRevision = *revision
+		}
+	}
+	cond.PrevValue = req.PrevValue
+	return cond
+}
+
+func txnOps(req []dto.TxnOp) []etcd.Op {
+	ops := make([]etcd.Op, 0, len(req))
+	for _, op := range req {
+		ops = append(ops, etcd.Op{
+			Type:  etcd.OpType(op.Type),
+			Key:   op.Key,
+			Value: op.Value,
+		})
+	}
+	return ops
+}
@@ -23,8 +23,17 @@ type LogConfig struct {
 }
 
 type DatastoreConfig struct {
-	Type        string            `mapstructure:"type"`
-	Meilisearch MeilisearchConfig `mapstructure:"meilisearch"`
+	Type             lib.DatastoreType `mapstructure:"type"`
+	CheckpointPeriod int64             `mapstructure:"checkpoint_period"` // in seconds
+	FlushInterval    int64             `mapstructure:"flush_interval"`    // in milliseconds
+	FlushBatchSize   int64             `mapstructure:"flush_batch_size"`
+	WaitForWrites    bool              `mapstructure:"wait_for_writes"`
+	ReconcilePeriod  int64             `mapstructure:"reconcile_period"` // in seconds, 0 disables it
+	MaxScanKeys      int64             `mapstructure:"max_scan_keys"`
+	Meilisearch      MeilisearchConfig `mapstructure:"meilisearch"`
+	Memory           MemoryConfig      `mapstructure:"memory"`
+	OpenSearch       OpenSearchConfig  `mapstructure:"opensearch"`
+	SQLite           SQLiteConfig      `mapstructure:"sqlite"`
 }
 
 type EtcdConfig struct {
@@ -35,12 +44,34 @@ type EtcdConfig struct {
 	PaginationLimit       int64           `mapstructure:"pagination_limit"`
 	EtcdAuditPeriod       int64           `mapstructure:"etcd_audit_period"` // in seconds
 	MaxWatchRetries       int64           `mapstructure:"max_watch_retries"`
+	MaxHistorySearch      int64           `mapstructure:"max_history_search"` // in revisions, 0 searches back to the compacted revision
+	DemoSeedFile          string          `mapstructure:"demo_seed_file"`     // JSON or YAML file the demo etcd is seeded from
 }
 
 type MeilisearchConfig struct {
 	Host             string `mapstructure:"host"`
 	IndexName        string `mapstructure:"index_name"`
 	MatchingStrategy string `mapstructure:"matching_strategy"`
+	TaskPollInterval int64  `mapstructure:"task_poll_interval"` // in milliseconds
+}
+
+type MemoryConfig struct {
+	Path string `mapstructure:"path"` // file the store is persisted to, empty to keep it in memory only
+}
+
+type OpenSearchConfig struct {
+	Host          string `mapstructure:"host"`
+	IndexName     string `mapstructure:"index_name"`
+	Username      string `mapstructure:"username"`
+	Password      string `mapstructure:"password"`
+	KeyMinGram    int64  `mapstructure:"key_min_gram"`
+	KeyMaxGram    int64  `mapstructure:"key_max_gram"`
+	ValueAnalyzer string `mapstructure:"value_analyzer"`
+	Timeout       int64  `mapstructure:"timeout"` // in seconds
+}
+
+type SQLiteConfig struct {
+	Path string `mapstructure:"path"` // database file, created if it does not exist
 }
 
 func Load(configPath string) (*Config, error) {
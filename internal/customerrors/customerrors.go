@@ -1,9 +1,12 @@
 package customerrors
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+
+	crdberrors "github.com/cockroachdb/errors"
 )
 
 var (
@@ -13,6 +16,24 @@ var (
 	ErrKeyNotFound           = new(ErrKeyNotFoundCode, "key not found")
 	ErrKeyNotPut             = new(ErrKeyNotPutCode, "key not put")
 	ErrKeyNotDeleted         = new(ErrKeyNotDeletedCode, "key not deleted")
+	ErrRevisionCompacted     = new(ErrRevisionCompactedCode, "revision has been compacted")
+	ErrRebuildInProgress     = new(ErrRebuildInProgressCode, "a rebuild of the search index is already in progress")
+	ErrInvalidSearchScope    = new(ErrInvalidSearchScopeCode, "search scope must be one of keys, values or both")
+	ErrInvalidSearchMode     = new(ErrInvalidSearchModeCode, "search mode must be one of fuzzy, prefix, glob or regex")
+	ErrInvalidPagination     = new(ErrInvalidPaginationCode, "offset must not be negative and limit must be between 0 and 1000")
+	ErrInvalidRevision       = new(ErrInvalidRevisionCode, "revision must not be negative nor greater than the current revision")
+	ErrRevisionUnsupported   = new(ErrRevisionUnsupportedCode, "reading past revisions requires etcd v3")
+	ErrKeyModified           = new(ErrKeyModifiedCode, "key has been modified concurrently")
+	ErrInvalidTxn            = new(ErrInvalidTxnCode, "invalid transaction")
+	ErrTxnUnsupported        = new(ErrTxnUnsupportedCode, "transactions require etcd v3")
+	ErrPrefixRequired        = new(ErrPrefixRequiredCode, "prefix is required")
+	ErrInvalidConfirmToken   = new(ErrInvalidConfirmTokenCode, "confirmation token is invalid or has expired, preview the delete again")
+	ErrInvalidDestination    = new(ErrInvalidDestinationCode, "destination is required and must not overlap the source")
+	ErrInvalidCopyPolicy     = new(ErrInvalidCopyPolicyCode, "policy must be one of overwrite or skip_existing")
+	ErrTooManyKeys           = new(ErrTooManyKeysCode, "too many keys under the prefix for a single request")
+	ErrInvalidExportFormat   = new(ErrInvalidExportFormatCode, "export format must be one of json, nested_json, yaml, env or etcdctl")
+	ErrSearchTooBroad        = new(ErrSearchTooBroadCode, "search scans too many keys, narrow it down with a prefix")
+	ErrPrefixOutsideRoot     = new(ErrPrefixOutsideRootCode, "prefix must be under the root prefix")
 )
 
 var statusCodeMap = map[error]int{
@@ -22,6 +43,24 @@ var statusCodeMap = map[error]int{
 	ErrKeyNotFound:           http.StatusNotFound,
 	ErrKeyNotPut:             http.StatusInternalServerError,
 	ErrKeyNotDeleted:         http.StatusInternalServerError,
+	ErrRevisionCompacted:     http.StatusGone,
+	ErrRebuildInProgress:     http.StatusConflict,
+	ErrInvalidSearchScope:    http.StatusBadRequest,
+	ErrInvalidSearchMode:     http.StatusBadRequest,
+	ErrInvalidPagination:     http.StatusBadRequest,
+	ErrInvalidRevision:       http.StatusBadRequest,
+	ErrRevisionUnsupported:   http.StatusNotImplemented,
+	ErrKeyModified:           http.StatusConflict,
+	ErrInvalidTxn:            http.StatusBadRequest,
+	ErrTxnUnsupported:        http.StatusNotImplemented,
+	ErrPrefixRequired:        http.StatusBadRequest,
+	ErrInvalidConfirmToken:   http.StatusBadRequest,
+	ErrInvalidDestination:    http.StatusBadRequest,
+	ErrInvalidCopyPolicy:     http.StatusBadRequest,
+	ErrTooManyKeys:           http.StatusRequestEntityTooLarge,
+	ErrInvalidExportFormat:   http.StatusBadRequest,
+	ErrSearchTooBroad:        http.StatusBadRequest,
+	ErrPrefixOutsideRoot:     http.StatusBadRequest,
 }
 
 const (
@@ -31,6 +70,24 @@ const (
 	ErrKeyNotFoundCode           = "KEY_NOT_FOUND"
 	ErrKeyNotPutCode             = "KEY_NOT_PUT"
 	ErrKeyNotDeletedCode         = "KEY_NOT_DELETED"
+	ErrRevisionCompactedCode     = "REVISION_COMPACTED"
+	ErrRebuildInProgressCode     = "REBUILD_IN_PROGRESS"
+	ErrInvalidSearchScopeCode    = "INVALID_SEARCH_SCOPE"
+	ErrInvalidSearchModeCode     = "INVALID_SEARCH_MODE"
+	ErrInvalidPaginationCode     = "INVALID_PAGINATION"
+	ErrInvalidRevisionCode       = "INVALID_REVISION"
+	ErrRevisionUnsupportedCode   = "REVISION_UNSUPPORTED"
+	ErrKeyModifiedCode           = "KEY_MODIFIED"
+	ErrInvalidTxnCode            = "INVALID_TXN"
+	ErrTxnUnsupportedCode        = "TXN_UNSUPPORTED"
+	ErrPrefixRequiredCode        = "PREFIX_REQUIRED"
+	ErrInvalidConfirmTokenCode   = "INVALID_CONFIRM_TOKEN"
+	ErrInvalidDestinationCode    = "INVALID_DESTINATION"
+	ErrInvalidCopyPolicyCode     = "INVALID_COPY_POLICY"
+	ErrTooManyKeysCode           = "TOO_MANY_KEYS"
+	ErrInvalidExportFormatCode   = "INVALID_EXPORT_FORMAT"
+	ErrSearchTooBroadCode        = "SEARCH_TOO_BROAD"
+	ErrPrefixOutsideRootCode     = "PREFIX_OUTSIDE_ROOT"
 )
 
 // InternalError represents a domain error
@@ -68,3 +125,12 @@ func HTTPStatusFromErr(err error) int {
 	}
 	return http.StatusInternalServerError
 }
+
+// WithDetails attaches details to err, they are returned in the details of the error response
+func WithDetails(err error, details map[string]any) error {
+	data, jsonErr := json.Marshal(details)
+	if jsonErr != nil {
+		return err
+	}
+	return crdberrors.WithSafeDetails(err, "__json__:%s", crdberrors.Safe(string(data)))
+}
@@ -0,0 +1,65 @@
+package ingestor
+
+import (
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+)
+
+// batch coalesces watch events until they are flushed to the KVStore
+// Only the last event of each key is kept, as it fully determines the state of the key
+type batch struct {
+	events          map[string]etcd.WatchEvent
+	size            int       // number of events added since the last flush, including coalesced ones
+	revision        int64     // revision of the last event added
+	firstReceivedAt time.Time // time the first event added was received
+	lastReceivedAt  time.Time // time the last event added was received
+}
+
+func newBatch() *batch {
+	return &batch{
+		events: make(map[string]etcd.WatchEvent),
+	}
+}
+
+func (b *batch) add(event etcd.WatchEvent) {
+	if b.size == 0 {
+		b.firstReceivedAt = event.ReceivedAt
+	}
+	b.events[event.Key] = event
+	b.size++
+	b.revision = event.Revision
+	b.lastReceivedAt = event.ReceivedAt
+}
+
+// keys returns the number of distinct keys in the batch
+func (b *batch) keys() int {
+	return len(b.events)
+}
+
+// split returns the key-value pairs to put and the keys to delete
+func (b *batch) split() ([]common.KV, []string) {
+	puts := make([]common.KV, 0, len(b.events))
+	var deletes []string
+	for _, event := range b.events {
+		switch event.Type {
+		case "PUT":
+			puts = append(puts, common.KV{
+				Key:   event.Key,
+				Value: event.Value,
+			})
+		case "DELETE":
+			deletes = append(deletes, event.Key)
+		}
+	}
+	return puts, deletes
+}
+
+func (b *batch) reset() {
+	clear(b.events)
+	b.size = 0
+	b.revision = 0
+	b.firstReceivedAt = time.Time{}
+	b.lastReceivedAt = time.Time{}
+}
@@ -2,113 +2,337 @@ package ingestor
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
 
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
 	"github.com/etcdfinder/etcdfinder/pkg/etcd"
 	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
 	"github.com/etcdfinder/etcdfinder/pkg/logger"
 )
 
+const (
+	listPageSize       = 1000 // number of documents read from the KVStore in a single List call
+	maxRebuildAttempts = 3    // number of attempts of a rebuild whose snapshot revision gets compacted or whose writes fail
+)
+
 type Base interface {
 	InitKVStore(context.Context) error
 	ChangeUpdater(context.Context) error
-	GetIngestionDelay(context.Context) int
+	GetIngestionDelay(context.Context) (IngestionDelay, error)
+	GetReconciliationStatus(context.Context) ReconciliationStatus
+	RequestRebuild(context.Context) error
+}
+
+// IngestionDelay describes how far the KVStore is behind etcd
+type IngestionDelay struct {
+	DelayMs             int64 // milliseconds since the oldest event not applied yet was received, 0 if there is none
+	RevisionsBehind     int64 // revisions of the changes under the root prefix received from the watch but not applied yet
+	EventsQueued        int   // events received from the watch and waiting to be applied
+	LastAppliedRevision int64
+	LastWatchedRevision int64 // revision of the newest change under the root prefix received from the watch
+	EtcdRevision        int64
+	PendingWrites       int    // writes accepted by the KVStore but not confirmed yet
+	FailedWrites        int64  // writes the KVStore failed to apply since startup
+	LastWriteError      string // error of the last failed write
+	Rebuilding          bool   // whether the KVStore is being rebuilt from etcd
 }
 
 type Ingestor struct {
-	kvStore    kvstore.KVStore
-	etcdClt    etcd.BaseClient
-	watchChan  <-chan etcd.WatchEvent
-	initDoneCh chan struct{}
+	kvStore          kvstore.KVStore
+	etcdClt          etcd.BaseClient
+	watchErrCh       <-chan error
+	cancelWatch      context.CancelFunc // stops the current watch when it is replaced
+	initDoneCh       chan struct{}
+	checkpointPeriod time.Duration
+	flushInterval    time.Duration // maximum time watch events are batched before being written to the KVStore
+	flushBatchSize   int           // number of distinct keys that triggers a flush of the batch
+	reconcilePeriod  time.Duration // period of the anti-entropy reconciliation, 0 if disabled
+	batch            *batch
+	rebuild          *rebuild      // rebuild in progress, nil if there is none
+	rebuildReqCh     chan struct{} // rebuild requests waiting to be picked up by the ingestion goroutine
+	reconciling      *reconcileRun // reconciliation in progress, nil if there is none
+
+	// The fields below are written by the ingestion goroutines and read by GetIngestionDelay
+	mu             sync.RWMutex
+	watchChan      <-chan etcd.WatchEvent
+	lastRevision   int64     // revision of the last event applied to the KVStore
+	pendingSince   time.Time // time the oldest event not applied yet was received, zero if there is none
+	bufferedEvents int       // events dequeued from the watch but not flushed yet
+	reconciliation ReconciliationStatus
+	rebuilding     bool
 }
 
-func NewIngestor(kvStore kvstore.KVStore, etcdClt etcd.BaseClient) Base {
-	return &Ingestor{
-		kvStore:    kvStore,
-		etcdClt:    etcdClt,
-		initDoneCh: make(chan struct{}),
+func NewIngestor(
+	kvStore kvstore.KVStore,
+	etcdClt etcd.BaseClient,
+	checkpointPeriod int64,
+	flushInterval int64,
+	flushBatchSize int64,
+	reconcilePeriod int64) (Base, error) {
+	if checkpointPeriod <= 0 {
+		return nil, fmt.Errorf("checkpointPeriod must be greater than 0")
 	}
+	if flushInterval <= 0 {
+		return nil, fmt.Errorf("flushInterval must be greater than 0")
+	}
+	if flushBatchSize <= 0 {
+		return nil, fmt.Errorf("flushBatchSize must be greater than 0")
+	}
+	if reconcilePeriod < 0 {
+		return nil, fmt.Errorf("reconcilePeriod must not be negative")
+	}
+
+	return &Ingestor{
+		kvStore:          kvStore,
+		etcdClt:          etcdClt,
+		initDoneCh:       make(chan struct{}),
+		checkpointPeriod: time.Duration(checkpointPeriod) * time.Second,
+		flushInterval:    time.Duration(flushInterval) * time.Millisecond,
+		flushBatchSize:   int(flushBatchSize),
+		reconcilePeriod:  time.Duration(reconcilePeriod) * time.Second,
+		batch:            newBatch(),
+		rebuildReqCh:     make(chan struct{}, 1),
+	}, nil
 }
 
 func (i *Ingestor) InitKVStore(ctx context.Context) error {
 	defer close(i.initDoneCh)
-	nextKey := ""
 
-	for {
-		keys, returnedNextKey, err := i.etcdClt.GetKeysWithPagination(ctx, nextKey)
-		if err != nil {
-			return err
+	checkpoint, err := i.kvStore.GetCheckpoint(ctx)
+	if err != nil {
+		return err
+	}
+
+	// If the KVStore already holds a checkpoint, resume the watch right after it
+	// If etcd has compacted that revision in the meantime, the KVStore is rebuilt
+	if checkpoint > 0 {
+		logger.Infof("Resuming ingestion from checkpoint revision %d", checkpoint)
+		i.setApplied(checkpoint, time.Time{})
+		i.watch(ctx, checkpoint+1)
+		return nil
+	}
+
+	// Search keeps serving the previous content of the KVStore, if any, until the rebuild completes
+	return i.startRebuild(ctx, true, 1)
+}
+
+// watch replaces the current watch, if any, with a new one starting at fromRevision
+func (i *Ingestor) watch(ctx context.Context, fromRevision int64) {
+	if i.cancelWatch != nil {
+		i.cancelWatch()
+	}
+	watchCtx, cancel := context.WithCancel(ctx)
+	i.cancelWatch = cancel
+	eventCh, errCh := i.etcdClt.Watch(watchCtx, fromRevision)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.watchChan = eventCh
+	i.watchErrCh = errCh
+}
+
+func (i *Ingestor) setApplied(revision int64, pendingSince time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.lastRevision = revision
+	i.pendingSince = pendingSince
+	i.bufferedEvents = 0
+}
+
+func (i *Ingestor) setBuffered(pendingSince time.Time, bufferedEvents int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.pendingSince = pendingSince
+	i.bufferedEvents = bufferedEvents
+}
+
+// flush writes the batched events to the KVStore, deletes first and then puts
+// Keys are distinct within a batch, so the order between them does not matter
+func (i *Ingestor) flush(ctx context.Context) error {
+	if i.batch.size == 0 {
+		return nil
+	}
+
+	// While a rebuild is in progress, the keys are marked as written before the snapshot can write them
+	if rb := i.rebuild; rb != nil {
+		rb.mu.Lock()
+		defer rb.mu.Unlock()
+		for key := range i.batch.events {
+			rb.touched[key] = struct{}{}
 		}
-		// If no keys returned, we've reached the end
-		if len(keys) == 0 {
-			break
+	}
+	// Likewise, the reconciliation does not repair keys written after the revision it compares against
+	if run := i.reconciling; run != nil {
+		for key := range i.batch.events {
+			run.touched[key] = struct{}{}
 		}
-		// Insert all keys from this page into the KVStore
-		if err := i.kvStore.PutBatch(ctx, keys); err != nil {
+	}
+
+	puts, deletes := i.batch.split()
+	if len(deletes) > 0 {
+		if err := i.kvStore.DeleteBatch(ctx, deletes); err != nil {
 			return err
 		}
-		logger.Debugf("Inserting %d keys into KVStore", len(keys))
-		// Update nextKey for the next iteration
-		nextKey = returnedNextKey
-		// If no nextKey is returned, we've reached the end
-		if nextKey == "" {
-			break
+	}
+	if len(puts) > 0 {
+		if err := i.kvStore.PutBatch(ctx, puts); err != nil {
+			return err
 		}
 	}
+	logger.Debugf("Flushed %d events as %d puts and %d deletes", i.batch.size, len(puts), len(deletes))
+
+	// The next queued event was received after the last one of the batch, so its receive time
+	// is kept as a conservative estimate of the oldest pending event until it is dequeued
+	pendingSince := time.Time{}
+	if len(i.watchChan) > 0 {
+		pendingSince = i.batch.lastReceivedAt
+	}
+	i.setApplied(i.batch.revision, pendingSince)
+	i.batch.reset()
 
 	return nil
 }
 
 func (i *Ingestor) ChangeUpdater(ctx context.Context) error {
-	// Get the watch channel and error channel from etcd
-	eventCh, errCh := i.etcdClt.Watch(ctx)
-	i.watchChan = eventCh
-
-	// Wait for initialization to complete
+	// Wait for initialization to complete, it also starts the watch
 	select {
 	case <-i.initDoneCh:
 	case <-ctx.Done():
 		return ctx.Err()
-	case err, ok := <-errCh:
-		if !ok {
-			return nil
-		}
-		return err
 	}
 
-	// Start a goroutine to listen to watch events
+	ticker := time.NewTicker(i.checkpointPeriod)
+	defer ticker.Stop()
+	flushTicker := time.NewTicker(i.flushInterval)
+	defer flushTicker.Stop()
+	checkpoint := i.lastRevision
+	failedWrites := i.kvStore.WriteStatus().FailedWrites
+
+	// A nil channel never fires, which leaves the reconciliation disabled
+	var reconcileCh <-chan time.Time
+	if i.reconcilePeriod > 0 {
+		reconcileTicker := time.NewTicker(i.reconcilePeriod)
+		defer reconcileTicker.Stop()
+		reconcileCh = reconcileTicker.C
+	}
+
+	// Listen to watch events
 	for {
 		select {
-		case event, ok := <-eventCh:
+		case event, ok := <-i.watchChan:
 			if !ok {
-				// Channel closed, exit
-				return nil
+				// The watch has ended, its error channel tells whether it can be resumed
+				i.mu.Lock()
+				i.watchChan = nil
+				i.mu.Unlock()
+				continue
 			}
 
 			logger.Debugf("Received event %s for key %s", event.Type, event.Key)
-			// Handle the event based on type
-			switch event.Type {
-			case "PUT":
-				// Update the kvstore with the new/updated key-value
-				if err := i.kvStore.Put(ctx, event.Key, event.Value); err != nil {
-					// return as it will lead to inconsistent state
-					return err
-				}
-			case "DELETE":
-				// Remove the key from kvstore
-				if err := i.kvStore.Delete(ctx, event.Key); err != nil {
+			i.batch.add(event)
+			i.setBuffered(i.batch.firstReceivedAt, i.batch.size)
+
+			if i.batch.keys() >= i.flushBatchSize {
+				if err := i.flush(ctx); err != nil {
 					// return as it will lead to inconsistent state
 					return err
 				}
 			}
 
-		case err, ok := <-errCh:
+		case <-flushTicker.C:
+			if err := i.flush(ctx); err != nil {
+				// return as it will lead to inconsistent state
+				return err
+			}
+
+		case err, ok := <-i.watchErrCh:
 			if !ok {
 				// Error channel closed, exit
 				return nil
 			}
+			// The watch cannot be resumed as etcd has compacted the revision it needs,
+			// rebuild the KVStore from the current state of etcd while search keeps serving
+			if errors.Is(err, customerrors.ErrRevisionCompacted) {
+				logger.Warnf("Cannot resume the watch after revision %d, rebuilding the KVStore: %v", i.lastRevision, err)
+				// A rebuild in progress relies on the watch, start over from the latest revision
+				if err := i.abortRebuild(ctx); err != nil {
+					return err
+				}
+				if err := i.startRebuild(ctx, true, 1); err != nil {
+					return err
+				}
+				continue
+			}
 			// Return watch error
 			return err
 
+		case err := <-i.rebuildDone():
+			if err := i.finishRebuild(ctx, err); err != nil {
+				return err
+			}
+			if i.rebuild == nil {
+				checkpoint = i.lastRevision
+				failedWrites = i.kvStore.WriteStatus().FailedWrites
+			}
+
+		case <-i.rebuildReqCh:
+			if i.rebuild != nil {
+				continue
+			}
+			logger.Infof("Rebuilding the KVStore on request")
+			if err := i.startRebuild(ctx, false, 1); err != nil {
+				return err
+			}
+
+		case <-ticker.C:
+			// The checkpoint only moves once the rebuilt KVStore has replaced the live one
+			if i.rebuild != nil {
+				continue
+			}
+
+			// A failed write means the KVStore has drifted from etcd, replaying the write could
+			// overwrite a newer value of the key, so the KVStore is rebuilt from etcd instead
+			if status := i.kvStore.WriteStatus(); status.FailedWrites > failedWrites {
+				logger.Warnf("%d writes to the KVStore failed, rebuilding it: %s", status.FailedWrites-failedWrites, status.LastError)
+				failedWrites = status.FailedWrites
+				if err := i.startRebuild(ctx, false, 1); err != nil {
+					return err
+				}
+				continue
+			}
+
+			// Persist the progress so that a restart can resume from here
+			if i.lastRevision != checkpoint {
+				if err := i.kvStore.SetCheckpoint(ctx, i.lastRevision); err != nil {
+					return err
+				}
+				checkpoint = i.lastRevision
+			}
+
+		case <-reconcileCh:
+			// The rebuild repairs every key anyway
+			if i.rebuild != nil || i.reconciling != nil {
+				continue
+			}
+			if err := i.startReconcile(ctx); err != nil {
+				return err
+			}
+			// Failed writes are repaired by the reconciliation as well
+			failedWrites = i.kvStore.WriteStatus().FailedWrites
+
+		case repair := <-i.reconcileRepairs():
+			if err := i.applyRepair(ctx, repair); err != nil {
+				return err
+			}
+
+		case err := <-i.reconcileDone():
+			if err := i.finishReconcile(err); err != nil {
+				return err
+			}
+
 		case <-ctx.Done():
 			// Context cancelled, exit
 			return ctx.Err()
@@ -117,6 +341,34 @@ func (i *Ingestor) ChangeUpdater(ctx context.Context) error {
 
 }
 
-func (i *Ingestor) GetIngestionDelay(ctx context.Context) int {
-	return len(i.watchChan)
+func (i *Ingestor) GetIngestionDelay(ctx context.Context) (IngestionDelay, error) {
+	etcdRevision, err := i.etcdClt.GetRevision(ctx)
+	if err != nil {
+		return IngestionDelay{}, err
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	delay := IngestionDelay{
+		EventsQueued:        len(i.watchChan) + i.bufferedEvents,
+		LastAppliedRevision: i.lastRevision,
+		LastWatchedRevision: i.etcdClt.LastWatchedRevision(),
+		EtcdRevision:        etcdRevision,
+		Rebuilding:          i.rebuilding,
+	}
+	if !i.pendingSince.IsZero() {
+		delay.DelayMs = time.Since(i.pendingSince).Milliseconds()
+	}
+	// Revisions are cluster-wide, only the ones of changes under the root prefix are waiting to be applied
+	// The watch is restarted after a rebuild, the events it received before are older than the rebuilt store
+	if delay.LastWatchedRevision > i.lastRevision {
+		delay.RevisionsBehind = delay.LastWatchedRevision - i.lastRevision
+	}
+
+	writeStatus := i.kvStore.WriteStatus()
+	delay.PendingWrites = writeStatus.PendingWrites
+	delay.FailedWrites = writeStatus.FailedWrites
+	delay.LastWriteError = writeStatus.LastError
+	return delay, nil
 }
@@ -0,0 +1,289 @@
+package ingestor_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/config"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/ingestor"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.NewLogger(&config.Config{}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// start initializes the KVStore from the fake etcd and runs the ingestion until the test ends
+func start(t *testing.T, client *etcd.FakeClient, store kvstore.KVStore, reconcilePeriod int64) ingestor.Base {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	ing, err := ingestor.NewIngestor(store, client, 1, 10, 100, reconcilePeriod)
+	if err != nil {
+		t.Fatalf("NewIngestor() error = %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- ing.ChangeUpdater(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		// The watch closes its channel once cancelled, which ends the ingestion without an error as well
+		if err := <-errCh; err != nil && !errors.Is(err, context.Canceled) {
+			t.Errorf("ChangeUpdater() error = %v", err)
+		}
+	})
+
+	if err := ing.InitKVStore(ctx); err != nil {
+		t.Fatalf("InitKVStore() error = %v", err)
+	}
+	return ing
+}
+
+func newFakeClient(t *testing.T, kvs ...common.KV) *etcd.FakeClient {
+	t.Helper()
+	client, err := etcd.NewFakeClient(100, "/app/", 2, 60)
+	if err != nil {
+		t.Fatalf("NewFakeClient() error = %v", err)
+	}
+	client.Seed(kvs)
+	return client
+}
+
+func newMemoryStore(t *testing.T) kvstore.KVStore {
+	t.Helper()
+	store, err := kvstore.NewMemoryStore("")
+	if err != nil {
+		t.Fatalf("NewMemoryStore() error = %v", err)
+	}
+	return store
+}
+
+// eventually fails the test if the KVStore does not hold exactly want within a few seconds
+func eventually(t *testing.T, store kvstore.KVStore, want map[string]string) {
+	t.Helper()
+	var got map[string]string
+	for range 100 {
+		kvs, _, err := store.List(context.Background(), "", 1000)
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		got = make(map[string]string, len(kvs))
+		for _, kv := range kvs {
+			got[kv.Key] = kv.Value
+		}
+		if equal(got, want) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("KVStore holds %v, want %v", got, want)
+}
+
+func equal(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+func TestIngestorSyncAndWatch(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(t,
+		common.KV{Key: "/app/a", Value: "1"},
+		common.KV{Key: "/app/b", Value: "2"},
+		common.KV{Key: "/app/c", Value: "3"},
+		common.KV{Key: "/other/x", Value: "outside the prefix"},
+	)
+	store := newMemoryStore(t)
+	start(t, client, store, 0)
+	eventually(t, store, map[string]string{"/app/a": "1", "/app/b": "2", "/app/c": "3"})
+
+	if _, err := client.Put(ctx, "/app/a", "updated"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Delete(ctx, "/app/b"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, store, map[string]string{"/app/a": "updated", "/app/c": "3"})
+
+	// The checkpoint catches up with etcd once the events are applied
+	revision, _ := client.GetRevision(ctx)
+	for range 100 {
+		if checkpoint, _ := store.GetCheckpoint(ctx); checkpoint == revision {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	checkpoint, _ := store.GetCheckpoint(ctx)
+	t.Fatalf("checkpoint = %d, want %d", checkpoint, revision)
+}
+
+func TestIngestorDelayIgnoresWritesOutsidePrefix(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(t, common.KV{Key: "/app/a", Value: "1"})
+	store := newMemoryStore(t)
+	ing := start(t, client, store, 0)
+	eventually(t, store, map[string]string{"/app/a": "1"})
+
+	if _, err := client.Put(ctx, "/app/a", "2"); err != nil {
+		t.Fatal(err)
+	}
+	revision, _ := client.GetRevision(ctx)
+	for range 3 {
+		if _, err := client.Put(ctx, "/other/x", "outside the prefix"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var delay ingestor.IngestionDelay
+	for range 100 {
+		var err error
+		delay, err = ing.GetIngestionDelay(ctx)
+		if err != nil {
+			t.Fatalf("GetIngestionDelay() error = %v", err)
+		}
+		if delay.LastAppliedRevision == revision {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if delay.LastAppliedRevision != revision || delay.LastWatchedRevision != revision || delay.RevisionsBehind != 0 || delay.EtcdRevision != revision+3 {
+		t.Fatalf("GetIngestionDelay() = %+v, want revision %d applied and no revision behind", delay, revision)
+	}
+}
+
+func TestIngestorAppliesTxn(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(t, common.KV{Key: "/app/flag", Value: "off"})
+	store := newMemoryStore(t)
+	start(t, client, store, 0)
+	eventually(t, store, map[string]string{"/app/flag": "off"})
+
+	// The events of a transaction share its revision
+	_, err := client.Txn(ctx, etcd.Txn{Then: []etcd.Op{
+		{Type: etcd.OpPut, Key: "/app/flag", Value: "on"},
+		{Type: etcd.OpPut, Key: "/app/rollout", Value: "50"},
+		{Type: etcd.OpPut, Key: "/app/owner", Value: "team-a"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, store, map[string]string{"/app/flag": "on", "/app/rollout": "50", "/app/owner": "team-a"})
+}
+
+func TestIngestorRebuildsAfterCompaction(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(t, common.KV{Key: "/app/a", Value: "1"})
+	store := newMemoryStore(t)
+	if err := store.Put(ctx, "/app/stale", "deleted while etcdfinder was down"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetCheckpoint(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The changes after the checkpoint are compacted before the ingestion resumes
+	if _, err := client.Put(ctx, "/app/b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	client.SkipRevisions(3)
+	client.Compact(4)
+
+	ing := start(t, client, store, 0)
+	eventually(t, store, map[string]string{"/app/a": "1", "/app/b": "2"})
+	for range 100 {
+		if delay, err := ing.GetIngestionDelay(ctx); err == nil && !delay.Rebuilding {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("the rebuild did not complete")
+}
+
+func TestIngestorReconcileRepairsDroppedEvents(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(t, common.KV{Key: "/app/a", Value: "1"})
+	store := newMemoryStore(t)
+	ing := start(t, client, store, 1)
+	eventually(t, store, map[string]string{"/app/a": "1"})
+
+	client.DropEvents(1)
+	if _, err := client.Put(ctx, "/app/a", "missed"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Put(ctx, "/app/b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, store, map[string]string{"/app/a": "missed", "/app/b": "2"})
+	if status := ing.GetReconciliationStatus(ctx); status.TotalRepairedKeys == 0 {
+		t.Fatalf("reconciliation status = %+v, want a repaired key", status)
+	}
+}
+
+// skippingStore is a KVStore whose listing misses a key, as a listing by offset does when a concurrent delete shifts its pages
+type skippingStore struct {
+	kvstore.KVStore
+	skipped string
+}
+
+func (s *skippingStore) List(ctx context.Context, cursor string, limit int64) ([]common.KV, string, error) {
+	kvs, next, err := s.KVStore.List(ctx, cursor, limit)
+	return slices.DeleteFunc(kvs, func(kv common.KV) bool { return kv.Key == s.skipped }), next, err
+}
+
+func TestIngestorReconcileIgnoresSkippedKeys(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(t, common.KV{Key: "/app/a", Value: "1"}, common.KV{Key: "/app/b", Value: "2"})
+	store := &skippingStore{KVStore: newMemoryStore(t), skipped: "/app/a"}
+	ing := start(t, client, store, 1)
+
+	for range 100 {
+		if status := ing.GetReconciliationStatus(ctx); status.Runs > 0 {
+			if status.TotalRepairedKeys != 0 {
+				t.Fatalf("reconciliation status = %+v, want the key missed by the listing not repaired", status)
+			}
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("reconciliation did not run")
+}
+
+func TestIngestorRequestRebuild(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(t, common.KV{Key: "/app/a", Value: "1"})
+	store := newMemoryStore(t)
+	ing := start(t, client, store, 0)
+	eventually(t, store, map[string]string{"/app/a": "1"})
+
+	// A key only the KVStore knows about disappears with the rebuild
+	if err := store.Put(ctx, "/app/stale", "not in etcd"); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		err := ing.RequestRebuild(ctx)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, customerrors.ErrRebuildInProgress) {
+			t.Fatalf("RequestRebuild() error = %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	eventually(t, store, map[string]string{"/app/a": "1"})
+}
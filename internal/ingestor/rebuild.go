@@ -0,0 +1,205 @@
+package ingestor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+)
+
+// rebuild is a rebuild of the KVStore in progress
+// A snapshot of etcd is written to the copy being rebuilt in the background, while the watch
+// keeps applying events to both the live store and the copy
+type rebuild struct {
+	revision     int64 // etcd revision the snapshot is read at
+	attempt      int
+	failedWrites int64 // failed KVStore writes when the rebuild started
+	cancel       context.CancelFunc
+	doneCh       chan error // receives the result of the snapshot
+
+	// touched holds the keys written by the watch since the rebuild started, the snapshot
+	// skips them as their snapshot value is older than the one already written to the copy
+	mu      sync.Mutex
+	touched map[string]struct{}
+}
+
+// startRebuild starts rebuilding the KVStore from a snapshot of etcd
+// If restartWatch is set, the snapshot is read at the latest revision and the watch is restarted right after it,
+// otherwise the snapshot is read at the revision of the last applied event and the watch goes on
+func (i *Ingestor) startRebuild(ctx context.Context, restartWatch bool, attempt int) error {
+	// The rebuild repairs every key anyway
+	i.abortReconcile()
+
+	var revision int64
+	if restartWatch {
+		var err error
+		revision, err = i.etcdClt.GetRevision(ctx)
+		if err != nil {
+			return err
+		}
+		// The batched events are older than the snapshot
+		i.batch.reset()
+		i.setApplied(revision, time.Time{})
+		i.watch(ctx, revision+1)
+	} else {
+		if err := i.flush(ctx); err != nil {
+			return err
+		}
+		revision = i.lastRevision
+	}
+
+	if err := i.kvStore.BeginRebuild(ctx); err != nil {
+		return err
+	}
+
+	snapshotCtx, cancel := context.WithCancel(ctx)
+	rb := &rebuild{
+		revision:     revision,
+		attempt:      attempt,
+		failedWrites: i.kvStore.WriteStatus().FailedWrites,
+		cancel:       cancel,
+		doneCh:       make(chan error, 1),
+		touched:      make(map[string]struct{}),
+	}
+	i.rebuild = rb
+	i.setRebuilding(true)
+	logger.Infof("Rebuild attempt #%d started at revision %d", attempt, revision)
+
+	go func() {
+		rb.doneCh <- i.writeSnapshot(snapshotCtx, rb)
+	}()
+
+	return nil
+}
+
+// writeSnapshot writes every key of etcd at the revision of the rebuild into the copy being rebuilt
+func (i *Ingestor) writeSnapshot(ctx context.Context, rb *rebuild) error {
+	nextKey := ""
+	revision := rb.revision
+
+	for {
+		// etcd v2 reads the latest index whatever the revision, the next pages are read at the one returned
+		keys, returnedNextKey, readRevision, err := i.etcdClt.GetKeysWithPagination(ctx, "", nextKey, revision)
+		if err != nil {
+			return err
+		}
+		revision = readRevision
+		if len(keys) == 0 {
+			break
+		}
+
+		// The lock is held while the batch is enqueued, so a key is never written by the snapshot
+		// after the watch has written a newer value of it
+		rb.mu.Lock()
+		kvs := make([]common.KV, 0, len(keys))
+		for _, kv := range keys {
+			if _, ok := rb.touched[kv.Key]; !ok {
+				kvs = append(kvs, kv)
+			}
+		}
+		if len(kvs) > 0 {
+			err = i.kvStore.PutRebuildBatch(ctx, kvs)
+		}
+		rb.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		logger.Debugf("Inserting %d keys into the rebuilt KVStore", len(kvs))
+
+		nextKey = returnedNextKey
+		if nextKey == "" {
+			break
+		}
+	}
+
+	return nil
+}
+
+// finishRebuild commits the rebuild once its snapshot has been written, or retries it if it failed
+func (i *Ingestor) finishRebuild(ctx context.Context, snapshotErr error) error {
+	rb := i.rebuild
+
+	err := snapshotErr
+	if err == nil {
+		// The writes enqueued for the copy must all have been applied before it replaces the live store
+		if err := i.flush(ctx); err != nil {
+			return err
+		}
+		if waitErr := i.kvStore.WaitForWrites(ctx); waitErr != nil {
+			logger.Warnf("A write to the KVStore failed during the rebuild: %v", waitErr)
+		}
+		if status := i.kvStore.WriteStatus(); status.FailedWrites > rb.failedWrites {
+			err = errors.New("writes to the KVStore failed during the rebuild: " + status.LastError)
+		}
+	}
+
+	if err != nil {
+		if abortErr := i.abortRebuild(ctx); abortErr != nil {
+			return abortErr
+		}
+		// The pinned revision can get compacted while a large keyspace is being read
+		retryable := snapshotErr == nil || errors.Is(err, customerrors.ErrRevisionCompacted)
+		if retryable && rb.attempt < maxRebuildAttempts {
+			logger.Warnf("Rebuild attempt #%d failed, retrying from the latest revision: %v", rb.attempt, err)
+			return i.startRebuild(ctx, true, rb.attempt+1)
+		}
+		return err
+	}
+
+	if err := i.kvStore.CommitRebuild(ctx); err != nil {
+		return err
+	}
+	i.rebuild = nil
+	i.setRebuilding(false)
+	logger.Infof("Rebuild completed at revision %d", rb.revision)
+
+	return i.kvStore.SetCheckpoint(ctx, i.lastRevision)
+}
+
+// abortRebuild stops the rebuild in progress, if any, and discards the copy being rebuilt
+func (i *Ingestor) abortRebuild(ctx context.Context) error {
+	rb := i.rebuild
+	if rb == nil {
+		return nil
+	}
+	rb.cancel()
+	i.rebuild = nil
+	i.setRebuilding(false)
+	return i.kvStore.AbortRebuild(ctx)
+}
+
+// rebuildDone returns the channel receiving the result of the rebuild snapshot, nil if there is no rebuild
+func (i *Ingestor) rebuildDone() <-chan error {
+	if i.rebuild == nil {
+		return nil
+	}
+	return i.rebuild.doneCh
+}
+
+// RequestRebuild asks the ingestion goroutine to rebuild the KVStore from etcd
+func (i *Ingestor) RequestRebuild(ctx context.Context) error {
+	i.mu.RLock()
+	rebuilding := i.rebuilding
+	i.mu.RUnlock()
+	if rebuilding {
+		return customerrors.ErrRebuildInProgress
+	}
+
+	select {
+	case i.rebuildReqCh <- struct{}{}:
+		return nil
+	default:
+		// A request is already waiting to be picked up
+		return customerrors.ErrRebuildInProgress
+	}
+}
+
+func (i *Ingestor) setRebuilding(rebuilding bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.rebuilding = rebuilding
+}
@@ -0,0 +1,257 @@
+package ingestor
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+)
+
+// ReconciliationStatus describes the runs of the anti-entropy reconciliation
+type ReconciliationStatus struct {
+	Runs              int64     // number of completed runs since startup
+	LastRunAt         time.Time // time the last run completed, zero if there is none
+	LastRevision      int64     // etcd revision the last run compared the KVStore against
+	LastRepairedKeys  int       // keys repaired by the last run
+	TotalRepairedKeys int64     // keys repaired since startup
+}
+
+// reconcileRun is a reconciliation in progress
+// The KVStore is compared against etcd in the background, while the watch keeps applying events
+// and the ingestion goroutine applies the repairs found
+type reconcileRun struct {
+	revision int64 // etcd revision the KVStore is compared against
+	repaired int   // keys repaired so far
+	cancel   context.CancelFunc
+	repairCh chan reconcileRepair // receives the differences found, page by page
+	doneCh   chan error           // receives the result of the comparison once every repair has been received
+
+	// touched holds the keys written by the watch since the run started, their value at the
+	// revision of the run is older than the one already written, so they are not repaired
+	touched map[string]struct{}
+}
+
+// reconcileRepair is a set of differences between the KVStore and etcd at the revision of the run
+type reconcileRepair struct {
+	puts    []common.KV // keys missing from the KVStore or holding another value
+	deletes []string    // keys of the KVStore missing from etcd
+}
+
+// startReconcile starts comparing every key of the KVStore against etcd at the revision of the last applied event
+func (i *Ingestor) startReconcile(ctx context.Context) error {
+	if err := i.flush(ctx); err != nil {
+		return err
+	}
+	// A failed write is fine here, it is exactly what the reconciliation repairs
+	if err := i.kvStore.WaitForWrites(ctx); err != nil {
+		logger.Warnf("Reconciliation found a failed write to the KVStore: %v", err)
+	}
+
+	compareCtx, cancel := context.WithCancel(ctx)
+	run := &reconcileRun{
+		revision: i.lastRevision,
+		cancel:   cancel,
+		repairCh: make(chan reconcileRepair),
+		doneCh:   make(chan error, 1),
+		touched:  make(map[string]struct{}),
+	}
+	i.reconciling = run
+
+	go func() {
+		run.doneCh <- i.compare(compareCtx, run)
+	}()
+
+	return nil
+}
+
+// compare walks etcd at the revision of the run and sends the differences with the KVStore to the ingestion goroutine
+func (i *Ingestor) compare(ctx context.Context, run *reconcileRun) error {
+	stored, err := i.hashStoredKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	nextKey := ""
+	revision := run.revision
+	for {
+		// etcd v2 reads the latest index whatever the revision, the next pages are read at the one returned
+		keys, returnedNextKey, readRevision, err := i.etcdClt.GetKeysWithPagination(ctx, "", nextKey, revision)
+		if err != nil {
+			return err
+		}
+		revision = readRevision
+		if len(keys) == 0 {
+			break
+		}
+
+		var repair reconcileRepair
+		for _, kv := range keys {
+			hash, ok := stored[kv.Key]
+			if !ok || hash != xxhash.Sum64String(kv.Value) {
+				repair.puts = append(repair.puts, kv)
+			}
+			delete(stored, kv.Key)
+		}
+		if err := run.send(ctx, repair); err != nil {
+			return err
+		}
+
+		nextKey = returnedNextKey
+		if nextKey == "" {
+			break
+		}
+	}
+
+	// The keys left were not found in etcd
+	var repair reconcileRepair
+	for key := range stored {
+		repair.deletes = append(repair.deletes, key)
+	}
+	return run.send(ctx, repair)
+}
+
+// send hands the repair over to the ingestion goroutine, unless it is empty
+func (run *reconcileRun) send(ctx context.Context, repair reconcileRepair) error {
+	if len(repair.puts) == 0 && len(repair.deletes) == 0 {
+		return nil
+	}
+	select {
+	case run.repairCh <- repair:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// applyRepair writes the repair to the KVStore, except for the keys the watch has written or batched since the run started
+// and the keys the KVStore already holds, which its listing skipped
+func (i *Ingestor) applyRepair(ctx context.Context, repair reconcileRepair) error {
+	run := i.reconciling
+	written := func(key string) bool {
+		_, touched := run.touched[key]
+		_, batched := i.batch.events[key]
+		return touched || batched
+	}
+
+	var puts []common.KV
+	for _, kv := range repair.puts {
+		if written(kv.Key) {
+			continue
+		}
+		// Meilisearch lists by offset, a key shifted to an earlier page by a concurrent delete is missed
+		if value, err := i.kvStore.Get(ctx, kv.Key); err == nil && value == kv.Value {
+			continue
+		}
+		logger.Debugf("Reconciliation repairing key %s", kv.Key)
+		puts = append(puts, kv)
+	}
+	var deletes []string
+	for _, key := range repair.deletes {
+		if !written(key) {
+			logger.Debugf("Reconciliation deleting key %s", key)
+			deletes = append(deletes, key)
+		}
+	}
+
+	if len(puts) > 0 {
+		if err := i.kvStore.PutBatch(ctx, puts); err != nil {
+			return err
+		}
+	}
+	if len(deletes) > 0 {
+		if err := i.kvStore.DeleteBatch(ctx, deletes); err != nil {
+			return err
+		}
+	}
+	run.repaired += len(puts) + len(deletes)
+	return nil
+}
+
+// finishReconcile records the outcome of the reconciliation once the comparison has ended
+func (i *Ingestor) finishReconcile(compareErr error) error {
+	run := i.reconciling
+	run.cancel()
+	i.reconciling = nil
+
+	if compareErr != nil {
+		// The revision is only compacted if ingestion is lagging far behind, try again on the next run
+		if errors.Is(compareErr, customerrors.ErrRevisionCompacted) {
+			logger.Warnf("Skipping reconciliation, revision %d has been compacted", run.revision)
+			return nil
+		}
+		return compareErr
+	}
+
+	if run.repaired > 0 {
+		logger.Warnf("Reconciliation at revision %d repaired %d keys", run.revision, run.repaired)
+	} else {
+		logger.Infof("Reconciliation at revision %d found no difference", run.revision)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.reconciliation.Runs++
+	i.reconciliation.LastRunAt = time.Now()
+	i.reconciliation.LastRevision = run.revision
+	i.reconciliation.LastRepairedKeys = run.repaired
+	i.reconciliation.TotalRepairedKeys += int64(run.repaired)
+
+	return nil
+}
+
+// abortReconcile stops the reconciliation in progress, if any
+func (i *Ingestor) abortReconcile() {
+	if i.reconciling == nil {
+		return
+	}
+	i.reconciling.cancel()
+	i.reconciling = nil
+}
+
+// reconcileRepairs returns the channel receiving the repairs of the reconciliation, nil if there is none
+func (i *Ingestor) reconcileRepairs() <-chan reconcileRepair {
+	if i.reconciling == nil {
+		return nil
+	}
+	return i.reconciling.repairCh
+}
+
+// reconcileDone returns the channel receiving the result of the reconciliation, nil if there is none
+func (i *Ingestor) reconcileDone() <-chan error {
+	if i.reconciling == nil {
+		return nil
+	}
+	return i.reconciling.doneCh
+}
+
+// hashStoredKeys returns the hash of the value of every key of the KVStore
+func (i *Ingestor) hashStoredKeys(ctx context.Context) (map[string]uint64, error) {
+	stored := make(map[string]uint64)
+	cursor := ""
+
+	for {
+		kvs, next, err := i.kvStore.List(ctx, cursor, listPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range kvs {
+			stored[kv.Key] = xxhash.Sum64String(kv.Value)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	return stored, nil
+}
+
+func (i *Ingestor) GetReconciliationStatus(ctx context.Context) ReconciliationStatus {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.reconciliation
+}
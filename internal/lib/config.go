@@ -12,4 +12,15 @@ type EtcdVersion string
 const (
 	ETCD_V2 EtcdVersion = "v2"
 	ETCD_V3 EtcdVersion = "v3"
+	// in-memory etcd seeded from a file, no cluster is needed
+	ETCD_DEMO EtcdVersion = "demo"
+)
+
+type DatastoreType string
+
+const (
+	DATASTORE_MEILISEARCH DatastoreType = "meilisearch"
+	DATASTORE_MEMORY      DatastoreType = "memory"
+	DATASTORE_OPENSEARCH  DatastoreType = "opensearch" // OpenSearch or Elasticsearch
+	DATASTORE_SQLITE      DatastoreType = "sqlite"
 )
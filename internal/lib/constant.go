@@ -5,4 +5,6 @@ const (
 	KEY_CONSTANT             = "key"
 	VALUE_CONSTANT           = "value"
 	ID_CONSTANT              = "id"
+	REVISION_CONSTANT        = "revision"
+	DIRS_CONSTANT            = "dirs"
 )
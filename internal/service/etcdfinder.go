@@ -2,67 +2,277 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"time"
 
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
 	"github.com/etcdfinder/etcdfinder/internal/ingestor"
 	"github.com/etcdfinder/etcdfinder/pkg/etcd"
 	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
 )
 
 type Etcdfinder interface {
-	GetKey(ctx context.Context, key string) (string, error)
-	SearchKeys(ctx context.Context, searchStr string) ([]string, error)
-	PutKey(ctx context.Context, key string, value string) error
-	DeleteKey(ctx context.Context, key string) error
-	GetIngestionDelay(ctx context.Context) int
+	GetKey(ctx context.Context, key string, revision int64) (etcd.KeyValue, error)
+	GetKeyHistory(ctx context.Context, key string, revision, limit int64) (etcd.KeyHistory, error)
+	SearchKeys(ctx context.Context, searchStr string, opts kvstore.SearchOptions) (kvstore.SearchResults, error)
+	ListChildren(ctx context.Context, prefix string, offset, limit int64) (etcd.Children, error)
+	PutKey(ctx context.Context, key string, value string, cond etcd.Precondition) error
+	RollbackKey(ctx context.Context, key string, revision int64) (Rollback, error)
+	DeleteKey(ctx context.Context, key string, cond etcd.Precondition) error
+	DeletePrefix(ctx context.Context, prefix string, token string) (PrefixDelete, error)
+	Copy(ctx context.Context, source, destination string, prefix bool, policy CopyPolicy) (Transfer, error)
+	Move(ctx context.Context, source, destination string, prefix bool, policy CopyPolicy) (Transfer, error)
+	Export(ctx context.Context, prefix string, format ExportFormat, w io.Writer) error
+	Txn(ctx context.Context, txn etcd.Txn) (etcd.TxnResult, error)
+	GetIngestionDelay(ctx context.Context) (ingestor.IngestionDelay, error)
+	GetReconciliationStatus(ctx context.Context) ingestor.ReconciliationStatus
+	Reindex(ctx context.Context) error
+}
+
+// Rollback describes a key restored to the value it had at a past revision
+type Rollback struct {
+	OldValue       string // value before the rollback, empty if the key did not exist
+	OldModRevision int64  // mod revision before the rollback, 0 if the key did not exist
+	RestoredValue  string
+	RestoredFrom   int64 // mod revision the restored value was written at
+	Revision       int64 // revision of the rollback write
+}
+
+const (
+	deletePreviewSampleSize = 20              // number of keys listed by the preview of a prefix delete
+	deleteTokenTTL          = 2 * time.Minute // time a prefix delete can be confirmed after its preview
+)
+
+// PrefixDelete is either the preview of a prefix delete or its outcome
+type PrefixDelete struct {
+	DryRun    bool
+	Count     int64    // keys under the prefix for a preview, keys deleted otherwise
+	Keys      []string // first keys under the prefix for a preview, keys deleted otherwise
+	Token     string   // token confirming the delete, empty if there is nothing to delete
+	ExpiresAt time.Time
+}
+
+// deleteToken is a confirmation token issued by the preview of a prefix delete
+type deleteToken struct {
+	prefix    string
+	revision  int64 // revision of the preview, the delete fails if a key has changed since
+	expiresAt time.Time
 }
 
 type DefaultEtcdfinder struct {
-	etcdClt     etcd.BaseClient
-	kvStore     kvstore.KVStore
-	ingestorClt ingestor.Base
+	etcdClt       etcd.BaseClient
+	kvStore       kvstore.KVStore
+	ingestorClt   ingestor.Base
+	waitForWrites bool  // block PutKey and DeleteKey until the write is visible in searches
+	maxScanKeys   int64 // keys an exact search evaluated by a scan reads at most
+
+	tokensMu     sync.Mutex
+	deleteTokens map[string]deleteToken
 }
 
-func NewDefaultEtcdfinder(etcdClt etcd.BaseClient, kvStore kvstore.KVStore, ingestorClt ingestor.Base) Etcdfinder {
+func NewDefaultEtcdfinder(etcdClt etcd.BaseClient, kvStore kvstore.KVStore, ingestorClt ingestor.Base, waitForWrites bool, maxScanKeys int64) Etcdfinder {
 	return &DefaultEtcdfinder{
-		etcdClt:     etcdClt,
-		kvStore:     kvStore,
-		ingestorClt: ingestorClt,
+		etcdClt:       etcdClt,
+		kvStore:       kvStore,
+		ingestorClt:   ingestorClt,
+		waitForWrites: waitForWrites,
+		maxScanKeys:   maxScanKeys,
+		deleteTokens:  make(map[string]deleteToken),
 	}
 }
 
-func (d *DefaultEtcdfinder) GetKey(ctx context.Context, key string) (string, error) {
+func (d *DefaultEtcdfinder) GetKey(ctx context.Context, key string, revision int64) (etcd.KeyValue, error) {
 	// Always Read from kvStore
-	return d.etcdClt.Get(ctx, key)
+	return d.etcdClt.Get(ctx, key, revision)
 }
 
-func (d *DefaultEtcdfinder) SearchKeys(ctx context.Context, searchStr string) ([]string, error) {
-	var keys []string
-	kvs, err := d.kvStore.Search(ctx, searchStr)
-	if err != nil {
-		return nil, err
+func (d *DefaultEtcdfinder) GetKeyHistory(ctx context.Context, key string, revision, limit int64) (etcd.KeyHistory, error) {
+	return d.etcdClt.GetHistory(ctx, key, revision, limit)
+}
+
+func (d *DefaultEtcdfinder) SearchKeys(ctx context.Context, searchStr string, opts kvstore.SearchOptions) (kvstore.SearchResults, error) {
+	if opts.Mode == "" {
+		opts.Mode = kvstore.SearchModeFuzzy
 	}
-	for _, kv := range kvs {
-		keys = append(keys, kv.Key)
+	if opts.Scope == "" {
+		opts.Scope = kvstore.SearchScopeKeys
 	}
-	return keys, nil
+	opts.MaxScanKeys = d.maxScanKeys
+	return d.kvStore.Search(ctx, searchStr, opts)
 }
 
-func (d *DefaultEtcdfinder) PutKey(ctx context.Context, key string, value string) error {
-	key, err := d.etcdClt.Put(ctx, key, value)
+func (d *DefaultEtcdfinder) ListChildren(ctx context.Context, prefix string, offset, limit int64) (etcd.Children, error) {
+	// Browse etcd directly, so the tree is never behind
+	return d.etcdClt.ListChildren(ctx, prefix, offset, limit)
+}
+
+// PutKey puts the key, if cond is set only while the key still satisfies it so that concurrent edits are not overwritten
+func (d *DefaultEtcdfinder) PutKey(ctx context.Context, key string, value string, cond etcd.Precondition) error {
+	var err error
+	if cond.IsZero() {
+		key, err = d.etcdClt.Put(ctx, key, value)
+	} else {
+		_, err = d.etcdClt.CompareAndPut(ctx, key, value, cond)
+	}
 	if err != nil {
 		return err
 	}
-	return d.kvStore.Put(ctx, key, value)
+	if err := d.kvStore.Put(ctx, key, value); err != nil {
+		return err
+	}
+	if d.waitForWrites {
+		return d.kvStore.WaitForWrites(ctx)
+	}
+	return nil
+}
+
+func (d *DefaultEtcdfinder) RollbackKey(ctx context.Context, key string, revision int64) (Rollback, error) {
+	target, err := d.etcdClt.Get(ctx, key, revision)
+	if err != nil {
+		return Rollback{}, err
+	}
+	// A deleted key is restored as well
+	current, err := d.etcdClt.Get(ctx, key, 0)
+	if err != nil && !errors.Is(err, customerrors.ErrKeyNotFound) {
+		return Rollback{}, err
+	}
+
+	// The write fails if the key changes after it was read, so a concurrent edit is never overwritten
+	cond := etcd.Precondition{ModRevision: current.ModRevision}
+	if current.ModRevision == 0 {
+		cond = etcd.Precondition{Absent: true}
+	}
+	newRevision, err := d.etcdClt.CompareAndPut(ctx, key, target.Value, cond)
+	if err != nil {
+		return Rollback{}, err
+	}
+	if err := d.kvStore.Put(ctx, key, target.Value); err != nil {
+		return Rollback{}, err
+	}
+	if d.waitForWrites {
+		if err := d.kvStore.WaitForWrites(ctx); err != nil {
+			return Rollback{}, err
+		}
+	}
+
+	return Rollback{
+		OldValue:       current.Value,
+		OldModRevision: current.ModRevision,
+		RestoredValue:  target.Value,
+		RestoredFrom:   target.ModRevision,
+		Revision:       newRevision,
+	}, nil
 }
 
-func (d *DefaultEtcdfinder) DeleteKey(ctx context.Context, key string) error {
-	key, err := d.etcdClt.Delete(ctx, key)
+// DeleteKey deletes the key, if cond is set only while the key still satisfies it
+func (d *DefaultEtcdfinder) DeleteKey(ctx context.Context, key string, cond etcd.Precondition) error {
+	var err error
+	if cond.IsZero() {
+		key, err = d.etcdClt.Delete(ctx, key)
+	} else {
+		_, err = d.etcdClt.CompareAndDelete(ctx, key, cond)
+	}
 	if err != nil {
 		return err
 	}
-	return d.kvStore.Delete(ctx, key)
+	if err := d.kvStore.Delete(ctx, key); err != nil {
+		return err
+	}
+	if d.waitForWrites {
+		return d.kvStore.WaitForWrites(ctx)
+	}
+	return nil
+}
+
+// DeletePrefix previews the delete of every key under prefix when no token is given, and deletes them
+// when given the token of a preview of the same prefix
+// A token can be used once, and the delete fails with customerrors.ErrKeyModified if a key has changed since the preview
+// The prefix names a directory, a trailing separator is added if it has none
+func (d *DefaultEtcdfinder) DeletePrefix(ctx context.Context, prefix string, token string) (PrefixDelete, error) {
+	prefix = etcd.DirPrefix(prefix)
+	if token == "" {
+		return d.previewPrefixDelete(ctx, prefix)
+	}
+
+	d.tokensMu.Lock()
+	issued, ok := d.deleteTokens[token]
+	delete(d.deleteTokens, token)
+	d.tokensMu.Unlock()
+	if !ok || issued.prefix != prefix || time.Now().After(issued.expiresAt) {
+		return PrefixDelete{}, customerrors.ErrInvalidConfirmToken
+	}
+
+	keys, err := d.etcdClt.DeletePrefix(ctx, prefix, issued.revision)
+	if err != nil {
+		return PrefixDelete{}, err
+	}
+	if len(keys) > 0 {
+		if err := d.kvStore.DeleteBatch(ctx, keys); err != nil {
+			return PrefixDelete{}, err
+		}
+	}
+	if d.waitForWrites {
+		if err := d.kvStore.WaitForWrites(ctx); err != nil {
+			return PrefixDelete{}, err
+		}
+	}
+	return PrefixDelete{
+		Count: int64(len(keys)),
+		Keys:  keys,
+	}, nil
+}
+
+// previewPrefixDelete lists the keys under prefix and issues the token confirming their delete
+func (d *DefaultEtcdfinder) previewPrefixDelete(ctx context.Context, prefix string) (PrefixDelete, error) {
+	preview, err := d.etcdClt.PreviewPrefix(ctx, prefix, deletePreviewSampleSize)
+	if err != nil {
+		return PrefixDelete{}, err
+	}
+	result := PrefixDelete{
+		DryRun: true,
+		Count:  preview.Count,
+		Keys:   preview.Keys,
+	}
+	if preview.Count == 0 {
+		return result, nil
+	}
+
+	result.Token = rand.Text()
+	result.ExpiresAt = time.Now().Add(deleteTokenTTL)
+
+	d.tokensMu.Lock()
+	defer d.tokensMu.Unlock()
+	now := time.Now()
+	for token, issued := range d.deleteTokens {
+		if now.After(issued.expiresAt) {
+			delete(d.deleteTokens, token)
+		}
+	}
+	d.deleteTokens[result.Token] = deleteToken{
+		prefix:    prefix,
+		revision:  preview.Revision,
+		expiresAt: result.ExpiresAt,
+	}
+	return result, nil
+}
+
+// Txn runs a multi-key transaction on etcd
+// The KVStore is not written here, the changes reach it through the watch like any other write to etcd
+func (d *DefaultEtcdfinder) Txn(ctx context.Context, txn etcd.Txn) (etcd.TxnResult, error) {
+	return d.etcdClt.Txn(ctx, txn)
 }
 
-func (d *DefaultEtcdfinder) GetIngestionDelay(ctx context.Context) int {
+func (d *DefaultEtcdfinder) GetIngestionDelay(ctx context.Context) (ingestor.IngestionDelay, error) {
 	return d.ingestorClt.GetIngestionDelay(ctx)
 }
+
+func (d *DefaultEtcdfinder) GetReconciliationStatus(ctx context.Context) ingestor.ReconciliationStatus {
+	return d.ingestorClt.GetReconciliationStatus(ctx)
+}
+
+func (d *DefaultEtcdfinder) Reindex(ctx context.Context) error {
+	return d.ingestorClt.RequestRebuild(ctx)
+}
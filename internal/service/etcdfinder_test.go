@@ -0,0 +1,465 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/etcdfinder/etcdfinder/internal/config"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/service"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+	"go.yaml.in/yaml/v3"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.NewLogger(&config.Config{}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// newEtcdfinder returns a service over a fake etcd and an in-memory KVStore, without an ingestor
+func newEtcdfinder(t *testing.T) (service.Etcdfinder, *etcd.FakeClient, kvstore.KVStore) {
+	t.Helper()
+	client, err := etcd.NewFakeClient(100, "", 100, 60)
+	if err != nil {
+		t.Fatalf("NewFakeClient() error = %v", err)
+	}
+	store, err := kvstore.NewMemoryStore("")
+	if err != nil {
+		t.Fatalf("NewMemoryStore() error = %v", err)
+	}
+	return service.NewDefaultEtcdfinder(client, store, nil, true, 0), client, store
+}
+
+// stringPtr returns a pointer to s, for the optional values of the preconditions
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestPutGetDeleteKey(t *testing.T) {
+	ctx := context.Background()
+	finder, _, store := newEtcdfinder(t)
+
+	if err := finder.PutKey(ctx, "/app/database", "postgres", etcd.Precondition{}); err != nil {
+		t.Fatalf("PutKey() error = %v", err)
+	}
+	if err := finder.PutKey(ctx, "/app/cache", "redis", etcd.Precondition{}); err != nil {
+		t.Fatalf("PutKey() error = %v", err)
+	}
+	if err := finder.PutKey(ctx, "/app/database", "postgres", etcd.Precondition{}); err != nil {
+		t.Fatalf("PutKey() error = %v", err)
+	}
+	kv, err := finder.GetKey(ctx, "/app/database", 0)
+	if err != nil {
+		t.Fatalf("GetKey() error = %v", err)
+	}
+	if kv.Value != "postgres" || kv.CreateRevision != 1 || kv.ModRevision != 3 || kv.Version != 2 {
+		t.Fatalf("GetKey() = %+v, want postgres created at revision 1 and changed twice up to revision 3", kv)
+	}
+
+	// The write is searchable as soon as PutKey returns
+	results, err := finder.SearchKeys(ctx, "database", kvstore.SearchOptions{Limit: kvstore.DefaultSearchLimit})
+	if err != nil {
+		t.Fatalf("SearchKeys() error = %v", err)
+	}
+	if len(results.Hits) != 1 || results.Hits[0].Key != "/app/database" {
+		t.Fatalf("SearchKeys() hits = %v, want /app/database", results.Hits)
+	}
+
+	if err := finder.DeleteKey(ctx, "/app/database", etcd.Precondition{}); err != nil {
+		t.Fatalf("DeleteKey() error = %v", err)
+	}
+	if _, err := finder.GetKey(ctx, "/app/database", 0); !errors.Is(err, customerrors.ErrKeyNotFound) {
+		t.Fatalf("GetKey() after delete error = %v, want ErrKeyNotFound", err)
+	}
+	if _, err := store.Get(ctx, "/app/database"); !errors.Is(err, customerrors.ErrKeyNotFound) {
+		t.Fatalf("KVStore Get() after delete error = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestPutKeyConnectionLost(t *testing.T) {
+	ctx := context.Background()
+	finder, client, store := newEtcdfinder(t)
+
+	client.Disconnect(nil)
+	if err := finder.PutKey(ctx, "/app/database", "postgres", etcd.Precondition{}); !errors.Is(err, etcd.ErrConnectionLost) {
+		t.Fatalf("PutKey() error = %v, want ErrConnectionLost", err)
+	}
+	// Nothing is indexed unless etcd accepted the write
+	if _, err := store.Get(ctx, "/app/database"); !errors.Is(err, customerrors.ErrKeyNotFound) {
+		t.Fatalf("KVStore Get() error = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestRollbackKey(t *testing.T) {
+	ctx := context.Background()
+	finder, _, store := newEtcdfinder(t)
+	for _, value := range []string{"v1", "v2", "broken"} {
+		if err := finder.PutKey(ctx, "/app/config", value, etcd.Precondition{}); err != nil {
+			t.Fatalf("PutKey() error = %v", err)
+		}
+	}
+
+	rollback, err := finder.RollbackKey(ctx, "/app/config", 2)
+	if err != nil {
+		t.Fatalf("RollbackKey() error = %v", err)
+	}
+	want := service.Rollback{OldValue: "broken", OldModRevision: 3, RestoredValue: "v2", RestoredFrom: 2, Revision: 4}
+	if rollback != want {
+		t.Fatalf("RollbackKey() = %+v, want %+v", rollback, want)
+	}
+	if value, err := store.Get(ctx, "/app/config"); err != nil || value != "v2" {
+		t.Fatalf("KVStore Get() = %q, %v, want v2", value, err)
+	}
+
+}
+
+func TestConditionalWrites(t *testing.T) {
+	ctx := context.Background()
+	finder, _, store := newEtcdfinder(t)
+
+	// A precondition on an absent key creates it only once
+	if err := finder.PutKey(ctx, "/app/config", "v1", etcd.Precondition{Absent: true}); err != nil {
+		t.Fatalf("PutKey() of a new key error = %v", err)
+	}
+	if err := finder.PutKey(ctx, "/app/config", "v1", etcd.Precondition{Absent: true}); !errors.Is(err, customerrors.ErrKeyModified) {
+		t.Fatalf("PutKey() of an existing key error = %v, want ErrKeyModified", err)
+	}
+
+	// Two operators editing the key read it at mod revision 1, only the first one wins
+	if err := finder.PutKey(ctx, "/app/config", "first", etcd.Precondition{ModRevision: 1}); err != nil {
+		t.Fatalf("PutKey() error = %v", err)
+	}
+	if err := finder.PutKey(ctx, "/app/config", "second", etcd.Precondition{ModRevision: 1}); !errors.Is(err, customerrors.ErrKeyModified) {
+		t.Fatalf("PutKey() on a stale mod revision error = %v, want ErrKeyModified", err)
+	}
+	if value, err := store.Get(ctx, "/app/config"); err != nil || value != "first" {
+		t.Fatalf("KVStore Get() = %q, %v, want first", value, err)
+	}
+
+	if err := finder.DeleteKey(ctx, "/app/config", etcd.Precondition{PrevValue: stringPtr("second")}); !errors.Is(err, customerrors.ErrKeyModified) {
+		t.Fatalf("DeleteKey() on a stale value error = %v, want ErrKeyModified", err)
+	}
+	if err := finder.DeleteKey(ctx, "/app/config", etcd.Precondition{ModRevision: 2, PrevValue: stringPtr("first")}); err != nil {
+		t.Fatalf("DeleteKey() error = %v", err)
+	}
+	if _, err := store.Get(ctx, "/app/config"); !errors.Is(err, customerrors.ErrKeyNotFound) {
+		t.Fatalf("KVStore Get() after delete error = %v, want ErrKeyNotFound", err)
+	}
+
+	// An empty expected value is a condition too, it does not hold on a missing key nor on another value
+	if err := finder.PutKey(ctx, "/app/flag", "on", etcd.Precondition{PrevValue: stringPtr("")}); !errors.Is(err, customerrors.ErrKeyModified) {
+		t.Fatalf("PutKey() of a missing key expected empty error = %v, want ErrKeyModified", err)
+	}
+	if err := finder.PutKey(ctx, "/app/flag", "", etcd.Precondition{}); err != nil {
+		t.Fatalf("PutKey() error = %v", err)
+	}
+	if err := finder.PutKey(ctx, "/app/flag", "on", etcd.Precondition{PrevValue: stringPtr("")}); err != nil {
+		t.Fatalf("PutKey() of a key expected empty error = %v", err)
+	}
+	if err := finder.PutKey(ctx, "/app/flag", "off", etcd.Precondition{PrevValue: stringPtr("")}); !errors.Is(err, customerrors.ErrKeyModified) {
+		t.Fatalf("PutKey() of a key no longer empty error = %v, want ErrKeyModified", err)
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	ctx := context.Background()
+	finder, client, store := newEtcdfinder(t)
+	for _, key := range []string{"/svc/old/a", "/svc/old/b", "/svc/old/c/d", "/svc/new/a", "/svc/old-billing/a"} {
+		if err := finder.PutKey(ctx, key, "v", etcd.Precondition{}); err != nil {
+			t.Fatalf("PutKey() error = %v", err)
+		}
+	}
+
+	// The prefix names a directory, /svc/old-billing is not under /svc/old
+	preview, err := finder.DeletePrefix(ctx, "/svc/old", "")
+	if err != nil {
+		t.Fatalf("DeletePrefix() preview error = %v", err)
+	}
+	if !preview.DryRun || preview.Count != 3 || len(preview.Keys) != 3 || preview.Token == "" {
+		t.Fatalf("DeletePrefix() preview = %+v, want 3 keys and a token", preview)
+	}
+	if kv, err := client.Get(ctx, "/svc/old/a", 0); err != nil || kv.Value != "v" {
+		t.Fatalf("Get() after the preview = %+v, %v, want the key kept", kv, err)
+	}
+
+	// The token is bound to the prefix it was issued for
+	if _, err := finder.DeletePrefix(ctx, "/svc/", preview.Token); !errors.Is(err, customerrors.ErrInvalidConfirmToken) {
+		t.Fatalf("DeletePrefix() with the token of another prefix error = %v, want ErrInvalidConfirmToken", err)
+	}
+
+	// A change since the preview makes the delete fail
+	preview, err = finder.DeletePrefix(ctx, "/svc/old/", "")
+	if err != nil {
+		t.Fatalf("DeletePrefix() preview error = %v", err)
+	}
+	if _, err := client.Put(ctx, "/svc/old/e", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := finder.DeletePrefix(ctx, "/svc/old/", preview.Token); !errors.Is(err, customerrors.ErrKeyModified) {
+		t.Fatalf("DeletePrefix() after a change error = %v, want ErrKeyModified", err)
+	}
+
+	preview, err = finder.DeletePrefix(ctx, "/svc/old/", "")
+	if err != nil {
+		t.Fatalf("DeletePrefix() preview error = %v", err)
+	}
+	result, err := finder.DeletePrefix(ctx, "/svc/old/", preview.Token)
+	if err != nil {
+		t.Fatalf("DeletePrefix() error = %v", err)
+	}
+	if result.DryRun || result.Count != 4 {
+		t.Fatalf("DeletePrefix() = %+v, want 4 keys deleted", result)
+	}
+	for _, key := range []string{"/svc/new/a", "/svc/old-billing/a"} {
+		if _, err := client.Get(ctx, key, 0); err != nil {
+			t.Fatalf("Get() of %s outside the prefix error = %v", key, err)
+		}
+	}
+	if _, err := store.Get(ctx, "/svc/old/a"); !errors.Is(err, customerrors.ErrKeyNotFound) {
+		t.Fatalf("KVStore Get() of a deleted key error = %v, want ErrKeyNotFound", err)
+	}
+
+	// A token is used once
+	if _, err := finder.DeletePrefix(ctx, "/svc/old/", preview.Token); !errors.Is(err, customerrors.ErrInvalidConfirmToken) {
+		t.Fatalf("DeletePrefix() reusing a token error = %v, want ErrInvalidConfirmToken", err)
+	}
+}
+
+func TestDeletePrefixStaysInsideRoot(t *testing.T) {
+	ctx := context.Background()
+	client, err := etcd.NewFakeClient(100, "/app/", 100, 60)
+	if err != nil {
+		t.Fatalf("NewFakeClient() error = %v", err)
+	}
+	client.Seed([]common.KV{{Key: "/app/a", Value: "1"}, {Key: "/other/b", Value: "2"}})
+	store, err := kvstore.NewMemoryStore("")
+	if err != nil {
+		t.Fatalf("NewMemoryStore() error = %v", err)
+	}
+	finder := service.NewDefaultEtcdfinder(client, store, nil, false, 0)
+
+	if _, err := finder.DeletePrefix(ctx, "/", ""); !errors.Is(err, customerrors.ErrPrefixOutsideRoot) {
+		t.Fatalf("DeletePrefix() preview of / error = %v, want ErrPrefixOutsideRoot", err)
+	}
+	// Nor does the client delete past the root prefix when handed a confirmed revision
+	revision, err := client.GetRevision(ctx)
+	if err != nil {
+		t.Fatalf("GetRevision() error = %v", err)
+	}
+	for _, prefix := range []string{"/", ""} {
+		if _, err := client.DeletePrefix(ctx, prefix, revision); err == nil {
+			t.Fatalf("DeletePrefix(%q) deleted past the root prefix", prefix)
+		}
+	}
+	for _, key := range []string{"/app/a", "/other/b"} {
+		if _, err := client.Get(ctx, key, 0); err != nil {
+			t.Fatalf("Get() of %s error = %v, want it kept", key, err)
+		}
+	}
+
+	preview, err := finder.DeletePrefix(ctx, "/app", "")
+	if err != nil {
+		t.Fatalf("DeletePrefix() preview error = %v", err)
+	}
+	if result, err := finder.DeletePrefix(ctx, "/app", preview.Token); err != nil || result.Count != 1 {
+		t.Fatalf("DeletePrefix() of the root prefix = %+v, %v, want 1 key deleted", result, err)
+	}
+	if _, err := client.Get(ctx, "/other/b", 0); err != nil {
+		t.Fatalf("Get() of a key outside the root prefix error = %v, want it kept", err)
+	}
+}
+
+func TestCopyAndMove(t *testing.T) {
+	ctx := context.Background()
+	finder, client, store := newEtcdfinder(t)
+	for key, value := range map[string]string{"/config/svc/a": "1", "/config/svc/b": "2", "/svc/config/b": "existing", "/config/svc-old/a": "old"} {
+		if err := finder.PutKey(ctx, key, value, etcd.Precondition{}); err != nil {
+			t.Fatalf("PutKey() error = %v", err)
+		}
+	}
+
+	// Prefixes name directories, /config/svc-old is not under /config/svc
+	copied, err := finder.Copy(ctx, "/config/svc", "/svc/config", true, service.CopySkipExisting)
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if copied.Succeeded != 1 || copied.Skipped != 1 || len(copied.Keys) != 2 ||
+		copied.Keys[0].Destination != "/svc/config/a" || copied.Keys[0].Status != service.TransferCopied ||
+		copied.Keys[1].Status != service.TransferSkipped {
+		t.Fatalf("Copy() = %+v, want /svc/config/a copied and /svc/config/b skipped", copied)
+	}
+	if kv, err := client.Get(ctx, "/svc/config/b", 0); err != nil || kv.Value != "existing" {
+		t.Fatalf("Get() of a skipped destination = %q, %v, want it kept", kv.Value, err)
+	}
+
+	moved, err := finder.Move(ctx, "/config/svc/", "/svc/config/", true, service.CopyOverwrite)
+	if err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+	if moved.Succeeded != 2 || moved.Keys[1].Status != service.TransferMoved {
+		t.Fatalf("Move() = %+v, want both keys moved", moved)
+	}
+	if kv, err := client.Get(ctx, "/svc/config/b", 0); err != nil || kv.Value != "2" {
+		t.Fatalf("Get() of an overwritten destination = %q, %v, want 2", kv.Value, err)
+	}
+	if _, err := client.Get(ctx, "/config/svc/a", 0); !errors.Is(err, customerrors.ErrKeyNotFound) {
+		t.Fatalf("Get() of a moved source error = %v, want ErrKeyNotFound", err)
+	}
+	if value, err := store.Get(ctx, "/svc/config/b"); err != nil || value != "2" {
+		t.Fatalf("KVStore Get() of a moved key = %q, %v, want 2", value, err)
+	}
+
+	if _, err := finder.Move(ctx, "/svc", "/svc/config/", true, service.CopyOverwrite); !errors.Is(err, customerrors.ErrInvalidDestination) {
+		t.Fatalf("Move() into the source prefix error = %v, want ErrInvalidDestination", err)
+	}
+	// A sibling sharing the name of the source as a prefix is not inside it
+	if _, err := finder.Move(ctx, "/config/svc-old", "/config/svc", true, service.CopyOverwrite); err != nil {
+		t.Fatalf("Move() to a sibling error = %v", err)
+	}
+	if kv, err := client.Get(ctx, "/config/svc/a", 0); err != nil || kv.Value != "old" {
+		t.Fatalf("Get() of a key moved to a sibling = %q, %v, want old", kv.Value, err)
+	}
+}
+
+// v2Client is a fake etcd without transactions, like etcd v2, whose writes of some keys fail
+type v2Client struct {
+	*etcd.FakeClient
+	lostPut  string // key whose write fails with a lost connection
+	modified string // key whose conditional delete fails as if it had changed
+}
+
+func (c *v2Client) Txn(ctx context.Context, txn etcd.Txn) (etcd.TxnResult, error) {
+	return etcd.TxnResult{}, customerrors.ErrTxnUnsupported
+}
+
+func (c *v2Client) Put(ctx context.Context, key string, value string) (string, error) {
+	if key == c.lostPut {
+		return "", etcd.ErrConnectionLost
+	}
+	return c.FakeClient.Put(ctx, key, value)
+}
+
+func (c *v2Client) CompareAndDelete(ctx context.Context, key string, cond etcd.Precondition) (int64, error) {
+	if key == c.modified {
+		return 0, customerrors.ErrKeyModified
+	}
+	return c.FakeClient.CompareAndDelete(ctx, key, cond)
+}
+
+func TestMoveFailsMidway(t *testing.T) {
+	ctx := context.Background()
+	fake, err := etcd.NewFakeClient(100, "", 100, 60)
+	if err != nil {
+		t.Fatalf("NewFakeClient() error = %v", err)
+	}
+	fake.Seed([]common.KV{{Key: "/src/a", Value: "1"}, {Key: "/src/b", Value: "2"}, {Key: "/src/c", Value: "3"}, {Key: "/src/d", Value: "4"}})
+	client := &v2Client{FakeClient: fake, lostPut: "/dst/c", modified: "/src/b"}
+	store, err := kvstore.NewMemoryStore("")
+	if err != nil {
+		t.Fatalf("NewMemoryStore() error = %v", err)
+	}
+	finder := service.NewDefaultEtcdfinder(client, store, nil, false, 0)
+
+	moved, err := finder.Move(ctx, "/src", "/dst", true, service.CopyOverwrite)
+	if !errors.Is(err, etcd.ErrConnectionLost) {
+		t.Fatalf("Move() error = %v, want ErrConnectionLost", err)
+	}
+	var statuses []service.TransferStatus
+	for _, key := range moved.Keys {
+		statuses = append(statuses, key.Status)
+	}
+	want := []service.TransferStatus{service.TransferMoved, service.TransferCopiedNotMoved, service.TransferFailed, service.TransferFailed}
+	if !reflect.DeepEqual(statuses, want) || moved.Succeeded != 1 || moved.CopiedNotMoved != 1 || moved.Failed != 2 {
+		t.Fatalf("Move() = %+v, want the keys before the failure reported and the rest failed", moved)
+	}
+	// The keys written before the failure are indexed, the source whose deletion failed is kept
+	if value, err := store.Get(ctx, "/dst/b"); err != nil || value != "2" {
+		t.Fatalf("KVStore Get() of a copied but not moved key = %q, %v, want 2", value, err)
+	}
+	if kv, err := fake.Get(ctx, "/src/b", 0); err != nil || kv.Value != "2" {
+		t.Fatalf("Get() of a changed source = %q, %v, want it kept", kv.Value, err)
+	}
+	if _, err := fake.Get(ctx, "/dst/d", 0); !errors.Is(err, customerrors.ErrKeyNotFound) {
+		t.Fatalf("Get() of a destination not attempted error = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	// A small page size makes the export span several pages
+	client, err := etcd.NewFakeClient(100, "", 2, 60)
+	if err != nil {
+		t.Fatalf("NewFakeClient() error = %v", err)
+	}
+	client.Seed([]common.KV{
+		{Key: "/app/db/host", Value: "db.internal"},
+		{Key: "/app/db/port", Value: "5432"},
+		{Key: "/app/db-replica", Value: "replica.internal"},
+		{Key: "/app/name", Value: `it's "quoted"`},
+		{Key: "/app/db", Value: "a key named like a directory"},
+		{Key: "/other/key", Value: "outside the prefix"},
+	})
+	store, err := kvstore.NewMemoryStore("")
+	if err != nil {
+		t.Fatalf("NewMemoryStore() error = %v", err)
+	}
+	finder := service.NewDefaultEtcdfinder(client, store, nil, false, 0)
+
+	export := func(format service.ExportFormat) string {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := finder.Export(ctx, "/app", format, &buf); err != nil {
+			t.Fatalf("Export(%s) error = %v", format, err)
+		}
+		return buf.String()
+	}
+
+	var flat map[string]string
+	if err := json.Unmarshal([]byte(export(service.ExportJSON)), &flat); err != nil {
+		t.Fatalf("json export is not valid JSON: %v", err)
+	}
+	if len(flat) != 5 || flat["/app/name"] != `it's "quoted"` {
+		t.Fatalf("json export = %v, want the 5 keys under /app/", flat)
+	}
+
+	// The directory named like a key written before it is kept as a flat leaf
+	want := map[string]any{
+		"db":         "a key named like a directory",
+		"db-replica": "replica.internal",
+		"db/host":    "db.internal",
+		"db/port":    "5432",
+		"name":       `it's "quoted"`,
+	}
+	var nested map[string]any
+	if err := json.Unmarshal([]byte(export(service.ExportNestedJSON)), &nested); err != nil {
+		t.Fatalf("nested_json export is not valid JSON: %v", err)
+	}
+	if !reflect.DeepEqual(nested, want) {
+		t.Fatalf("nested_json export = %v, want %v", nested, want)
+	}
+	var fromYAML map[string]any
+	if err := yaml.Unmarshal([]byte(export(service.ExportYAML)), &fromYAML); err != nil {
+		t.Fatalf("yaml export is not valid YAML: %v", err)
+	}
+	if !reflect.DeepEqual(fromYAML, want) {
+		t.Fatalf("yaml export = %v, want %v", fromYAML, want)
+	}
+
+	if env := export(service.ExportEnv); !strings.Contains(env, "DB_HOST=\"db.internal\"\n") || !strings.Contains(env, `NAME="it's \"quoted\""`) {
+		t.Fatalf("env export = %q, want DB_HOST and NAME variables", env)
+	}
+	if script := export(service.ExportEtcdctl); !strings.Contains(script, `etcdctl put -- '/app/name' 'it'\''s "quoted"'`) {
+		t.Fatalf("etcdctl export = %q, want a put of /app/name", script)
+	}
+}
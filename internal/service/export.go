@@ -0,0 +1,377 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+)
+
+// ExportFormat is the format keys are exported in
+type ExportFormat string
+
+const (
+	ExportJSON       ExportFormat = "json"        // flat object of full keys to values
+	ExportNestedJSON ExportFormat = "nested_json" // objects nested on the segments of the keys below the prefix
+	ExportYAML       ExportFormat = "yaml"        // mappings nested like nested_json
+	ExportEnv        ExportFormat = "env"         // .env file of variables named after the keys below the prefix
+	ExportEtcdctl    ExportFormat = "etcdctl"     // shell script of etcdctl put commands
+)
+
+// Valid reports whether the export format is supported
+func (f ExportFormat) Valid() bool {
+	switch f {
+	case ExportJSON, ExportNestedJSON, ExportYAML, ExportEnv, ExportEtcdctl:
+		return true
+	}
+	return false
+}
+
+// ContentType returns the MIME type of the export
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportJSON, ExportNestedJSON:
+		return "application/json"
+	case ExportYAML:
+		return "application/yaml"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// FileExtension returns the extension of a file holding the export
+func (f ExportFormat) FileExtension() string {
+	switch f {
+	case ExportJSON, ExportNestedJSON:
+		return "json"
+	case ExportYAML:
+		return "yaml"
+	case ExportEnv:
+		return "env"
+	}
+	return "sh"
+}
+
+// exportWriter writes the keys of an export in order
+type exportWriter interface {
+	// write writes a key, rel is the key below the prefix
+	write(kv common.KV, rel string) error
+	// close writes the end of the export
+	close() error
+}
+
+// flusher is implemented by writers that can send what they buffered, like http.ResponseWriter
+type flusher interface {
+	Flush()
+}
+
+// Export writes the keys under prefix to w in the given format, one page of keys at a time
+// The prefix names a directory, a trailing separator is added if it has none
+// Nothing is written if reading the first page fails, so the error can still be reported to the client
+func (d *DefaultEtcdfinder) Export(ctx context.Context, prefix string, format ExportFormat, w io.Writer) error {
+	dir := etcd.DirPrefix(prefix)
+
+	// The pages after the first one are read at its revision, so the export is a consistent snapshot
+	kvs, nextKey, revision, err := d.etcdClt.GetKeysWithPagination(ctx, dir, "", 0)
+	if err != nil {
+		return err
+	}
+
+	var ew exportWriter
+	switch format {
+	case ExportNestedJSON:
+		ew = newNestedWriter(w, jsonNesting{})
+	case ExportYAML:
+		ew = newNestedWriter(w, yamlNesting{})
+	case ExportEnv:
+		ew = &envWriter{w: w}
+	case ExportEtcdctl:
+		ew = &etcdctlWriter{w: w}
+	default:
+		ew = &flatJSONWriter{w: w}
+	}
+
+	for {
+		for _, kv := range kvs {
+			rel, ok := strings.CutPrefix(kv.Key, dir)
+			// Keys come in order, so the first one outside the directory ends it
+			if !ok {
+				return ew.close()
+			}
+			// Keys start with the separator when exporting the empty prefix, which adds no level
+			if dir == "" {
+				rel = strings.TrimPrefix(rel, etcd.PathSeparator)
+			}
+			if rel == "" {
+				continue
+			}
+			if err := ew.write(kv, rel); err != nil {
+				return err
+			}
+		}
+		if f, ok := w.(flusher); ok {
+			f.Flush()
+		}
+		if nextKey == "" {
+			return ew.close()
+		}
+
+		kvs, nextKey, _, err = d.etcdClt.GetKeysWithPagination(ctx, dir, nextKey, revision)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// quote returns s as a JSON string, which is a valid double-quoted YAML scalar as well
+func quote(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// flatJSONWriter writes a single object of full keys to values
+type flatJSONWriter struct {
+	w       io.Writer
+	written bool
+}
+
+func (fw *flatJSONWriter) write(kv common.KV, rel string) error {
+	sep := ","
+	if !fw.written {
+		sep = "{"
+		fw.written = true
+	}
+	_, err := fmt.Fprintf(fw.w, "%s\n  %s: %s", sep, quote(kv.Key), quote(kv.Value))
+	return err
+}
+
+func (fw *flatJSONWriter) close() error {
+	if !fw.written {
+		_, err := io.WriteString(fw.w, "{}\n")
+		return err
+	}
+	_, err := io.WriteString(fw.w, "\n}\n")
+	return err
+}
+
+// envWriter writes a variable per key, named after the key below the prefix in upper case
+// with every character other than a letter or digit replaced by an underscore
+type envWriter struct {
+	w io.Writer
+}
+
+func (ew *envWriter) write(kv common.KV, rel string) error {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			return r
+		}
+		return '_'
+	}, rel)
+	if name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+
+	// Double quotes keep the value on one line, the characters interpreted within them are escaped
+	value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`).Replace(kv.Value)
+	_, err := fmt.Fprintf(ew.w, "%s=\"%s\"\n", name, value)
+	return err
+}
+
+func (ew *envWriter) close() error {
+	return nil
+}
+
+// etcdctlWriter writes a shell script restoring the keys with etcdctl
+type etcdctlWriter struct {
+	w       io.Writer
+	written bool
+}
+
+func (ew *etcdctlWriter) write(kv common.KV, rel string) error {
+	if !ew.written {
+		ew.written = true
+		if _, err := io.WriteString(ew.w, "#!/bin/sh\nset -e\n"); err != nil {
+			return err
+		}
+	}
+	// -- keeps keys and values starting with a dash from being read as flags
+	_, err := fmt.Fprintf(ew.w, "etcdctl put -- %s %s\n", shellQuote(kv.Key), shellQuote(kv.Value))
+	return err
+}
+
+func (ew *etcdctlWriter) close() error {
+	if !ew.written {
+		_, err := io.WriteString(ew.w, "#!/bin/sh\n")
+		return err
+	}
+	return nil
+}
+
+// shellQuote returns s as a single-quoted shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// nesting writes the syntax of a nested export format
+type nesting interface {
+	// open writes the start of a nested object named name at depth, first tells whether it is the first entry of its parent
+	open(w io.Writer, name string, depth int, first bool) error
+	// leaf writes a value named name at depth
+	leaf(w io.Writer, name, value string, depth int, first bool) error
+	// closeObject writes the end of an object at depth, empty tells whether it had no entry
+	closeObject(w io.Writer, depth int, empty bool) error
+	// end writes the end of the export, empty tells whether the root had no entry
+	end(w io.Writer, empty bool) error
+}
+
+// nestedWriter writes keys as objects nested on the segments of the keys below the prefix
+// The keys come in order, so the keys of a directory are contiguous and a directory is written
+// as soon as its last key is, only the directories of the current key are kept in memory
+// The key a/b sorts after a and a-b, so a directory can be named like a key written before it,
+// in which case its keys are written as leaves of the parent named by their remaining path, like a/b
+type nestedWriter struct {
+	w       io.Writer
+	nesting nesting
+	path    []string              // directories of the last key written, outermost first
+	entries []int                 // number of entries written in the root and in each directory of path
+	leaves  []map[string]struct{} // names of the leaves written in the root and in each directory of path
+}
+
+func newNestedWriter(w io.Writer, n nesting) *nestedWriter {
+	return &nestedWriter{
+		w:       w,
+		nesting: n,
+		entries: []int{0},
+		leaves:  []map[string]struct{}{{}},
+	}
+}
+
+func (nw *nestedWriter) write(kv common.KV, rel string) error {
+	segments := strings.Split(rel, etcd.PathSeparator)
+	dirs, name := segments[:len(segments)-1], segments[len(segments)-1]
+
+	// Close the directories the key is not in
+	shared := 0
+	for shared < len(nw.path) && shared < len(dirs) && nw.path[shared] == dirs[shared] {
+		shared++
+	}
+	for len(nw.path) > shared {
+		if err := nw.closeLast(); err != nil {
+			return err
+		}
+	}
+
+	// Open the directories of the key, unless one is named like a leaf written before it
+	for i := shared; i < len(dirs); i++ {
+		depth := len(nw.path)
+		if _, ok := nw.leaves[depth][dirs[i]]; ok {
+			name = strings.Join(segments[i:], etcd.PathSeparator)
+			break
+		}
+		if err := nw.nesting.open(nw.w, dirs[i], depth, nw.entries[depth] == 0); err != nil {
+			return err
+		}
+		nw.entries[depth]++
+		nw.path = append(nw.path, dirs[i])
+		nw.entries = append(nw.entries, 0)
+		nw.leaves = append(nw.leaves, map[string]struct{}{})
+	}
+
+	depth := len(nw.path)
+	if err := nw.nesting.leaf(nw.w, name, kv.Value, depth, nw.entries[depth] == 0); err != nil {
+		return err
+	}
+	nw.entries[depth]++
+	nw.leaves[depth][name] = struct{}{}
+	return nil
+}
+
+// closeLast closes the innermost open directory
+func (nw *nestedWriter) closeLast() error {
+	depth := len(nw.path)
+	if err := nw.nesting.closeObject(nw.w, depth, nw.entries[depth] == 0); err != nil {
+		return err
+	}
+	nw.path = nw.path[:depth-1]
+	nw.entries = nw.entries[:depth]
+	nw.leaves = nw.leaves[:depth]
+	return nil
+}
+
+func (nw *nestedWriter) close() error {
+	for len(nw.path) > 0 {
+		if err := nw.closeLast(); err != nil {
+			return err
+		}
+	}
+	return nw.nesting.end(nw.w, nw.entries[0] == 0)
+}
+
+// jsonNesting writes nested JSON objects indented by two spaces
+type jsonNesting struct{}
+
+func (jsonNesting) entry(w io.Writer, name string, depth int, first bool) error {
+	sep := ","
+	if first {
+		sep = "{"
+	}
+	_, err := fmt.Fprintf(w, "%s\n%s%s: ", sep, strings.Repeat("  ", depth+1), quote(name))
+	return err
+}
+
+func (n jsonNesting) open(w io.Writer, name string, depth int, first bool) error {
+	return n.entry(w, name, depth, first)
+}
+
+func (n jsonNesting) leaf(w io.Writer, name, value string, depth int, first bool) error {
+	if err := n.entry(w, name, depth, first); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, quote(value))
+	return err
+}
+
+func (jsonNesting) closeObject(w io.Writer, depth int, empty bool) error {
+	_, err := fmt.Fprintf(w, "\n%s}", strings.Repeat("  ", depth))
+	return err
+}
+
+func (jsonNesting) end(w io.Writer, empty bool) error {
+	if empty {
+		_, err := io.WriteString(w, "{}\n")
+		return err
+	}
+	_, err := io.WriteString(w, "\n}\n")
+	return err
+}
+
+// yamlNesting writes nested YAML block mappings indented by two spaces, with double-quoted keys and values
+type yamlNesting struct{}
+
+func (yamlNesting) open(w io.Writer, name string, depth int, first bool) error {
+	_, err := fmt.Fprintf(w, "%s%s:\n", strings.Repeat("  ", depth), quote(name))
+	return err
+}
+
+func (yamlNesting) leaf(w io.Writer, name, value string, depth int, first bool) error {
+	_, err := fmt.Fprintf(w, "%s%s: %s\n", strings.Repeat("  ", depth), quote(name), quote(value))
+	return err
+}
+
+func (yamlNesting) closeObject(w io.Writer, depth int, empty bool) error {
+	return nil
+}
+
+func (yamlNesting) end(w io.Writer, empty bool) error {
+	if empty {
+		_, err := io.WriteString(w, "{}\n")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+)
+
+const (
+	MaxTransferKeys   = 10000 // maximum number of keys copied or moved by a single request
+	transferBatchSize = 64    // keys written per transaction, a move takes two operations per key out of the 128 etcd allows by default
+)
+
+// CopyPolicy tells what a copy or move does with destination keys that already exist
+type CopyPolicy string
+
+const (
+	CopyOverwrite    CopyPolicy = "overwrite"
+	CopySkipExisting CopyPolicy = "skip_existing"
+)
+
+// Valid reports whether the policy is supported
+func (p CopyPolicy) Valid() bool {
+	return p == CopyOverwrite || p == CopySkipExisting
+}
+
+// TransferStatus is the outcome of the copy or move of a single key
+type TransferStatus string
+
+const (
+	TransferCopied         TransferStatus = "copied"
+	TransferMoved          TransferStatus = "moved"
+	TransferCopiedNotMoved TransferStatus = "copied_not_moved" // the destination was written but the source changed and was kept
+	TransferSkipped        TransferStatus = "skipped"
+	TransferFailed         TransferStatus = "failed"
+)
+
+// Transfer is the outcome of a copy or move
+type Transfer struct {
+	Keys           []KeyTransfer
+	Succeeded      int
+	CopiedNotMoved int
+	Skipped        int
+	Failed         int
+}
+
+// KeyTransfer is the outcome of the copy or move of a single key
+type KeyTransfer struct {
+	Source      string
+	Destination string
+	Status      TransferStatus
+	Error       string // reason of a failure
+}
+
+// Copy copies the key, or every key under the prefix if prefix is set, to destination
+func (d *DefaultEtcdfinder) Copy(ctx context.Context, source, destination string, prefix bool, policy CopyPolicy) (Transfer, error) {
+	return d.transfer(ctx, source, destination, prefix, policy, false)
+}
+
+// Move moves the key, or every key under the prefix if prefix is set, to destination
+// A source key is only deleted along with the write of its destination, and only if it has not changed since it was read
+func (d *DefaultEtcdfinder) Move(ctx context.Context, source, destination string, prefix bool, policy CopyPolicy) (Transfer, error) {
+	return d.transfer(ctx, source, destination, prefix, policy, true)
+}
+
+// transfer copies the source keys to the destination in batches, each batch in its own transaction
+// On etcd v2, which has no transactions, the keys are transferred one by one
+// Prefixes name directories, a trailing separator is added to the source and destination if they have none
+// If etcd fails midway, the outcome of the keys transferred so far is returned along with the error,
+// and the keys not attempted are reported as failed
+func (d *DefaultEtcdfinder) transfer(ctx context.Context, source, destination string, prefix bool, policy CopyPolicy, move bool) (Transfer, error) {
+	if prefix {
+		source, destination = etcd.DirPrefix(source), etcd.DirPrefix(destination)
+	}
+	if source == destination || (prefix && (strings.HasPrefix(destination, source) || strings.HasPrefix(source, destination))) {
+		return Transfer{}, customerrors.ErrInvalidDestination
+	}
+
+	var kvs []etcd.KeyValue
+	if prefix {
+		var err error
+		kvs, err = d.etcdClt.GetPrefix(ctx, source, MaxTransferKeys+1)
+		if err != nil {
+			return Transfer{}, err
+		}
+		if len(kvs) > MaxTransferKeys {
+			return Transfer{}, fmt.Errorf("%w: %s holds more than %d keys, transfer its subtrees separately", customerrors.ErrTooManyKeys, source, MaxTransferKeys)
+		}
+	} else {
+		kv, err := d.etcdClt.Get(ctx, source, 0)
+		if err != nil {
+			return Transfer{}, err
+		}
+		kvs = []etcd.KeyValue{kv}
+	}
+
+	result := Transfer{Keys: make([]KeyTransfer, 0, len(kvs))}
+	var transferErr error
+	for start := 0; start < len(kvs) && transferErr == nil; start += transferBatchSize {
+		batch := kvs[start:min(start+transferBatchSize, len(kvs))]
+		keys, err := d.transferBatch(ctx, batch, source, destination, policy, move)
+		if errors.Is(err, customerrors.ErrTxnUnsupported) {
+			keys, err = d.transferEach(ctx, batch, source, destination, policy, move)
+		}
+		if err != nil && keys == nil {
+			// A failed transaction reports the whole batch
+			keys = newKeyTransfers(batch, source, destination, move)
+			for i := range keys {
+				keys[i].Status = TransferFailed
+				keys[i].Error = err.Error()
+			}
+		}
+		result.Keys = append(result.Keys, keys...)
+		transferErr = err
+	}
+	if transferErr != nil {
+		result.Keys = append(result.Keys, notAttempted(newKeyTransfers(kvs[len(result.Keys):], source, destination, move))...)
+	}
+
+	var puts []common.KV
+	var deletes []string
+	for i, key := range result.Keys {
+		switch key.Status {
+		case TransferSkipped:
+			result.Skipped++
+			continue
+		case TransferFailed:
+			result.Failed++
+			continue
+		case TransferCopiedNotMoved:
+			result.CopiedNotMoved++
+			puts = append(puts, common.KV{Key: key.Destination, Value: kvs[i].Value})
+			continue
+		}
+		result.Succeeded++
+		puts = append(puts, common.KV{Key: key.Destination, Value: kvs[i].Value})
+		if move {
+			deletes = append(deletes, key.Source)
+		}
+	}
+	// The keys written to etcd are indexed even after an etcd error, which is reported first
+	if len(puts) > 0 {
+		if err := d.kvStore.PutBatch(ctx, puts); err != nil {
+			return result, cmp.Or(transferErr, err)
+		}
+	}
+	if len(deletes) > 0 {
+		if err := d.kvStore.DeleteBatch(ctx, deletes); err != nil {
+			return result, cmp.Or(transferErr, err)
+		}
+	}
+	if d.waitForWrites {
+		if err := d.kvStore.WaitForWrites(ctx); err != nil {
+			return result, cmp.Or(transferErr, err)
+		}
+	}
+	return result, transferErr
+}
+
+// transferBatch transfers the keys in a single transaction, guarded on the source keys being unchanged
+// and, with the skip_existing policy, on the destination keys still not existing
+// If the guard fails, every key of the batch is reported as failed and nothing is written
+func (d *DefaultEtcdfinder) transferBatch(
+	ctx context.Context,
+	kvs []etcd.KeyValue,
+	source, destination string,
+	policy CopyPolicy,
+	move bool) ([]KeyTransfer, error) {
+	keys := newKeyTransfers(kvs, source, destination, move)
+
+	if policy == CopySkipExisting {
+		var existing etcd.Txn
+		for _, key := range keys {
+			existing.Then = append(existing.Then, etcd.Op{Type: etcd.OpGet, Key: key.Destination})
+		}
+		read, err := d.etcdClt.Txn(ctx, existing)
+		if err != nil {
+			return nil, err
+		}
+		for i, opResult := range read.Results {
+			if opResult.KV != nil {
+				keys[i].Status = TransferSkipped
+			}
+		}
+	}
+
+	var txn etcd.Txn
+	for i, key := range keys {
+		if key.Status == TransferSkipped {
+			continue
+		}
+		txn.Compares = append(txn.Compares, etcd.Compare{
+			Key:    key.Source,
+			Target: etcd.CompareModRevision,
+			Op:     etcd.CompareEqual,
+			Number: kvs[i].ModRevision,
+		})
+		if policy == CopySkipExisting {
+			txn.Compares = append(txn.Compares, etcd.Compare{
+				Key:    key.Destination,
+				Target: etcd.CompareExists,
+				Exists: false,
+			})
+		}
+		txn.Then = append(txn.Then, etcd.Op{Type: etcd.OpPut, Key: key.Destination, Value: kvs[i].Value})
+		if move {
+			txn.Then = append(txn.Then, etcd.Op{Type: etcd.OpDelete, Key: key.Source})
+		}
+	}
+	if len(txn.Then) == 0 {
+		return keys, nil
+	}
+
+	committed, err := d.etcdClt.Txn(ctx, txn)
+	if err != nil {
+		return nil, err
+	}
+	if !committed.Succeeded {
+		for i := range keys {
+			if keys[i].Status != TransferSkipped {
+				keys[i].Status = TransferFailed
+				keys[i].Error = "a key of the batch changed concurrently, nothing of the batch was written"
+			}
+		}
+	}
+	return keys, nil
+}
+
+// transferEach transfers the keys one by one, for etcd v2
+// The destination is written with a condition only under the skip_existing policy,
+// and the source is deleted only if it has not changed since it was read
+// If etcd fails, the keys are returned with the error, the failed key and the keys after it reported as failed
+func (d *DefaultEtcdfinder) transferEach(
+	ctx context.Context,
+	kvs []etcd.KeyValue,
+	source, destination string,
+	policy CopyPolicy,
+	move bool) ([]KeyTransfer, error) {
+	keys := newKeyTransfers(kvs, source, destination, move)
+	for i := range keys {
+		var err error
+		if policy == CopySkipExisting {
+			_, err = d.etcdClt.CompareAndPut(ctx, keys[i].Destination, kvs[i].Value, etcd.Precondition{Absent: true})
+		} else {
+			_, err = d.etcdClt.Put(ctx, keys[i].Destination, kvs[i].Value)
+		}
+		if errors.Is(err, customerrors.ErrKeyModified) {
+			keys[i].Status = TransferSkipped
+			continue
+		}
+		if err != nil {
+			keys[i].Status = TransferFailed
+			keys[i].Error = err.Error()
+			notAttempted(keys[i+1:])
+			return keys, err
+		}
+
+		if move {
+			_, err = d.etcdClt.CompareAndDelete(ctx, keys[i].Source, etcd.Precondition{ModRevision: kvs[i].ModRevision})
+			if errors.Is(err, customerrors.ErrKeyModified) {
+				keys[i].Status = TransferCopiedNotMoved
+				keys[i].Error = "the source changed concurrently, it was copied but not deleted"
+				continue
+			}
+			if err != nil {
+				keys[i].Status = TransferCopiedNotMoved
+				keys[i].Error = err.Error()
+				notAttempted(keys[i+1:])
+				return keys, err
+			}
+		}
+	}
+	return keys, nil
+}
+
+// notAttempted reports the keys as failed without having been attempted, after an earlier key failed
+func notAttempted(keys []KeyTransfer) []KeyTransfer {
+	for i := range keys {
+		keys[i].Status = TransferFailed
+		keys[i].Error = "not attempted, an earlier key failed"
+	}
+	return keys
+}
+
+// newKeyTransfers returns the transfers of the keys, successful until proven otherwise
+func newKeyTransfers(kvs []etcd.KeyValue, source, destination string, move bool) []KeyTransfer {
+	status := TransferCopied
+	if move {
+		status = TransferMoved
+	}
+	keys := make([]KeyTransfer, 0, len(kvs))
+	for _, kv := range kvs {
+		keys = append(keys, KeyTransfer{
+			Source:      kv.Key,
+			Destination: destination + strings.TrimPrefix(kv.Key, source),
+			Status:      status,
+		})
+	}
+	return keys
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"flag"
 	"log"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/etcdfinder/etcdfinder/internal/api"
 	v1 "github.com/etcdfinder/etcdfinder/internal/api/v1"
@@ -36,9 +38,12 @@ func main() {
 	logger.Infof("Initializing application...")
 
 	// Initialize etcd client
-	logger.Infof("Connecting to etcd at %s", conf.Etcd.Endpoints)
 	var etcdClient etcd.BaseClient
-	if conf.Etcd.Version == lib.ETCD_V3 {
+	switch conf.Etcd.Version {
+	case lib.ETCD_DEMO:
+		etcdClient, err = newDemoClient(conf)
+	case lib.ETCD_V3:
+		logger.Infof("Connecting to etcd at %s", conf.Etcd.Endpoints)
 		etcdClient, err = etcd.NewClientV3(
 			strings.Split(conf.Etcd.Endpoints, lib.ETCD_ENDPOINTS_SEPERATOR),
 			conf.Etcd.WatchEventChannelSize,
@@ -46,8 +51,10 @@ func main() {
 			conf.Etcd.PaginationLimit,
 			conf.Etcd.EtcdAuditPeriod,
 			conf.Etcd.MaxWatchRetries,
+			conf.Etcd.MaxHistorySearch,
 		)
-	} else {
+	default:
+		logger.Infof("Connecting to etcd at %s", conf.Etcd.Endpoints)
 		etcdClient, err = etcd.NewClientV2(
 			strings.Split(conf.Etcd.Endpoints, lib.ETCD_ENDPOINTS_SEPERATOR),
 			conf.Etcd.WatchEventChannelSize,
@@ -62,23 +69,61 @@ func main() {
 	}
 	defer etcdClient.Close() //nolint
 
-	// Initialize Elasticsearch KV store
+	// Initialize KV store
 	var kvStore kvstore.KVStore
-	if conf.Datastore.Type == "meilisearch" {
+	switch conf.Datastore.Type {
+	case lib.DATASTORE_MEILISEARCH:
 		kvStore, err = kvstore.NewMeilisearchStore(
 			conf.Datastore.Meilisearch.Host,
 			conf.Datastore.Meilisearch.IndexName,
-			conf.Datastore.Meilisearch.MatchingStrategy)
+			conf.Datastore.Meilisearch.MatchingStrategy,
+			conf.Datastore.Meilisearch.TaskPollInterval)
 		if err != nil {
 			logger.Fatalf("Failed to create Meilisearch store: %v", err)
 		}
-	} else {
+	case lib.DATASTORE_MEMORY:
+		kvStore, err = kvstore.NewMemoryStore(conf.Datastore.Memory.Path)
+		if err != nil {
+			logger.Fatalf("Failed to create in-memory store: %v", err)
+		}
+	case lib.DATASTORE_OPENSEARCH:
+		kvStore, err = kvstore.NewOpenSearchStore(
+			ctx,
+			&http.Client{Timeout: time.Duration(conf.Datastore.OpenSearch.Timeout) * time.Second},
+			kvstore.OpenSearchConfig{
+				Host:          conf.Datastore.OpenSearch.Host,
+				IndexName:     conf.Datastore.OpenSearch.IndexName,
+				Username:      conf.Datastore.OpenSearch.Username,
+				Password:      conf.Datastore.OpenSearch.Password,
+				KeyMinGram:    conf.Datastore.OpenSearch.KeyMinGram,
+				KeyMaxGram:    conf.Datastore.OpenSearch.KeyMaxGram,
+				ValueAnalyzer: conf.Datastore.OpenSearch.ValueAnalyzer,
+			})
+		if err != nil {
+			logger.Fatalf("Failed to create OpenSearch store: %v", err)
+		}
+	case lib.DATASTORE_SQLITE:
+		kvStore, err = kvstore.NewSQLiteStore(ctx, conf.Datastore.SQLite.Path)
+		if err != nil {
+			logger.Fatalf("Failed to create SQLite store: %v", err)
+		}
+	default:
 		logger.Fatalf("Unsupported datastore type: %s", conf.Datastore.Type)
 	}
 	defer kvStore.Close(ctx) //nolint
 
 	// Initialize ingestor
-	ing := ingestor.NewIngestor(kvStore, etcdClient)
+	ing, err := ingestor.NewIngestor(
+		kvStore,
+		etcdClient,
+		conf.Datastore.CheckpointPeriod,
+		conf.Datastore.FlushInterval,
+		conf.Datastore.FlushBatchSize,
+		conf.Datastore.ReconcilePeriod,
+	)
+	if err != nil {
+		logger.Fatalf("Failed to create ingestor: %v", err)
+	}
 
 	// Start watching for etcd changes in background
 	go func() {
@@ -105,7 +150,7 @@ func main() {
 	}
 
 	// Initialize service layer
-	etcdFinderService := service.NewDefaultEtcdfinder(etcdClient, kvStore, ing)
+	etcdFinderService := service.NewDefaultEtcdfinder(etcdClient, kvStore, ing, conf.Datastore.WaitForWrites, conf.Datastore.MaxScanKeys)
 
 	// Initialize router with handlers
 	router, err := api.NewRouter(api.Handlers{
@@ -121,3 +166,28 @@ func main() {
 		logger.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+// newDemoClient creates an in-memory etcd seeded from the demo seed file, if any
+func newDemoClient(conf *config.Config) (etcd.BaseClient, error) {
+	logger.Infof("Running in demo mode with an in-memory etcd")
+	client, err := etcd.NewFakeClient(
+		conf.Etcd.WatchEventChannelSize,
+		conf.Etcd.RootPrefixEtcd,
+		conf.Etcd.PaginationLimit,
+		conf.Etcd.EtcdAuditPeriod,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Etcd.DemoSeedFile == "" {
+		return client, nil
+	}
+
+	kvs, err := etcd.LoadSeedFile(conf.Etcd.DemoSeedFile)
+	if err != nil {
+		return nil, err
+	}
+	client.Seed(kvs)
+	logger.Infof("Seeded the demo etcd with %d keys from %s", len(kvs), conf.Etcd.DemoSeedFile)
+	return client, nil
+}
@@ -0,0 +1,98 @@
+package etcd
+
+import (
+	"slices"
+	"strings"
+)
+
+const (
+	PathSeparator        = "/"  // separates the segments of hierarchical keys
+	DefaultChildrenLimit = 100  // number of children returned by ListChildren when no limit is given
+	MaxChildrenLimit     = 1000 // maximum number of children returned by a single ListChildren call
+)
+
+// Child is an immediate child of a prefix in the key hierarchy
+type Child struct {
+	Name string // segment below the prefix, followed by PathSeparator for a directory
+	Key  string // full key of a leaf, or prefix of a directory
+	Dir  bool
+	Keys int64 // number of keys under a directory at any depth, 1 for a leaf
+}
+
+// Children is a page of the immediate children of a prefix
+type Children struct {
+	Children      []Child
+	TotalChildren int64 // number of immediate children of the prefix
+	TotalKeys     int64 // number of keys under the prefix at any depth
+}
+
+// DirPrefix returns the prefix whose children are listed for prefix, which is the prefix itself
+// followed by PathSeparator if it does not end with one yet
+// The empty prefix is kept, its children are the first segments of every key
+func DirPrefix(prefix string) string {
+	if prefix == "" || strings.HasSuffix(prefix, PathSeparator) {
+		return prefix
+	}
+	return prefix + PathSeparator
+}
+
+// childrenCounter groups the keys under a prefix by the immediate child they belong to
+type childrenCounter struct {
+	prefix    string
+	children  map[string]*Child
+	totalKeys int64
+}
+
+func newChildrenCounter(prefix string) *childrenCounter {
+	return &childrenCounter{
+		prefix:   prefix,
+		children: make(map[string]*Child),
+	}
+}
+
+// add counts a key in the child it belongs to, keys outside of the prefix and the prefix itself are ignored
+func (cc *childrenCounter) add(key string) {
+	rest, ok := strings.CutPrefix(key, cc.prefix)
+	if !ok || rest == "" {
+		return
+	}
+	cc.totalKeys++
+
+	name, isLeaf := rest, true
+	if i := strings.Index(rest, PathSeparator); i >= 0 {
+		name, isLeaf = rest[:i+len(PathSeparator)], false
+	}
+	if child, ok := cc.children[name]; ok {
+		child.Keys++
+		return
+	}
+	cc.children[name] = &Child{
+		Name: name,
+		Key:  cc.prefix + name,
+		Dir:  !isLeaf,
+		Keys: 1,
+	}
+}
+
+// page returns the children sorted by name, skipping offset of them and returning at most limit
+func (cc *childrenCounter) page(offset, limit int64) Children {
+	children := make([]Child, 0, len(cc.children))
+	for _, child := range cc.children {
+		children = append(children, *child)
+	}
+	return pageChildren(children, cc.totalKeys, offset, limit)
+}
+
+// pageChildren sorts the children by name, skipping offset of them and returning at most limit
+func pageChildren(children []Child, totalKeys, offset, limit int64) Children {
+	slices.SortFunc(children, func(a, b Child) int { return strings.Compare(a.Name, b.Name) })
+
+	total := int64(len(children))
+	start := min(offset, total)
+	end := min(start+limit, total)
+	return Children{
+		Children:      children[start:end],
+		TotalChildren: total,
+		TotalKeys:     totalKeys,
+	}
+}
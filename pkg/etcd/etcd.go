@@ -2,23 +2,91 @@ package etcd
 
 import (
 	"context"
+	"fmt"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
 
 	"github.com/etcdfinder/etcdfinder/pkg/common"
 )
 
+// KeyValue is a key with its value and metadata
+type KeyValue struct {
+	Key            string
+	Value          string
+	CreateRevision int64      // CreateRevision (v3) or CreatedIndex (v2) of the key
+	ModRevision    int64      // ModRevision (v3) or ModifiedIndex (v2) of the key
+	Version        int64      // number of changes of the key since its creation, v3 only
+	Lease          int64      // ID of the lease attached to the key, 0 if there is none, v3 only
+	TTL            int64      // remaining seconds to live, 0 if the key does not expire, -1 if its lease has expired
+	Expiration     *time.Time // time the key expires, nil if it does not, v2 only
+}
+
+// Precondition guards a write on the current state of the key, the zero value guards nothing
+type Precondition struct {
+	ModRevision int64   // ModRevision (v3) or ModifiedIndex (v2) the key must still have, 0 to not check it
+	PrevValue   *string // value the key must still have, nil to not check it
+	Absent      bool    // whether the key must not exist
+}
+
+// IsZero reports whether the precondition guards nothing
+func (p Precondition) IsZero() bool {
+	return p == Precondition{}
+}
+
+// conflictError reports that key no longer satisfies a precondition, with the mod revision it has now, 0 if it does not exist
+func conflictError(key string, modRevision int64) error {
+	return customerrors.WithDetails(
+		fmt.Errorf("%w: %s has changed, its mod revision is now %d", customerrors.ErrKeyModified, key, modRevision),
+		map[string]any{"mod_revision": modRevision})
+}
+
 type BaseClient interface {
-	// returns the value of the key and error if any
-	Get(ctx context.Context, key string) (string, error)
+	// returns the value of the key with its metadata and error if any
+	// the key is read at the given revision, or at the latest revision if it is 0
+	Get(ctx context.Context, key string, revision int64) (KeyValue, error)
+	// returns a page of the past versions of the key, newest first, and error if any
+	// the versions are read backwards from the given revision, or from the latest revision if it is 0,
+	// starting at the version removed by the last deletion if the key is deleted at that revision
+	GetHistory(ctx context.Context, key string, revision, limit int64) (KeyHistory, error)
 	// returns the key that was put and error if any
 	Put(ctx context.Context, key string, value string) (string, error)
+	// puts the key only if it still satisfies cond
+	// returns the revision (v3) or index (v2) of the write and error if any, customerrors.ErrKeyModified if the key has changed
+	CompareAndPut(ctx context.Context, key string, value string, cond Precondition) (int64, error)
+	// deletes the key only if it still satisfies cond
+	// returns the revision (v3) or index (v2) of the delete and error if any, customerrors.ErrKeyModified if the key has changed
+	CompareAndDelete(ctx context.Context, key string, cond Precondition) (int64, error)
+	// runs the operations of txn atomically, the Then ones if all of its comparisons hold and the Else ones otherwise
+	// returns the outcome of the transaction and error if any, customerrors.ErrTxnUnsupported on etcd v2
+	Txn(ctx context.Context, txn Txn) (TxnResult, error)
 	// returns the key that was deleted and error if any
 	Delete(ctx context.Context, key string) (string, error)
+	// prefix names a directory in the methods below, see DirPrefix, so /app covers /app/name but not /app-name
+	// returns up to limit keys under prefix with their values and metadata, in key order, and error if any
+	GetPrefix(ctx context.Context, prefix string, limit int64) ([]KeyValue, error)
+	// returns the number of keys under prefix with a sample of them and error if any
+	// customerrors.ErrPrefixOutsideRoot if the prefix directory is not under the root prefix
+	PreviewPrefix(ctx context.Context, prefix string, sampleSize int64) (PrefixPreview, error)
+	// deletes every key under the prefix directory, if revision is not 0 only while none of them has changed after it
+	// returns the keys that were deleted and error if any, customerrors.ErrKeyModified if a key has changed
+	// and customerrors.ErrPrefixOutsideRoot if the prefix directory is not under the root prefix
+	DeletePrefix(ctx context.Context, prefix string, revision int64) ([]string, error)
 	// returns the channel of watch events and error channel
-	Watch(ctx context.Context) (<-chan WatchEvent, <-chan error)
-	// returns the list of keys and the next key to be fetched and error if any
-	GetKeysWithPagination(ctx context.Context, fromKey string) ([]common.KV, string, error)
+	// events are streamed starting at fromRevision, or from now if fromRevision is 0
+	Watch(ctx context.Context, fromRevision int64) (<-chan WatchEvent, <-chan error)
+	// returns the list of keys under prefix, or under the root prefix if it is empty, that sort after fromKey,
+	// the next key to be fetched, the revision the keys were read at and error if any
+	// keys are read at the given revision, or at the latest revision if it is 0
+	GetKeysWithPagination(ctx context.Context, prefix, fromKey string, revision int64) ([]common.KV, string, int64, error)
+	// returns a page of the immediate children of prefix, directories and leaf keys, and error if any
+	ListChildren(ctx context.Context, prefix string, offset, limit int64) (Children, error)
+	// returns the current revision (v3) or index (v2) of etcd and error if any
+	GetRevision(ctx context.Context) (int64, error)
+	// returns the revision (v3) or index (v2) of the newest change under the root prefix received by the watch, 0 if there is none
+	LastWatchedRevision() int64
 	// returns the error channel
 	StartAuditor(ctx context.Context) <-chan error
 	// closes the client
 	Close() error
-}
\ No newline at end of file
+}
@@ -2,8 +2,13 @@ package etcd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"path"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/etcdfinder/etcdfinder/internal/customerrors"
@@ -20,8 +25,26 @@ type ClientV2 struct {
 	numGetKeysLimit       int64  // number of keys to be returned in a single GetKeysWithPagination call
 	EtcdAuditPeriod       time.Duration
 	maxWatchRetries       int64    // maximum number of consecutive failures on the same ModRevision
-	ExpectedModIndex      uint64   // expected modified index of the etcd keys
 	endpoints             []string // endpoints for health checks
+	lastWatchedIndex      atomic.Int64
+
+	snapshotsMu sync.Mutex
+	snapshots   map[snapshotKey]*keySnapshot // sorted keys of the directories by the index they were read at
+}
+
+// keySnapshotTTL is how long the sorted keys of a walk are kept after its last page was read
+const keySnapshotTTL = time.Minute
+
+// snapshotKey identifies the keys of a directory read at an index
+type snapshotKey struct {
+	dir   string
+	index int64
+}
+
+// keySnapshot is the sorted keys of a directory read at an index, shared by the pages of a walk
+type keySnapshot struct {
+	kvs   []common.KV
+	timer *time.Timer // drops the snapshot once the walk has stopped reading it
 }
 
 // NewClientV2 creates a new etcd v2 client
@@ -53,25 +76,42 @@ func NewClientV2(
 		numGetKeysLimit:       numGetKeysLimit,
 		EtcdAuditPeriod:       time.Duration(etcdAuditPeriod) * time.Second,
 		maxWatchRetries:       maxWatchRetries,
-		ExpectedModIndex:      0,
 		endpoints:             endpoints,
+		snapshots:             make(map[snapshotKey]*keySnapshot),
 	}, nil
 }
 
-func (c *ClientV2) Get(ctx context.Context, key string) (string, error) {
+// Get reads the key at the latest index, the v2 API keeps no past versions of the keys
+func (c *ClientV2) Get(ctx context.Context, key string, revision int64) (KeyValue, error) {
+	if revision > 0 {
+		return KeyValue{}, customerrors.ErrRevisionUnsupported
+	}
+
 	resp, err := c.client.Get(ctx, key, nil)
 	if err != nil {
 		if etcdv2.IsKeyNotFound(err) {
-			return "", customerrors.ErrKeyNotFound
+			return KeyValue{}, customerrors.ErrKeyNotFound
 		}
-		return "", fmt.Errorf("failed to get key: %w", err)
+		return KeyValue{}, fmt.Errorf("failed to get key: %w", err)
 	}
 
 	if resp.Node == nil {
-		return "", customerrors.ErrKeyNotFound
+		return KeyValue{}, customerrors.ErrKeyNotFound
 	}
 
-	return resp.Node.Value, nil
+	return KeyValue{
+		Key:            resp.Node.Key,
+		Value:          resp.Node.Value,
+		CreateRevision: int64(resp.Node.CreatedIndex),
+		ModRevision:    int64(resp.Node.ModifiedIndex),
+		TTL:            resp.Node.TTL,
+		Expiration:     resp.Node.Expiration,
+	}, nil
+}
+
+// GetHistory is not supported, the v2 API keeps no past versions of the keys
+func (c *ClientV2) GetHistory(ctx context.Context, key string, revision, limit int64) (KeyHistory, error) {
+	return KeyHistory{}, customerrors.ErrRevisionUnsupported
 }
 
 func (c *ClientV2) Put(ctx context.Context, key string, value string) (string, error) {
@@ -85,6 +125,112 @@ func (c *ClientV2) Put(ctx context.Context, key string, value string) (string, e
 	return resp.Node.Key, nil
 }
 
+// CompareAndPut sets the key with the prevIndex, prevValue and prevExist conditions of cond
+func (c *ClientV2) CompareAndPut(ctx context.Context, key string, value string, cond Precondition) (int64, error) {
+	prevIndex, prevValue, err := c.prevConditions(ctx, key, cond)
+	if err != nil {
+		return 0, err
+	}
+	opts := &etcdv2.SetOptions{
+		PrevIndex: prevIndex,
+		PrevValue: prevValue,
+	}
+	if cond.Absent {
+		opts.PrevExist = etcdv2.PrevNoExist
+	}
+
+	resp, err := c.client.Set(ctx, key, value, opts)
+	if err != nil {
+		if isConditionFailed(err) {
+			return 0, c.conflictError(ctx, key)
+		}
+		return 0, fmt.Errorf("failed to put key: %w", err)
+	}
+	if resp.Node == nil {
+		return 0, customerrors.ErrKeyNotPut
+	}
+	return int64(resp.Node.ModifiedIndex), nil
+}
+
+// CompareAndDelete deletes the key with the prevIndex and prevValue conditions of cond
+func (c *ClientV2) CompareAndDelete(ctx context.Context, key string, cond Precondition) (int64, error) {
+	if cond.Absent {
+		return 0, c.conflictError(ctx, key)
+	}
+
+	prevIndex, prevValue, err := c.prevConditions(ctx, key, cond)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.client.Delete(ctx, key, &etcdv2.DeleteOptions{
+		PrevIndex: prevIndex,
+		PrevValue: prevValue,
+	})
+	if err != nil {
+		if isConditionFailed(err) {
+			return 0, c.conflictError(ctx, key)
+		}
+		return 0, fmt.Errorf("failed to delete key: %w", err)
+	}
+	if resp.Node == nil {
+		return 0, customerrors.ErrKeyNotDeleted
+	}
+	return int64(resp.Node.ModifiedIndex), nil
+}
+
+// prevConditions returns the prevIndex and prevValue options checking cond
+// The v2 API ignores an empty prevValue, so an empty expected value is checked on a read of the key
+// and the write is then guarded on the modified index it was read at
+func (c *ClientV2) prevConditions(ctx context.Context, key string, cond Precondition) (uint64, string, error) {
+	if cond.PrevValue == nil || *cond.PrevValue != "" {
+		var prevValue string
+		if cond.PrevValue != nil {
+			prevValue = *cond.PrevValue
+		}
+		return uint64(cond.ModRevision), prevValue, nil
+	}
+
+	kv, err := c.Get(ctx, key, 0)
+	if errors.Is(err, customerrors.ErrKeyNotFound) {
+		return 0, "", conflictError(key, 0)
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	if kv.Value != "" || (cond.ModRevision > 0 && kv.ModRevision != cond.ModRevision) {
+		return 0, "", conflictError(key, kv.ModRevision)
+	}
+	return uint64(kv.ModRevision), "", nil
+}
+
+// isConditionFailed reports whether a conditional write failed because the key does not satisfy its conditions
+func isConditionFailed(err error) bool {
+	var etcdErr etcdv2.Error
+	if !errors.As(err, &etcdErr) {
+		return false
+	}
+	switch etcdErr.Code {
+	case etcdv2.ErrorCodeTestFailed, etcdv2.ErrorCodeNodeExist, etcdv2.ErrorCodeKeyNotFound:
+		return true
+	}
+	return false
+}
+
+// conflictError reads the current modified index of the key for the conflict error
+// The v2 API does not return it with the failed condition, so it may already be outdated
+func (c *ClientV2) conflictError(ctx context.Context, key string) error {
+	kv, err := c.Get(ctx, key, 0)
+	if err != nil && !errors.Is(err, customerrors.ErrKeyNotFound) {
+		return fmt.Errorf("%w: %s has changed", customerrors.ErrKeyModified, key)
+	}
+	return conflictError(key, kv.ModRevision)
+}
+
+// Txn is not supported, the v2 API has no multi-key transactions
+func (c *ClientV2) Txn(ctx context.Context, txn Txn) (TxnResult, error) {
+	return TxnResult{}, customerrors.ErrTxnUnsupported
+}
+
 func (c *ClientV2) Delete(ctx context.Context, key string) (string, error) {
 	resp, err := c.client.Delete(ctx, key, &etcdv2.DeleteOptions{})
 	if err != nil {
@@ -101,7 +247,8 @@ func (c *ClientV2) Delete(ctx context.Context, key string) (string, error) {
 
 // Watch watches for changes on keys
 // Returns a channel of WatchEvents and an error channel
-func (c *ClientV2) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error) {
+// If the requested index has been cleared from the event history, customerrors.ErrRevisionCompacted is sent on the error channel
+func (c *ClientV2) Watch(ctx context.Context, fromRevision int64) (<-chan WatchEvent, <-chan error) {
 	eventCh := make(chan WatchEvent, c.watchEventChannelSize)
 	errCh := make(chan error, 1)
 
@@ -110,6 +257,8 @@ func (c *ClientV2) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error)
 		defer close(errCh)
 		watchIndexDiscrepancy := false
 		var consecutiveFailureCount int64
+		// Each watch tracks its own expected index, a cancelled watch may still be running
+		var expectedModIndex uint64
 
 		for {
 			var watcher etcdv2.Watcher
@@ -117,8 +266,10 @@ func (c *ClientV2) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error)
 				Recursive: true,
 			}
 
-			if watchIndexDiscrepancy && c.ExpectedModIndex > 0 {
-				watchOpts.AfterIndex = c.ExpectedModIndex - 1
+			if watchIndexDiscrepancy && expectedModIndex > 0 {
+				watchOpts.AfterIndex = expectedModIndex - 1
+			} else if fromRevision > 0 {
+				watchOpts.AfterIndex = uint64(fromRevision) - 1
 			}
 
 			watcher = c.client.Watcher(c.rootPrefixEtcd, watchOpts)
@@ -129,6 +280,11 @@ func (c *ClientV2) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error)
 					if ctx.Err() != nil {
 						return // Context cancelled
 					}
+					var etcdErr etcdv2.Error
+					if errors.As(err, &etcdErr) && etcdErr.Code == etcdv2.ErrorCodeEventIndexCleared {
+						errCh <- fmt.Errorf("%w: %s", customerrors.ErrRevisionCompacted, etcdErr.Cause)
+						return
+					}
 					errCh <- fmt.Errorf("watch error: %w", err)
 					return
 				}
@@ -138,18 +294,18 @@ func (c *ClientV2) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error)
 				}
 
 				// If this is the first event, set the expected modindex
-				if c.ExpectedModIndex == 0 {
-					c.ExpectedModIndex = resp.Node.ModifiedIndex
+				if expectedModIndex == 0 {
+					expectedModIndex = resp.Node.ModifiedIndex
 				}
 
 				// Check if the modindex is not equal to the expected modindex
-				if resp.Node.ModifiedIndex != c.ExpectedModIndex {
+				if resp.Node.ModifiedIndex != expectedModIndex {
 					consecutiveFailureCount++
-					logger.Warnf("ModIndex mismatch: Consecutive failure #%d on ModIndex %d", consecutiveFailureCount, c.ExpectedModIndex)
+					logger.Warnf("ModIndex mismatch: Consecutive failure #%d on ModIndex %d", consecutiveFailureCount, expectedModIndex)
 
 					// If we've exceeded max retries on the same index, fail fast
 					if consecutiveFailureCount >= c.maxWatchRetries {
-						errCh <- fmt.Errorf("exceeded max watch retries (%d) on ModIndex %d - failing fast to prevent infinite loop", c.maxWatchRetries, c.ExpectedModIndex)
+						errCh <- fmt.Errorf("exceeded max watch retries (%d) on ModIndex %d - failing fast to prevent infinite loop", c.maxWatchRetries, expectedModIndex)
 						return
 					}
 					watchIndexDiscrepancy = true
@@ -158,10 +314,12 @@ func (c *ClientV2) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error)
 
 				// Successfully processed an event, reset failure counter
 				consecutiveFailureCount = 0
-				c.ExpectedModIndex = resp.Node.ModifiedIndex + 1
+				expectedModIndex = resp.Node.ModifiedIndex + 1
 
 				watchEvent := WatchEvent{
-					Key: resp.Node.Key,
+					Key:        resp.Node.Key,
+					Revision:   int64(resp.Node.ModifiedIndex),
+					ReceivedAt: time.Now(),
 				}
 
 				switch resp.Action {
@@ -174,6 +332,7 @@ func (c *ClientV2) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error)
 					// Skip unknown actions
 					continue
 				}
+				c.lastWatchedIndex.Store(watchEvent.Revision)
 
 				select {
 				case eventCh <- watchEvent:
@@ -194,80 +353,283 @@ func (c *ClientV2) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error)
 }
 
 // GetKeysWithPagination retrieves keys with pagination support
-func (c *ClientV2) GetKeysWithPagination(ctx context.Context, fromKey string) ([]common.KV, string, error) {
-	// Always fetch from root to ensure we can traverse the tree
-	getKey := c.rootPrefixEtcd
+// The v2 API has no multi-version store nor ranges, so the first page reads the whole directory named
+// by prefix at the latest index and sorts its keys, and the next pages, read at the returned index,
+// are cut from the same snapshot for as long as it is kept, after which they read the latest directory again
+// The returned revision is the etcd index the keys were read at
+func (c *ClientV2) GetKeysWithPagination(ctx context.Context, prefix, fromKey string, revision int64) ([]common.KV, string, int64, error) {
+	dir := c.rootPrefixEtcd
+	if prefix != "" {
+		dir = dirKey(prefix)
+	}
+	all, index, err := c.sortedKeys(ctx, dir, revision)
+	if err != nil {
+		return nil, "", 0, err
+	}
 
-	opts := &etcdv2.GetOptions{
-		Recursive: true,
-		Sort:      true,
+	start, found := slices.BinarySearchFunc(all, fromKey, func(kv common.KV, key string) int {
+		return strings.Compare(kv.Key, key)
+	})
+	if found {
+		start++
 	}
+	keys := slices.Clone(all[start:min(start+int(c.numGetKeysLimit), len(all))])
 
-	resp, err := c.client.Get(ctx, getKey, opts)
-	if err != nil {
-		if etcdv2.IsKeyNotFound(err) {
-			return []common.KV{}, "", nil
-		}
-		return nil, "", fmt.Errorf("failed to get keys: %w", err)
+	if len(keys) == 0 {
+		// The walk is over, its snapshot is no longer needed
+		c.dropSnapshot(snapshotKey{dir: dir, index: index})
+		return keys, "", index, nil
 	}
 
-	keys := make([]common.KV, 0)
+	// If result is full, return nextKey.
+	// Note: If we reached exactly end of list and it's full, we still return nextKey.
+	// The next call will return empty, which ends pagination.
+	return keys, keys[len(keys)-1].Key, index, nil
+}
 
-	// skipping indicates if we are currently skipping keys until we find fromKey
-	skipping := fromKey != ""
+// sortedKeys returns the keys of the directory in key order and the index they were read at,
+// from the snapshot taken at revision if it is still kept, from the latest directory otherwise
+func (c *ClientV2) sortedKeys(ctx context.Context, dir string, revision int64) ([]common.KV, int64, error) {
+	if revision > 0 {
+		c.snapshotsMu.Lock()
+		snapshot, ok := c.snapshots[snapshotKey{dir: dir, index: revision}]
+		if ok {
+			snapshot.timer.Reset(keySnapshotTTL)
+		}
+		c.snapshotsMu.Unlock()
+		if ok {
+			return snapshot.kvs, revision, nil
+		}
+	}
 
-	// Recursively collect all keys from the node tree
+	resp, err := c.client.Get(ctx, dir, &etcdv2.GetOptions{
+		Recursive: true,
+		Sort:      true,
+	})
+	if err != nil {
+		// The error carries the current etcd index when the directory does not exist yet
+		if etcdErr, ok := err.(etcdv2.Error); ok && etcdErr.Code == etcdv2.ErrorCodeKeyNotFound {
+			return nil, int64(etcdErr.Index), nil
+		}
+		return nil, 0, fmt.Errorf("failed to get keys: %w", err)
+	}
+
+	// The tree is walked depth first, which does not follow the key order when a directory has
+	// siblings sorting between its name and its keys, e.g. a-b between a and a/b, so the keys
+	// are sorted to be paged like on v3
+	var kvs []common.KV
 	var collectKeys func(node *etcdv2.Node)
 	collectKeys = func(node *etcdv2.Node) {
 		if node == nil {
 			return
 		}
-
-		// Check if we've reached the limit
-		if int64(len(keys)) >= c.numGetKeysLimit {
-			return
-		}
-
 		if !node.Dir {
-			// Leaf node logic
-			if skipping {
-				if node.Key == fromKey {
-					skipping = false
-				}
-				// If we were skipping, we don't add the key (even the one that matched)
-				// The next key encountered will be added.
-				return
-			}
-
-			// Not skipping, add key
-			keys = append(keys, common.KV{
+			kvs = append(kvs, common.KV{
 				Key:   node.Key,
 				Value: node.Value,
 			})
 			return
 		}
+		for _, child := range node.Nodes {
+			collectKeys(child)
+		}
+	}
+	collectKeys(resp.Node)
+	slices.SortFunc(kvs, func(a, b common.KV) int { return strings.Compare(a.Key, b.Key) })
+
+	index := int64(resp.Index)
+	key := snapshotKey{dir: dir, index: index}
+	c.snapshotsMu.Lock()
+	defer c.snapshotsMu.Unlock()
+	if old, ok := c.snapshots[key]; ok {
+		old.timer.Stop()
+	}
+	c.snapshots[key] = &keySnapshot{
+		kvs:   kvs,
+		timer: time.AfterFunc(keySnapshotTTL, func() { c.dropSnapshot(key) }),
+	}
+	return kvs, index, nil
+}
 
-		// directory node - process sorted children
-		if node.Nodes != nil {
-			for _, child := range node.Nodes {
-				if int64(len(keys)) >= c.numGetKeysLimit {
-					return
-				}
-				collectKeys(child)
-			}
+// dropSnapshot releases the sorted keys of a directory read at an index
+func (c *ClientV2) dropSnapshot(key snapshotKey) {
+	c.snapshotsMu.Lock()
+	defer c.snapshotsMu.Unlock()
+	if snapshot, ok := c.snapshots[key]; ok {
+		snapshot.timer.Stop()
+		delete(c.snapshots, key)
+	}
+}
+
+// ListChildren returns a page of the immediate children of prefix, read from the directory nodes of etcd
+// Every key starts with the root directory, so the empty prefix lists the root directory
+// Unlike on v3, empty directories are listed as well
+func (c *ClientV2) ListChildren(ctx context.Context, prefix string, offset, limit int64) (Children, error) {
+	resp, err := c.client.Get(ctx, dirKey(prefix), &etcdv2.GetOptions{Recursive: true})
+	if err != nil {
+		if etcdv2.IsKeyNotFound(err) {
+			return pageChildren(nil, 0, offset, limit), nil
 		}
+		return Children{}, fmt.Errorf("failed to list keys: %w", err)
+	}
+	// A leaf key has no children
+	if resp.Node == nil || !resp.Node.Dir {
+		return pageChildren(nil, 0, offset, limit), nil
 	}
 
-	collectKeys(resp.Node)
+	children := make([]Child, 0, len(resp.Node.Nodes))
+	var totalKeys int64
+	for _, node := range resp.Node.Nodes {
+		child := Child{
+			Name: path.Base(node.Key),
+			Key:  node.Key,
+			Keys: 1,
+		}
+		if node.Dir {
+			child.Name += PathSeparator
+			child.Key += PathSeparator
+			child.Dir = true
+			child.Keys = countLeaves(node)
+		}
+		totalKeys += child.Keys
+		children = append(children, child)
+	}
+
+	return pageChildren(children, totalKeys, offset, limit), nil
+}
+
+// GetPrefix reads the keys under prefix, which names a directory on etcd v2
+func (c *ClientV2) GetPrefix(ctx context.Context, prefix string, limit int64) ([]KeyValue, error) {
+	kvs, _, err := c.prefixKVs(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return kvs[:min(int64(len(kvs)), limit)], nil
+}
+
+// PreviewPrefix reads the keys under prefix, which names a directory on etcd v2
+func (c *ClientV2) PreviewPrefix(ctx context.Context, prefix string, sampleSize int64) (PrefixPreview, error) {
+	if err := checkDeletePrefix(prefix, c.rootPrefixEtcd); err != nil {
+		return PrefixPreview{}, err
+	}
+	kvs, index, err := c.prefixKVs(ctx, prefix)
+	if err != nil {
+		return PrefixPreview{}, err
+	}
+	preview := PrefixPreview{
+		Count:    int64(len(kvs)),
+		Keys:     make([]string, 0, min(int64(len(kvs)), sampleSize)),
+		Revision: index,
+	}
+	for _, kv := range kvs[:cap(preview.Keys)] {
+		preview.Keys = append(preview.Keys, kv.Key)
+	}
+	return preview, nil
+}
 
+// DeletePrefix deletes the directory named by prefix recursively
+// The v2 API cannot guard a recursive delete, so the keys are checked just before deleting them
+func (c *ClientV2) DeletePrefix(ctx context.Context, prefix string, revision int64) ([]string, error) {
+	if err := checkDeletePrefix(prefix, c.rootPrefixEtcd); err != nil {
+		return nil, err
+	}
+	kvs, _, err := c.prefixKVs(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(kvs))
+	for _, kv := range kvs {
+		if revision > 0 && kv.ModRevision > revision {
+			return nil, prefixChangedError(prefix, revision)
+		}
+		keys = append(keys, kv.Key)
+	}
 	if len(keys) == 0 {
-		return keys, "", nil
+		return keys, nil
 	}
 
-	// If result is full, return nextKey.
-	// Note: If we reached exactly end of list and it's full, we still return nextKey.
-	// The next call will return empty, which ends pagination.
-	return keys, keys[len(keys)-1].Key, nil
+	if _, err := c.client.Delete(ctx, dirKey(prefix), &etcdv2.DeleteOptions{Recursive: true}); err != nil {
+		if etcdv2.IsKeyNotFound(err) {
+			return nil, prefixChangedError(prefix, revision)
+		}
+		return nil, fmt.Errorf("failed to delete keys: %w", err)
+	}
+	return keys, nil
+}
+
+// prefixKVs returns the keys under the directory named by prefix in key order, and the index they were read at
+func (c *ClientV2) prefixKVs(ctx context.Context, prefix string) ([]KeyValue, int64, error) {
+	resp, err := c.client.Get(ctx, dirKey(prefix), &etcdv2.GetOptions{Recursive: true, Sort: true})
+	if err != nil {
+		var etcdErr etcdv2.Error
+		if errors.As(err, &etcdErr) && etcdErr.Code == etcdv2.ErrorCodeKeyNotFound {
+			return []KeyValue{}, int64(etcdErr.Index), nil
+		}
+		return nil, 0, fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	kvs := []KeyValue{}
+	var collect func(node *etcdv2.Node)
+	collect = func(node *etcdv2.Node) {
+		if !node.Dir {
+			kvs = append(kvs, KeyValue{
+				Key:            node.Key,
+				Value:          node.Value,
+				CreateRevision: int64(node.CreatedIndex),
+				ModRevision:    int64(node.ModifiedIndex),
+				TTL:            node.TTL,
+				Expiration:     node.Expiration,
+			})
+			return
+		}
+		for _, child := range node.Nodes {
+			collect(child)
+		}
+	}
+	if resp.Node != nil {
+		collect(resp.Node)
+	}
+	return kvs, int64(resp.Index), nil
+}
+
+// dirKey returns the key of the directory named by prefix, the root directory for the empty prefix
+func dirKey(prefix string) string {
+	dir := strings.TrimSuffix(prefix, PathSeparator)
+	if dir == "" {
+		return PathSeparator
+	}
+	return dir
+}
+
+// countLeaves returns the number of keys under a directory node at any depth
+func countLeaves(node *etcdv2.Node) int64 {
+	var count int64
+	for _, child := range node.Nodes {
+		if child.Dir {
+			count += countLeaves(child)
+		} else {
+			count++
+		}
+	}
+	return count
+}
+
+// GetRevision returns the current index of the etcd cluster
+func (c *ClientV2) GetRevision(ctx context.Context) (int64, error) {
+	resp, err := c.client.Get(ctx, c.rootPrefixEtcd, nil)
+	if err != nil {
+		// The error carries the current etcd index when the root prefix does not exist yet
+		if etcdErr, ok := err.(etcdv2.Error); ok && etcdErr.Code == etcdv2.ErrorCodeKeyNotFound {
+			return int64(etcdErr.Index), nil
+		}
+		return 0, fmt.Errorf("failed to get index: %w", err)
+	}
+	return int64(resp.Index), nil
+}
+
+// LastWatchedRevision returns the ModifiedIndex of the newest event received by the watch, 0 if there is none
+func (c *ClientV2) LastWatchedRevision() int64 {
+	return c.lastWatchedIndex.Load()
 }
 
 // StartAuditor starts a background goroutine that checks etcd connection health every EtcdAuditPeriod
@@ -2,12 +2,15 @@ package etcd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/etcdfinder/etcdfinder/internal/customerrors"
 	"github.com/etcdfinder/etcdfinder/pkg/common"
 	"github.com/etcdfinder/etcdfinder/pkg/logger"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
@@ -19,14 +22,17 @@ type Client struct {
 	numGetKeysLimit       int64  // number of keys to be returned in a single GetKeysWithPagination call
 	EtcdAuditPeriod       time.Duration
 	maxWatchRetries       int64 // maximum number of consecutive failures on the same ModRevision
-	ExpectedModRevision   int64 // expected modified revision of the etcd keys
+	maxHistorySearch      int64 // revisions searched back for the last deletion of a key, 0 searches back to the compacted revision
+	lastWatchedRevision   atomic.Int64
 }
 
 // WatchEvent represents a change event from etcd
 type WatchEvent struct {
-	Type  string
-	Key   string
-	Value string
+	Type       string
+	Key        string
+	Value      string
+	Revision   int64     // ModRevision (v3) or ModifiedIndex (v2) of the event
+	ReceivedAt time.Time // time the event was received from etcd
 }
 
 // NewClient creates a new etcd client
@@ -36,7 +42,8 @@ func NewClientV3(
 	rootPrefixEtcd string,
 	numGetKeysLimit int64,
 	etcdAuditPeriod int64,
-	maxWatchRetries int64) (BaseClient, error) {
+	maxWatchRetries int64,
+	maxHistorySearch int64) (BaseClient, error) {
 	if numGetKeysLimit <= 0 {
 		return nil, fmt.Errorf("numGetKeysLimit must be greater than 0")
 	}
@@ -55,21 +62,50 @@ func NewClientV3(
 		numGetKeysLimit:       numGetKeysLimit,
 		EtcdAuditPeriod:       time.Duration(etcdAuditPeriod) * time.Second,
 		maxWatchRetries:       maxWatchRetries,
-		ExpectedModRevision:   -1,
+		maxHistorySearch:      maxHistorySearch,
 	}, nil
 }
 
-func (c *Client) Get(ctx context.Context, key string) (string, error) {
-	resp, err := c.client.Get(ctx, key)
+func (c *Client) Get(ctx context.Context, key string, revision int64) (KeyValue, error) {
+	var opts []clientv3.OpOption
+	if revision > 0 {
+		opts = append(opts, clientv3.WithRev(revision))
+	}
+	resp, err := c.client.Get(ctx, key, opts...)
 	if err != nil {
-		return "", fmt.Errorf("failed to get key: %w", err)
+		if errors.Is(err, rpctypes.ErrCompacted) {
+			return KeyValue{}, fmt.Errorf("%w: failed to get key at revision %d", customerrors.ErrRevisionCompacted, revision)
+		}
+		if errors.Is(err, rpctypes.ErrFutureRev) {
+			return KeyValue{}, fmt.Errorf("%w: revision %d is a future revision", customerrors.ErrInvalidRevision, revision)
+		}
+		return KeyValue{}, fmt.Errorf("failed to get key: %w", err)
 	}
 
 	if len(resp.Kvs) == 0 {
-		return "", customerrors.ErrKeyNotFound
+		return KeyValue{}, customerrors.ErrKeyNotFound
+	}
+
+	kv := resp.Kvs[0]
+	keyValue := KeyValue{
+		Key:            string(kv.Key),
+		Value:          string(kv.Value),
+		CreateRevision: kv.CreateRevision,
+		ModRevision:    kv.ModRevision,
+		Version:        kv.Version,
+		Lease:          kv.Lease,
+	}
+
+	// The remaining TTL is held by the lease, not by the key, so it only describes the latest revision
+	if kv.Lease != 0 && revision == 0 {
+		leaseResp, err := c.client.TimeToLive(ctx, clientv3.LeaseID(kv.Lease))
+		if err != nil {
+			return KeyValue{}, fmt.Errorf("failed to get lease TTL: %w", err)
+		}
+		keyValue.TTL = leaseResp.TTL
 	}
 
-	return string(resp.Kvs[0].Value), nil
+	return keyValue, nil
 }
 
 func (c *Client) Put(ctx context.Context, key string, value string) (string, error) {
@@ -80,6 +116,152 @@ func (c *Client) Put(ctx context.Context, key string, value string) (string, err
 	return key, nil
 }
 
+// CompareAndPut puts the key in a transaction guarded on cond
+func (c *Client) CompareAndPut(ctx context.Context, key string, value string, cond Precondition) (int64, error) {
+	resp, err := c.client.Txn(ctx).
+		If(compares(key, cond)...).
+		Then(clientv3.OpPut(key, value)).
+		Else(clientv3.OpGet(key, clientv3.WithKeysOnly())).
+		Commit()
+	if err != nil {
+		return 0, fmt.Errorf("failed to put key: %w", err)
+	}
+	if !resp.Succeeded {
+		return 0, conflictError(key, currentModRevision(resp))
+	}
+	return resp.Header.Revision, nil
+}
+
+// CompareAndDelete deletes the key in a transaction guarded on cond
+func (c *Client) CompareAndDelete(ctx context.Context, key string, cond Precondition) (int64, error) {
+	resp, err := c.client.Txn(ctx).
+		If(compares(key, cond)...).
+		Then(clientv3.OpDelete(key)).
+		Else(clientv3.OpGet(key, clientv3.WithKeysOnly())).
+		Commit()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete key: %w", err)
+	}
+	if !resp.Succeeded {
+		return 0, conflictError(key, currentModRevision(resp))
+	}
+	return resp.Header.Revision, nil
+}
+
+// compares returns the transaction comparisons checking cond
+func compares(key string, cond Precondition) []clientv3.Cmp {
+	var cmps []clientv3.Cmp
+	if cond.Absent {
+		// A missing key has a create revision of 0
+		cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+	}
+	if cond.ModRevision > 0 {
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", cond.ModRevision))
+	}
+	if cond.PrevValue != nil {
+		cmps = append(cmps, clientv3.Compare(clientv3.Value(key), "=", *cond.PrevValue))
+	}
+	return cmps
+}
+
+// currentModRevision returns the mod revision read by the else branch of a failed transaction, 0 if the key does not exist
+func currentModRevision(resp *clientv3.TxnResponse) int64 {
+	if len(resp.Responses) == 0 {
+		return 0
+	}
+	kvs := resp.Responses[0].GetResponseRange().GetKvs()
+	if len(kvs) == 0 {
+		return 0
+	}
+	return kvs[0].ModRevision
+}
+
+// Txn runs the operations of txn in a single etcd transaction
+// Keys read by a get come without their TTL, which is held by their lease
+func (c *Client) Txn(ctx context.Context, txn Txn) (TxnResult, error) {
+	cmps := make([]clientv3.Cmp, 0, len(txn.Compares))
+	for _, cmp := range txn.Compares {
+		cmps = append(cmps, txnCompare(cmp))
+	}
+	resp, err := c.client.Txn(ctx).
+		If(cmps...).
+		Then(txnOps(txn.Then)...).
+		Else(txnOps(txn.Else)...).
+		Commit()
+	if err != nil {
+		if errors.Is(err, rpctypes.ErrDuplicateKey) || errors.Is(err, rpctypes.ErrTooManyOps) {
+			return TxnResult{}, fmt.Errorf("%w: %s", customerrors.ErrInvalidTxn, rpctypes.ErrorDesc(err))
+		}
+		return TxnResult{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	ops := txn.Then
+	if !resp.Succeeded {
+		ops = txn.Else
+	}
+	result := TxnResult{
+		Succeeded: resp.Succeeded,
+		Revision:  resp.Header.Revision,
+		Results:   make([]OpResult, 0, len(ops)),
+	}
+	for i, op := range ops {
+		opResult := OpResult{Type: op.Type, Key: op.Key}
+		switch op.Type {
+		case OpGet:
+			if kvs := resp.Responses[i].GetResponseRange().GetKvs(); len(kvs) > 0 {
+				opResult.KV = &KeyValue{
+					Key:            string(kvs[0].Key),
+					Value:          string(kvs[0].Value),
+					CreateRevision: kvs[0].CreateRevision,
+					ModRevision:    kvs[0].ModRevision,
+					Version:        kvs[0].Version,
+					Lease:          kvs[0].Lease,
+				}
+			}
+		case OpDelete:
+			opResult.Deleted = resp.Responses[i].GetResponseDeleteRange().GetDeleted()
+		}
+		result.Results = append(result.Results, opResult)
+	}
+	return result, nil
+}
+
+// txnCompare returns the transaction comparison checking cmp
+func txnCompare(cmp Compare) clientv3.Cmp {
+	op := string(cmp.Op)
+	switch cmp.Target {
+	case CompareValue:
+		return clientv3.Compare(clientv3.Value(cmp.Key), op, cmp.Value)
+	case CompareVersion:
+		return clientv3.Compare(clientv3.Version(cmp.Key), op, cmp.Number)
+	case CompareModRevision:
+		return clientv3.Compare(clientv3.ModRevision(cmp.Key), op, cmp.Number)
+	case CompareCreateRevision:
+		return clientv3.Compare(clientv3.CreateRevision(cmp.Key), op, cmp.Number)
+	}
+	// A missing key has a create revision of 0
+	if cmp.Exists {
+		return clientv3.Compare(clientv3.CreateRevision(cmp.Key), ">", 0)
+	}
+	return clientv3.Compare(clientv3.CreateRevision(cmp.Key), "=", 0)
+}
+
+// txnOps returns the transaction operations running ops
+func txnOps(ops []Op) []clientv3.Op {
+	clientOps := make([]clientv3.Op, 0, len(ops))
+	for _, op := range ops {
+		switch op.Type {
+		case OpPut:
+			clientOps = append(clientOps, clientv3.OpPut(op.Key, op.Value))
+		case OpDelete:
+			clientOps = append(clientOps, clientv3.OpDelete(op.Key))
+		case OpGet:
+			clientOps = append(clientOps, clientv3.OpGet(op.Key))
+		}
+	}
+	return clientOps
+}
+
 func (c *Client) Delete(ctx context.Context, key string) (string, error) {
 	_, err := c.client.Delete(ctx, key)
 	if err != nil {
@@ -88,9 +270,182 @@ func (c *Client) Delete(ctx context.Context, key string) (string, error) {
 	return key, nil
 }
 
+// GetPrefix reads the first keys under prefix in a single range request
+func (c *Client) GetPrefix(ctx context.Context, prefix string, limit int64) ([]KeyValue, error) {
+	resp, err := c.client.Get(ctx, DirPrefix(prefix), clientv3.WithPrefix(), clientv3.WithLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys: %w", err)
+	}
+	kvs := make([]KeyValue, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		kvs = append(kvs, KeyValue{
+			Key:            string(kv.Key),
+			Value:          string(kv.Value),
+			CreateRevision: kv.CreateRevision,
+			ModRevision:    kv.ModRevision,
+			Version:        kv.Version,
+			Lease:          kv.Lease,
+		})
+	}
+	return kvs, nil
+}
+
+// PreviewPrefix reads the first keys under prefix, etcd counts all of them in the same range request
+func (c *Client) PreviewPrefix(ctx context.Context, prefix string, sampleSize int64) (PrefixPreview, error) {
+	if err := checkDeletePrefix(prefix, c.rootPrefixEtcd); err != nil {
+		return PrefixPreview{}, err
+	}
+	resp, err := c.client.Get(ctx, DirPrefix(prefix),
+		clientv3.WithPrefix(),
+		clientv3.WithKeysOnly(),
+		clientv3.WithLimit(sampleSize))
+	if err != nil {
+		return PrefixPreview{}, fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	preview := PrefixPreview{
+		Count:    resp.Count,
+		Keys:     make([]string, 0, len(resp.Kvs)),
+		Revision: resp.Header.Revision,
+	}
+	for _, kv := range resp.Kvs {
+		preview.Keys = append(preview.Keys, string(kv.Key))
+	}
+	return preview, nil
+}
+
+// DeletePrefix deletes the keys under prefix in a transaction guarded on their mod revisions
+func (c *Client) DeletePrefix(ctx context.Context, prefix string, revision int64) ([]string, error) {
+	if err := checkDeletePrefix(prefix, c.rootPrefixEtcd); err != nil {
+		return nil, err
+	}
+	dir := DirPrefix(prefix)
+	var cmps []clientv3.Cmp
+	if revision > 0 {
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(dir), "<", revision+1).WithPrefix())
+	}
+	resp, err := c.client.Txn(ctx).
+		If(cmps...).
+		Then(clientv3.OpDelete(dir, clientv3.WithPrefix(), clientv3.WithPrevKV())).
+		Commit()
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete keys: %w", err)
+	}
+	if !resp.Succeeded {
+		return nil, prefixChangedError(prefix, revision)
+	}
+
+	prevKvs := resp.Responses[0].GetResponseDeleteRange().GetPrevKvs()
+	keys := make([]string, 0, len(prevKvs))
+	for _, kv := range prevKvs {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys, nil
+}
+
+// GetHistory returns a page of the past versions of the key, read with one Get per version
+// The walk stops at the creation of the key or at the compacted revisions
+func (c *Client) GetHistory(ctx context.Context, key string, revision, limit int64) (KeyHistory, error) {
+	return walkHistory(ctx, c.Get, c.lastDeletion, key, revision, limit)
+}
+
+// lastDeletion returns the revision of the last deletion of the key at or before revision,
+// 0 if there is none after the compacted revisions or within maxHistorySearch revisions
+// The deletions are replayed by watches over windows of revisions going back from revision, each twice as large as the previous one
+func (c *Client) lastDeletion(ctx context.Context, key string, revision int64) (int64, error) {
+	if revision <= 0 {
+		var err error
+		revision, err = c.GetRevision(ctx)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// A key that never existed has no deletion to find, the search stops maxHistorySearch revisions back
+	floor := int64(1)
+	if c.maxHistorySearch > 0 {
+		floor = max(revision-c.maxHistorySearch+1, 1)
+	}
+	to := revision
+	for window := int64(deletionSearchWindow); to >= floor; window *= 2 {
+		from := max(to-window+1, floor)
+		deletion, compactRevision, err := c.lastDeletionBetween(ctx, key, from, to)
+		if err != nil {
+			return 0, err
+		}
+		// The revisions before the compacted one are gone, only the ones after it are left to search
+		if compactRevision > 0 {
+			if compactRevision >= to {
+				return 0, nil
+			}
+			deletion, _, err = c.lastDeletionBetween(ctx, key, compactRevision+1, to)
+			return deletion, err
+		}
+		if deletion > 0 {
+			return deletion, nil
+		}
+		to = from - 1
+	}
+	return 0, nil
+}
+
+// lastDeletionBetween returns the revision of the last deletion of the key from revision from to revision to, 0 if there is none
+// If from has been compacted, the compacted revision is returned instead
+func (c *Client) lastDeletionBetween(ctx context.Context, key string, from, to int64) (int64, int64, error) {
+	// A watcher of its own keeps the progress notifications of other watches from ending the search
+	watcher := clientv3.NewWatcher(c.client)
+	defer watcher.Close() //nolint
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var deletion int64
+	watchCh := watcher.Watch(watchCtx, key,
+		clientv3.WithRev(from),
+		clientv3.WithFilterPut(),
+		clientv3.WithCreatedNotify())
+	for resp := range watchCh {
+		if resp.CompactRevision > 0 {
+			return 0, resp.CompactRevision, nil
+		}
+		if err := resp.Err(); err != nil {
+			return 0, 0, fmt.Errorf("failed to watch deletions: %w", err)
+		}
+
+		// etcd sends the progress requested once every event up to its revision has been sent
+		if resp.Created {
+			if err := watcher.RequestProgress(watchCtx); err != nil {
+				return 0, 0, fmt.Errorf("failed to request watch progress: %w", err)
+			}
+			continue
+		}
+		if resp.IsProgressNotify() {
+			if resp.Header.Revision >= to {
+				return deletion, 0, nil
+			}
+			if err := watcher.RequestProgress(watchCtx); err != nil {
+				return 0, 0, fmt.Errorf("failed to request watch progress: %w", err)
+			}
+			continue
+		}
+
+		for _, event := range resp.Events {
+			if event.Kv.ModRevision > to {
+				return deletion, 0, nil
+			}
+			deletion = event.Kv.ModRevision
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+	return 0, 0, errors.New("failed to watch deletions: watch closed")
+}
+
 // WatchPrefix watches for changes on keys
 // Returns a channel of WatchEvents and an error channel
-func (c *Client) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error) {
+// If the requested revision has been compacted, customerrors.ErrRevisionCompacted is sent on the error channel
+func (c *Client) Watch(ctx context.Context, fromRevision int64) (<-chan WatchEvent, <-chan error) {
 	eventCh := make(chan WatchEvent, c.watchEventChannelSize)
 	errCh := make(chan error, 1)
 
@@ -99,6 +454,8 @@ func (c *Client) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error) {
 		defer close(errCh)
 		watchRevisionDiscrepancy := false
 		var consecutiveFailureCount int64
+		// Each watch tracks its own expected revision, a cancelled watch may still be running
+		expectedModRevision := int64(-1)
 
 		for {
 			var watchChan clientv3.WatchChan
@@ -107,7 +464,13 @@ func (c *Client) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error) {
 					ctx,
 					c.rootPrefixEtcd,
 					clientv3.WithPrefix(),
-					clientv3.WithRev(c.ExpectedModRevision))
+					clientv3.WithRev(expectedModRevision))
+			} else if fromRevision > 0 {
+				watchChan = c.client.Watch(
+					ctx,
+					c.rootPrefixEtcd,
+					clientv3.WithPrefix(),
+					clientv3.WithRev(fromRevision))
 			} else {
 				watchChan = c.client.Watch(
 					ctx,
@@ -116,6 +479,10 @@ func (c *Client) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error) {
 			}
 
 			for watchResp := range watchChan {
+				if watchResp.CompactRevision != 0 {
+					errCh <- fmt.Errorf("%w: requested revision is older than compacted revision %d", customerrors.ErrRevisionCompacted, watchResp.CompactRevision)
+					return
+				}
 				if watchResp.Err() != nil {
 					errCh <- fmt.Errorf("watch error: %w", watchResp.Err())
 					return
@@ -123,20 +490,21 @@ func (c *Client) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error) {
 
 				for _, event := range watchResp.Events {
 					// if this is the first event, set the expected modrevision to the current modrevision
-					if c.ExpectedModRevision == -1 {
-						c.ExpectedModRevision = event.Kv.ModRevision
+					if expectedModRevision == -1 {
+						expectedModRevision = event.Kv.ModRevision
 					}
 					// check if the modrevision is not equal to the expected modrevision
 					// which is the last modrevision + 1
 					// it means that some event must have been missed due to some network issues
 					// so it will break the loop and restart the watch to ensure consistency
-					if event.Kv.ModRevision != c.ExpectedModRevision {
+					// The events of a transaction share its revision, so the last modrevision is expected as well
+					if event.Kv.ModRevision != expectedModRevision && event.Kv.ModRevision != expectedModRevision-1 {
 						consecutiveFailureCount++
-						logger.Warnf("ModRevision mismatch: Consecutive failure #%d on ModRevision %d", consecutiveFailureCount, c.ExpectedModRevision)
+						logger.Warnf("ModRevision mismatch: Consecutive failure #%d on ModRevision %d", consecutiveFailureCount, expectedModRevision)
 
 						// If we've exceeded max retries on the same revision, fail fast
 						if consecutiveFailureCount >= c.maxWatchRetries {
-							errCh <- fmt.Errorf("exceeded max watch retries (%d) on ModRevision %d - failing fast to prevent infinite loop", c.maxWatchRetries, c.ExpectedModRevision)
+							errCh <- fmt.Errorf("exceeded max watch retries (%d) on ModRevision %d - failing fast to prevent infinite loop", c.maxWatchRetries, expectedModRevision)
 							return
 						}
 						watchRevisionDiscrepancy = true
@@ -145,10 +513,12 @@ func (c *Client) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error) {
 
 					// Successfully processed an event, reset failure counter
 					consecutiveFailureCount = 0
-					c.ExpectedModRevision = event.Kv.ModRevision + 1
+					expectedModRevision = event.Kv.ModRevision + 1
 
 					watchEvent := WatchEvent{
-						Key: string(event.Kv.Key),
+						Key:        string(event.Kv.Key),
+						Revision:   event.Kv.ModRevision,
+						ReceivedAt: time.Now(),
 					}
 
 					switch event.Type {
@@ -157,7 +527,8 @@ func (c *Client) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error) {
 						watchEvent.Value = string(event.Kv.Value)
 					case clientv3.EventTypeDelete:
 						watchEvent.Type = "DELETE"
-					}				
+					}
+					c.lastWatchedRevision.Store(event.Kv.ModRevision)
 
 					select {
 					case eventCh <- watchEvent:
@@ -170,6 +541,11 @@ func (c *Client) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error) {
 					break
 				}
 			}
+
+			// The watch channel is closed when the context is cancelled
+			if ctx.Err() != nil {
+				return
+			}
 		}
 	}()
 
@@ -177,23 +553,39 @@ func (c *Client) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error) {
 }
 
 // GetKeysWithPagination retrieves keys with pagination support
-func (c *Client) GetKeysWithPagination(ctx context.Context, fromKey string) ([]common.KV, string, error) {
+// All pages of a sync should be read at the revision returned by the first call
+// so that they form a consistent snapshot of the keyspace
+func (c *Client) GetKeysWithPagination(ctx context.Context, prefix, fromKey string, revision int64) ([]common.KV, string, int64, error) {
+	if prefix == "" {
+		prefix = c.rootPrefixEtcd
+	}
 
 	opts := []clientv3.OpOption{
 		clientv3.WithLimit(c.numGetKeysLimit),
 	}
-	var key string
-	if fromKey != "" {
+	if revision > 0 {
+		opts = append(opts, clientv3.WithRev(revision))
+	}
+	key := prefix
+	if fromKey > prefix {
+		// Read from fromKey until the end of the prefix
 		key = fromKey
-		opts = append(opts, clientv3.WithFromKey())
+		opts = append(opts, clientv3.WithRange(clientv3.GetPrefixRangeEnd(prefix)))
 	} else {
-		key = c.rootPrefixEtcd
 		opts = append(opts, clientv3.WithPrefix())
 	}
 
 	resp, err := c.client.Get(ctx, key, opts...)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to get keys: %w", err)
+		if errors.Is(err, rpctypes.ErrCompacted) {
+			return nil, "", 0, fmt.Errorf("%w: failed to get keys at revision %d", customerrors.ErrRevisionCompacted, revision)
+		}
+		return nil, "", 0, fmt.Errorf("failed to get keys: %w", err)
+	}
+
+	// The header holds the latest revision, not the one a past read was made at
+	if revision <= 0 {
+		revision = resp.Header.Revision
 	}
 
 	keys := make([]common.KV, 0)
@@ -210,10 +602,69 @@ func (c *Client) GetKeysWithPagination(ctx context.Context, fromKey string) ([]c
 	}
 
 	if len(keys) == 0 {
-		return keys, "", nil
+		return keys, "", revision, nil
 	}
 
-	return keys, keys[len(keys)-1].Key, nil
+	return keys, keys[len(keys)-1].Key, revision, nil
+}
+
+// ListChildren returns a page of the immediate children of prefix
+// The keys under prefix are scanned without their values, every page of the scan at the revision of the first one
+func (c *Client) ListChildren(ctx context.Context, prefix string, offset, limit int64) (Children, error) {
+	prefix = DirPrefix(prefix)
+	counter := newChildrenCounter(prefix)
+
+	key, rangeEnd := prefix, clientv3.GetPrefixRangeEnd(prefix)
+	if prefix == "" {
+		// "\x00" as both key and range end selects the whole keyspace
+		key, rangeEnd = "\x00", "\x00"
+	}
+
+	var revision int64
+	for {
+		opts := []clientv3.OpOption{
+			clientv3.WithRange(rangeEnd),
+			clientv3.WithKeysOnly(),
+			clientv3.WithLimit(c.numGetKeysLimit),
+		}
+		if revision > 0 {
+			opts = append(opts, clientv3.WithRev(revision))
+		}
+
+		resp, err := c.client.Get(ctx, key, opts...)
+		if err != nil {
+			if errors.Is(err, rpctypes.ErrCompacted) {
+				return Children{}, fmt.Errorf("%w: failed to list keys at revision %d", customerrors.ErrRevisionCompacted, revision)
+			}
+			return Children{}, fmt.Errorf("failed to list keys: %w", err)
+		}
+		revision = resp.Header.Revision
+
+		for _, kv := range resp.Kvs {
+			counter.add(string(kv.Key))
+		}
+		if !resp.More || len(resp.Kvs) == 0 {
+			break
+		}
+		// Continue right after the last key read
+		key = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+
+	return counter.page(offset, limit), nil
+}
+
+// GetRevision returns the current revision of the etcd cluster
+func (c *Client) GetRevision(ctx context.Context) (int64, error) {
+	resp, err := c.client.Get(ctx, c.rootPrefixEtcd, clientv3.WithLimit(1), clientv3.WithKeysOnly())
+	if err != nil {
+		return 0, fmt.Errorf("failed to get revision: %w", err)
+	}
+	return resp.Header.Revision, nil
+}
+
+// LastWatchedRevision returns the ModRevision of the newest event received by the watch, 0 if there is none
+func (c *Client) LastWatchedRevision() int64 {
+	return c.lastWatchedRevision.Load()
 }
 
 // StartAuditor starts a background goroutine that checks etcd connection health every EtcdAuditPeriod
@@ -0,0 +1,700 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+	"go.yaml.in/yaml/v3"
+)
+
+// ErrConnectionLost is returned by a FakeClient while its connection is lost, unless another error was injected
+var ErrConnectionLost = errors.New("connection to etcd lost")
+
+// FakeClient is an in-memory etcd used by tests and by the demo mode
+// Every change creates a new revision like etcd v3 does, and the whole history is kept so that
+// watches can start and keys can be read at a past revision
+// Failures can be injected to exercise the recovery paths of the callers: dropped watch events,
+// revision gaps, compaction and connection loss
+type FakeClient struct {
+	watchEventChannelSize int64  // size of the watch event channel
+	rootPrefixEtcd        string // prefix of the etcd keys to be watched
+	numGetKeysLimit       int64  // number of keys to be returned in a single GetKeysWithPagination call
+	EtcdAuditPeriod       time.Duration
+
+	mu              sync.Mutex
+	revision        int64
+	compactRevision int64        // revisions up to this one can no longer be watched or read
+	history         []WatchEvent // every change of the keys in revision order, the receive time is not set
+	kvs             map[string]KeyValue
+	watchers        map[*fakeWatcher]struct{}
+	dropEvents      int   // number of upcoming changes not delivered to the open watches
+	connErr         error // error returned by every call while the connection is lost, nil if connected
+	lastWatched     int64 // revision of the newest event delivered by a watch
+}
+
+// fakeWatcher holds the events of an open watch until its goroutine delivers them
+type fakeWatcher struct {
+	pending []WatchEvent
+	err     error         // error ending the watch once the pending events are delivered
+	notify  chan struct{} // signalled when pending or err change
+}
+
+// NewFakeClient creates a new in-memory etcd client at revision 0 with no keys
+func NewFakeClient(
+	watchEventChannelSize int64,
+	rootPrefixEtcd string,
+	numGetKeysLimit int64,
+	etcdAuditPeriod int64) (*FakeClient, error) {
+	if numGetKeysLimit <= 0 {
+		return nil, fmt.Errorf("numGetKeysLimit must be greater than 0")
+	}
+	if etcdAuditPeriod <= 0 {
+		return nil, fmt.Errorf("etcdAuditPeriod must be greater than 0")
+	}
+
+	return &FakeClient{
+		watchEventChannelSize: watchEventChannelSize,
+		rootPrefixEtcd:        rootPrefixEtcd,
+		numGetKeysLimit:       numGetKeysLimit,
+		EtcdAuditPeriod:       time.Duration(etcdAuditPeriod) * time.Second,
+		kvs:                   make(map[string]KeyValue),
+		watchers:              make(map[*fakeWatcher]struct{}),
+	}, nil
+}
+
+// LoadSeedFile reads the keys a FakeClient is seeded with from a JSON or YAML file
+// The file holds a single object mapping every key to its value
+func LoadSeedFile(path string) ([]common.KV, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read seed file: %w", err)
+	}
+
+	// JSON is a subset of YAML, so a single decoder reads both
+	var values map[string]string
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to decode seed file %s: %w", path, err)
+	}
+
+	kvs := make([]common.KV, 0, len(values))
+	for key, value := range values {
+		kvs = append(kvs, common.KV{Key: key, Value: value})
+	}
+	slices.SortFunc(kvs, func(a, b common.KV) int { return strings.Compare(a.Key, b.Key) })
+	return kvs, nil
+}
+
+// Seed puts the key-value pairs in order, each of them in its own revision
+func (c *FakeClient) Seed(kvs []common.KV) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, kv := range kvs {
+		c.commit(WatchEvent{Type: "PUT", Key: kv.Key, Value: kv.Value})
+	}
+}
+
+func (c *FakeClient) Get(ctx context.Context, key string, revision int64) (KeyValue, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connErr != nil {
+		return KeyValue{}, fmt.Errorf("failed to get key: %w", c.connErr)
+	}
+	kvs, err := c.kvsAt(revision)
+	if err != nil {
+		return KeyValue{}, err
+	}
+	kv, ok := kvs[key]
+	if !ok {
+		return KeyValue{}, customerrors.ErrKeyNotFound
+	}
+	return kv, nil
+}
+
+// GetHistory returns a page of the past versions of the key
+func (c *FakeClient) GetHistory(ctx context.Context, key string, revision, limit int64) (KeyHistory, error) {
+	return walkHistory(ctx, c.Get, c.lastDeletion, key, revision, limit)
+}
+
+// lastDeletion returns the revision of the last deletion of the key at or before revision, 0 if there is none after the compacted revisions
+func (c *FakeClient) lastDeletion(ctx context.Context, key string, revision int64) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connErr != nil {
+		return 0, fmt.Errorf("failed to watch deletions: %w", c.connErr)
+	}
+	if revision <= 0 {
+		revision = c.revision
+	}
+	for i := c.historyIndex(revision+1) - 1; i >= 0 && c.history[i].Revision > c.compactRevision; i-- {
+		if event := c.history[i]; event.Type == "DELETE" && event.Key == key {
+			return event.Revision, nil
+		}
+	}
+	return 0, nil
+}
+
+func (c *FakeClient) Put(ctx context.Context, key string, value string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connErr != nil {
+		return "", fmt.Errorf("failed to put key: %w", c.connErr)
+	}
+	c.commit(WatchEvent{Type: "PUT", Key: key, Value: value})
+	return key, nil
+}
+
+// CompareAndPut puts the key only if it still satisfies cond
+func (c *FakeClient) CompareAndPut(ctx context.Context, key string, value string, cond Precondition) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connErr != nil {
+		return 0, fmt.Errorf("failed to put key: %w", c.connErr)
+	}
+	if err := c.check(key, cond); err != nil {
+		return 0, err
+	}
+	c.commit(WatchEvent{Type: "PUT", Key: key, Value: value})
+	return c.revision, nil
+}
+
+// CompareAndDelete deletes the key only if it still satisfies cond
+func (c *FakeClient) CompareAndDelete(ctx context.Context, key string, cond Precondition) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connErr != nil {
+		return 0, fmt.Errorf("failed to delete key: %w", c.connErr)
+	}
+	if err := c.check(key, cond); err != nil {
+		return 0, err
+	}
+	if _, ok := c.kvs[key]; ok {
+		c.commit(WatchEvent{Type: "DELETE", Key: key})
+	}
+	return c.revision, nil
+}
+
+// check returns a conflict error if the key does not satisfy cond
+// Must be called with mu held
+func (c *FakeClient) check(key string, cond Precondition) error {
+	kv, ok := c.kvs[key]
+	if (cond.Absent && ok) ||
+		(cond.ModRevision > 0 && kv.ModRevision != cond.ModRevision) ||
+		(cond.PrevValue != nil && (!ok || kv.Value != *cond.PrevValue)) {
+		return conflictError(key, kv.ModRevision)
+	}
+	return nil
+}
+
+// Txn runs the operations of txn atomically, all of its writes share a single new revision
+// Like etcd, a get sees the writes of the operations before it
+func (c *FakeClient) Txn(ctx context.Context, txn Txn) (TxnResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connErr != nil {
+		return TxnResult{}, fmt.Errorf("failed to commit transaction: %w", c.connErr)
+	}
+	for _, ops := range [][]Op{txn.Then, txn.Else} {
+		written := make(map[string]struct{})
+		for _, op := range ops {
+			if op.Type == OpGet {
+				continue
+			}
+			if _, ok := written[op.Key]; ok {
+				return TxnResult{}, fmt.Errorf("%w: duplicate key given in txn request", customerrors.ErrInvalidTxn)
+			}
+			written[op.Key] = struct{}{}
+		}
+	}
+
+	succeeded := true
+	for _, cmp := range txn.Compares {
+		if !c.holds(cmp) {
+			succeeded = false
+			break
+		}
+	}
+	ops := txn.Then
+	if !succeeded {
+		ops = txn.Else
+	}
+
+	// The writes are applied to a copy of the keys as they go so that the gets see them
+	kvs := maps.Clone(c.kvs)
+	var events []WatchEvent
+	results := make([]OpResult, 0, len(ops))
+	for _, op := range ops {
+		result := OpResult{Type: op.Type, Key: op.Key}
+		var event WatchEvent
+		switch op.Type {
+		case OpGet:
+			if kv, ok := kvs[op.Key]; ok {
+				result.KV = &kv
+			}
+			results = append(results, result)
+			continue
+		case OpPut:
+			event = WatchEvent{Type: "PUT", Key: op.Key, Value: op.Value}
+		case OpDelete:
+			if _, ok := kvs[op.Key]; !ok {
+				results = append(results, result)
+				continue
+			}
+			result.Deleted = 1
+			event = WatchEvent{Type: "DELETE", Key: op.Key}
+		}
+		events = append(events, event)
+		event.Revision = c.revision + 1
+		applyEvent(kvs, event)
+		results = append(results, result)
+	}
+
+	// Like etcd, a transaction that changes nothing does not create a revision
+	if len(events) > 0 {
+		c.commit(events...)
+	}
+	return TxnResult{
+		Succeeded: succeeded,
+		Revision:  c.revision,
+		Results:   results,
+	}, nil
+}
+
+// holds reports whether the current state of the key satisfies cmp
+// Like etcd, a missing key has zero metadata and fails every comparison of its value
+// Must be called with mu held
+func (c *FakeClient) holds(cmp Compare) bool {
+	kv, ok := c.kvs[cmp.Key]
+	switch cmp.Target {
+	case CompareValue:
+		return ok && compareString(kv.Value, cmp.Op, cmp.Value)
+	case CompareVersion:
+		return compareInt(kv.Version, cmp.Op, cmp.Number)
+	case CompareModRevision:
+		return compareInt(kv.ModRevision, cmp.Op, cmp.Number)
+	case CompareCreateRevision:
+		return compareInt(kv.CreateRevision, cmp.Op, cmp.Number)
+	}
+	return ok == cmp.Exists
+}
+
+func (c *FakeClient) Delete(ctx context.Context, key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connErr != nil {
+		return "", fmt.Errorf("failed to delete key: %w", c.connErr)
+	}
+	// Like etcd, deleting a missing key does not create a revision
+	if _, ok := c.kvs[key]; ok {
+		c.commit(WatchEvent{Type: "DELETE", Key: key})
+	}
+	return key, nil
+}
+
+// GetPrefix returns the first limit keys under prefix
+func (c *FakeClient) GetPrefix(ctx context.Context, prefix string, limit int64) ([]KeyValue, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connErr != nil {
+		return nil, fmt.Errorf("failed to get keys: %w", c.connErr)
+	}
+	keys := c.prefixKeys(DirPrefix(prefix))
+	kvs := make([]KeyValue, 0, min(int64(len(keys)), limit))
+	for _, key := range keys[:cap(kvs)] {
+		kvs = append(kvs, c.kvs[key])
+	}
+	return kvs, nil
+}
+
+// PreviewPrefix returns the number of keys under prefix with the first sampleSize of them
+func (c *FakeClient) PreviewPrefix(ctx context.Context, prefix string, sampleSize int64) (PrefixPreview, error) {
+	if err := checkDeletePrefix(prefix, c.rootPrefixEtcd); err != nil {
+		return PrefixPreview{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connErr != nil {
+		return PrefixPreview{}, fmt.Errorf("failed to list keys: %w", c.connErr)
+	}
+	keys := c.prefixKeys(DirPrefix(prefix))
+	return PrefixPreview{
+		Count:    int64(len(keys)),
+		Keys:     keys[:min(int64(len(keys)), sampleSize)],
+		Revision: c.revision,
+	}, nil
+}
+
+// DeletePrefix deletes the keys under prefix in a single revision, if revision is not 0 only while none has changed after it
+func (c *FakeClient) DeletePrefix(ctx context.Context, prefix string, revision int64) ([]string, error) {
+	if err := checkDeletePrefix(prefix, c.rootPrefixEtcd); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connErr != nil {
+		return nil, fmt.Errorf("failed to delete keys: %w", c.connErr)
+	}
+	keys := c.prefixKeys(DirPrefix(prefix))
+	events := make([]WatchEvent, 0, len(keys))
+	for _, key := range keys {
+		if revision > 0 && c.kvs[key].ModRevision > revision {
+			return nil, prefixChangedError(prefix, revision)
+		}
+		events = append(events, WatchEvent{Type: "DELETE", Key: key})
+	}
+	if len(events) > 0 {
+		c.commit(events...)
+	}
+	return keys, nil
+}
+
+// prefixKeys returns the sorted keys under prefix
+// Must be called with mu held
+func (c *FakeClient) prefixKeys(prefix string) []string {
+	keys := make([]string, 0)
+	for key := range c.kvs {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// commit applies the changes in a single new revision and hands them to the open watches
+// Must be called with mu held
+func (c *FakeClient) commit(events ...WatchEvent) {
+	c.revision++
+	for _, event := range events {
+		event.Revision = c.revision
+		c.history = append(c.history, event)
+		applyEvent(c.kvs, event)
+
+		if !strings.HasPrefix(event.Key, c.rootPrefixEtcd) {
+			continue
+		}
+		if c.dropEvents > 0 {
+			c.dropEvents--
+			logger.Debugf("Dropping watch event %s for key %s at revision %d", event.Type, event.Key, event.Revision)
+			continue
+		}
+		for w := range c.watchers {
+			w.pending = append(w.pending, event)
+			w.signal()
+		}
+	}
+}
+
+// applyEvent applies a change to the keys, keeping their metadata up to date like etcd v3 does
+func applyEvent(kvs map[string]KeyValue, event WatchEvent) {
+	if event.Type != "PUT" {
+		delete(kvs, event.Key)
+		return
+	}
+	kv, ok := kvs[event.Key]
+	if !ok {
+		kv = KeyValue{
+			Key:            event.Key,
+			CreateRevision: event.Revision,
+		}
+	}
+	kv.Value = event.Value
+	kv.ModRevision = event.Revision
+	kv.Version++
+	kvs[event.Key] = kv
+}
+
+func (w *fakeWatcher) signal() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Watch watches for changes on keys
+// Returns a channel of WatchEvents and an error channel
+// If the requested revision has been compacted, customerrors.ErrRevisionCompacted is sent on the error channel
+func (c *FakeClient) Watch(ctx context.Context, fromRevision int64) (<-chan WatchEvent, <-chan error) {
+	eventCh := make(chan WatchEvent, c.watchEventChannelSize)
+	errCh := make(chan error, 1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connErr != nil {
+		errCh <- fmt.Errorf("watch error: %w", c.connErr)
+		close(eventCh)
+		close(errCh)
+		return eventCh, errCh
+	}
+	if fromRevision > 0 && fromRevision <= c.compactRevision {
+		errCh <- fmt.Errorf("%w: requested revision is older than compacted revision %d", customerrors.ErrRevisionCompacted, c.compactRevision)
+		close(eventCh)
+		close(errCh)
+		return eventCh, errCh
+	}
+
+	// Replay the past changes first, later ones are appended by commit
+	w := &fakeWatcher{notify: make(chan struct{}, 1)}
+	if fromRevision > 0 {
+		for _, event := range c.history[c.historyIndex(fromRevision):] {
+			if strings.HasPrefix(event.Key, c.rootPrefixEtcd) {
+				w.pending = append(w.pending, event)
+			}
+		}
+		w.signal()
+	}
+	c.watchers[w] = struct{}{}
+
+	go func() {
+		defer close(eventCh)
+		defer close(errCh)
+		defer c.removeWatcher(w)
+
+		for {
+			c.mu.Lock()
+			events, err := w.pending, w.err
+			w.pending = nil
+			c.mu.Unlock()
+
+			for _, event := range events {
+				event.ReceivedAt = time.Now()
+				c.mu.Lock()
+				c.lastWatched = event.Revision
+				c.mu.Unlock()
+				select {
+				case eventCh <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			select {
+			case <-w.notify:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return eventCh, errCh
+}
+
+func (c *FakeClient) removeWatcher(w *fakeWatcher) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.watchers, w)
+}
+
+// historyIndex returns the index of the first change of the history at or after revision
+// Must be called with mu held
+func (c *FakeClient) historyIndex(revision int64) int {
+	return sort.Search(len(c.history), func(i int) bool {
+		return c.history[i].Revision >= revision
+	})
+}
+
+// GetKeysWithPagination retrieves keys with pagination support
+// Keys are read at the given revision, or at the latest revision if it is 0
+func (c *FakeClient) GetKeysWithPagination(ctx context.Context, prefix, fromKey string, revision int64) ([]common.KV, string, int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connErr != nil {
+		return nil, "", 0, fmt.Errorf("failed to get keys: %w", c.connErr)
+	}
+	kvs, err := c.kvsAt(revision)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	if revision <= 0 {
+		revision = c.revision
+	}
+
+	if prefix == "" {
+		prefix = c.rootPrefixEtcd
+	}
+
+	keys := make([]common.KV, 0)
+	for key, kv := range kvs {
+		if strings.HasPrefix(key, prefix) && key > fromKey {
+			keys = append(keys, common.KV{Key: key, Value: kv.Value})
+		}
+	}
+	slices.SortFunc(keys, func(a, b common.KV) int { return strings.Compare(a.Key, b.Key) })
+	if int64(len(keys)) > c.numGetKeysLimit {
+		keys = keys[:c.numGetKeysLimit]
+	}
+
+	if len(keys) == 0 {
+		return keys, "", revision, nil
+	}
+	return keys, keys[len(keys)-1].Key, revision, nil
+}
+
+// kvsAt returns the keys as of revision, or as of the latest revision if it is 0
+// Past revisions are read by replaying the history up to them
+// Must be called with mu held
+func (c *FakeClient) kvsAt(revision int64) (map[string]KeyValue, error) {
+	if revision <= 0 {
+		return c.kvs, nil
+	}
+	if revision > c.revision {
+		return nil, fmt.Errorf("%w: revision %d is a future revision", customerrors.ErrInvalidRevision, revision)
+	}
+	if revision <= c.compactRevision {
+		return nil, fmt.Errorf("%w: revision %d", customerrors.ErrRevisionCompacted, revision)
+	}
+	if revision == c.revision {
+		return c.kvs, nil
+	}
+
+	kvs := make(map[string]KeyValue)
+	for _, event := range c.history[:c.historyIndex(revision+1)] {
+		applyEvent(kvs, event)
+	}
+	return kvs, nil
+}
+
+// ListChildren returns a page of the immediate children of prefix
+func (c *FakeClient) ListChildren(ctx context.Context, prefix string, offset, limit int64) (Children, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connErr != nil {
+		return Children{}, fmt.Errorf("failed to list keys: %w", c.connErr)
+	}
+	counter := newChildrenCounter(DirPrefix(prefix))
+	for key := range c.kvs {
+		counter.add(key)
+	}
+	return counter.page(offset, limit), nil
+}
+
+// GetRevision returns the current revision of the fake etcd
+func (c *FakeClient) GetRevision(ctx context.Context) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connErr != nil {
+		return 0, fmt.Errorf("failed to get revision: %w", c.connErr)
+	}
+	return c.revision, nil
+}
+
+// LastWatchedRevision returns the revision of the newest event delivered by a watch, 0 if there is none
+func (c *FakeClient) LastWatchedRevision() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastWatched
+}
+
+// StartAuditor starts a background goroutine that checks the connection every EtcdAuditPeriod
+// Returns an error channel that will receive an error once the connection is lost
+func (c *FakeClient) StartAuditor(ctx context.Context) <-chan error {
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(errCh)
+		ticker := time.NewTicker(c.EtcdAuditPeriod)
+		defer ticker.Stop()
+
+		for {
+			if err := c.checkConnection(); err != nil {
+				errCh <- fmt.Errorf("fake etcd connection check failed: %w", err)
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return errCh
+}
+
+func (c *FakeClient) checkConnection() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connErr
+}
+
+// Close closes the fake client, the open watches end when their context is cancelled
+func (c *FakeClient) Close() error {
+	return nil
+}
+
+// DropEvents makes the open watches miss the next n changes under the root prefix
+// The changes are still applied, so only a resync or a reconciliation can bring them in
+func (c *FakeClient) DropEvents(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dropEvents = n
+}
+
+// SkipRevisions consumes n revisions without any change under the root prefix,
+// like writes to keys outside of it do on a real cluster
+func (c *FakeClient) SkipRevisions(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revision += n
+}
+
+// Compact makes the revisions up to revision unavailable to new watches and reads
+// Like on etcd, the open watches are not affected
+func (c *FakeClient) Compact(revision int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.compactRevision = max(c.compactRevision, min(revision, c.revision))
+}
+
+// Disconnect simulates a connection loss: the open watches end with err once their pending events are delivered,
+// and every call fails with err until Reconnect is called
+// A nil err is replaced by ErrConnectionLost
+func (c *FakeClient) Disconnect(err error) {
+	if err == nil {
+		err = ErrConnectionLost
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connErr = err
+	for w := range c.watchers {
+		w.err = fmt.Errorf("watch error: %w", err)
+		w.signal()
+	}
+	clear(c.watchers)
+}
+
+// Reconnect restores the connection lost by Disconnect
+func (c *FakeClient) Reconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connErr = nil
+}
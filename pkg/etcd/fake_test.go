@@ -0,0 +1,309 @@
+package etcd_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/config"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.NewLogger(&config.Config{}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func newFakeClient(t *testing.T, numGetKeysLimit int64) *etcd.FakeClient {
+	t.Helper()
+	client, err := etcd.NewFakeClient(10, "/app/", numGetKeysLimit, 1)
+	if err != nil {
+		t.Fatalf("NewFakeClient() error = %v", err)
+	}
+	return client
+}
+
+func mustPut(t *testing.T, client *etcd.FakeClient, key, value string) {
+	t.Helper()
+	if _, err := client.Put(context.Background(), key, value); err != nil {
+		t.Fatalf("Put(%q) error = %v", key, err)
+	}
+}
+
+func receive(t *testing.T, eventCh <-chan etcd.WatchEvent) etcd.WatchEvent {
+	t.Helper()
+	select {
+	case event := <-eventCh:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a watch event")
+		return etcd.WatchEvent{}
+	}
+}
+
+func TestFakeClientGetKeysAtRevision(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(t, 2)
+	mustPut(t, client, "/app/a", "1")
+	mustPut(t, client, "/other/x", "outside the prefix")
+	mustPut(t, client, "/app/c", "3")
+	mustPut(t, client, "/app/b", "2")
+	if _, err := client.Delete(ctx, "/app/a"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	revision, err := client.GetRevision(ctx)
+	if err != nil || revision != 5 {
+		t.Fatalf("GetRevision() = %d, %v, want 5", revision, err)
+	}
+
+	// Pages are read at the revision of the first one, later changes are not visible
+	keys, next, readRevision, err := client.GetKeysWithPagination(ctx, "", "", 4)
+	if err != nil {
+		t.Fatalf("GetKeysWithPagination() error = %v", err)
+	}
+	mustPut(t, client, "/app/bb", "new")
+	want := []common.KV{{Key: "/app/a", Value: "1"}, {Key: "/app/b", Value: "2"}}
+	if readRevision != 4 || next != "/app/b" || len(keys) != 2 || keys[0] != want[0] || keys[1] != want[1] {
+		t.Fatalf("first page = %v, %q, %d, want %v, %q, 4", keys, next, readRevision, want, "/app/b")
+	}
+	keys, next, _, err = client.GetKeysWithPagination(ctx, "", next, readRevision)
+	if err != nil || len(keys) != 1 || keys[0].Key != "/app/c" || next != "/app/c" {
+		t.Fatalf("second page = %v, %q, %v, want /app/c", keys, next, err)
+	}
+
+	client.Compact(4)
+	if _, _, _, err := client.GetKeysWithPagination(ctx, "", "", 4); !errors.Is(err, customerrors.ErrRevisionCompacted) {
+		t.Fatalf("GetKeysWithPagination() at a compacted revision error = %v, want ErrRevisionCompacted", err)
+	}
+}
+
+func TestFakeClientWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := newFakeClient(t, 10)
+	mustPut(t, client, "/app/a", "1")
+	mustPut(t, client, "/app/b", "2")
+
+	// Past changes are replayed before the live ones
+	eventCh, _ := client.Watch(ctx, 2)
+	mustPut(t, client, "/other/x", "outside the prefix")
+	if _, err := client.Delete(ctx, "/app/a"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if event := receive(t, eventCh); event.Type != "PUT" || event.Key != "/app/b" || event.Value != "2" || event.Revision != 2 {
+		t.Fatalf("replayed event = %+v, want PUT /app/b at revision 2", event)
+	}
+	if event := receive(t, eventCh); event.Type != "DELETE" || event.Key != "/app/a" || event.Revision != 4 {
+		t.Fatalf("live event = %+v, want DELETE /app/a at revision 4", event)
+	}
+
+	// Dropped events are applied but never delivered
+	client.DropEvents(1)
+	mustPut(t, client, "/app/c", "dropped")
+	mustPut(t, client, "/app/d", "delivered")
+	if event := receive(t, eventCh); event.Key != "/app/d" || event.Revision != 6 {
+		t.Fatalf("event after a dropped one = %+v, want /app/d at revision 6", event)
+	}
+	if kv, err := client.Get(ctx, "/app/c", 0); err != nil || kv.Value != "dropped" {
+		t.Fatalf("Get() of a dropped change = %q, %v, want %q", kv.Value, err, "dropped")
+	}
+
+	client.Compact(5)
+	_, errCh := client.Watch(ctx, 5)
+	if err := <-errCh; !errors.Is(err, customerrors.ErrRevisionCompacted) {
+		t.Fatalf("Watch() at a compacted revision error = %v, want ErrRevisionCompacted", err)
+	}
+}
+
+func TestFakeClientDisconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := newFakeClient(t, 10)
+	_, errCh := client.Watch(ctx, 0)
+
+	client.Disconnect(nil)
+	if err := <-errCh; !errors.Is(err, etcd.ErrConnectionLost) {
+		t.Fatalf("watch error = %v, want ErrConnectionLost", err)
+	}
+	if _, err := client.Put(ctx, "/app/a", "1"); !errors.Is(err, etcd.ErrConnectionLost) {
+		t.Fatalf("Put() while disconnected error = %v, want ErrConnectionLost", err)
+	}
+	if err := <-client.StartAuditor(ctx); !errors.Is(err, etcd.ErrConnectionLost) {
+		t.Fatalf("auditor error = %v, want ErrConnectionLost", err)
+	}
+
+	client.Reconnect()
+	mustPut(t, client, "/app/a", "1")
+}
+
+func TestLoadSeedFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"seed.json": `{"/app/b": "2", "/app/a": "1"}`,
+		"seed.yaml": "/app/b: \"2\"\n/app/a: \"1\"\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		kvs, err := etcd.LoadSeedFile(path)
+		if err != nil {
+			t.Fatalf("LoadSeedFile(%s) error = %v", name, err)
+		}
+		if len(kvs) != 2 || kvs[0] != (common.KV{Key: "/app/a", Value: "1"}) || kvs[1] != (common.KV{Key: "/app/b", Value: "2"}) {
+			t.Fatalf("LoadSeedFile(%s) = %v, want /app/a and /app/b in order", name, kvs)
+		}
+	}
+}
+
+func TestFakeClientListChildren(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(t, 10)
+	client.Seed([]common.KV{
+		{Key: "/registry/pods/default/web", Value: "1"},
+		{Key: "/registry/pods/default/db", Value: "2"},
+		{Key: "/registry/pods/kube-system/dns", Value: "3"},
+		{Key: "/registry/services", Value: "4"},
+		{Key: "/registry-backup", Value: "5"},
+	})
+
+	children, err := client.ListChildren(ctx, "/registry", 0, 10)
+	if err != nil {
+		t.Fatalf("ListChildren() error = %v", err)
+	}
+	want := []etcd.Child{
+		{Name: "pods/", Key: "/registry/pods/", Dir: true, Keys: 3},
+		{Name: "services", Key: "/registry/services", Keys: 1},
+	}
+	if children.TotalChildren != 2 || children.TotalKeys != 4 || len(children.Children) != 2 ||
+		children.Children[0] != want[0] || children.Children[1] != want[1] {
+		t.Fatalf("ListChildren() = %+v, want %v with 4 keys", children, want)
+	}
+
+	// Pages are cut from the children sorted by name
+	children, err = client.ListChildren(ctx, "/registry/pods/", 1, 1)
+	if err != nil {
+		t.Fatalf("ListChildren() error = %v", err)
+	}
+	if children.TotalChildren != 2 || len(children.Children) != 1 || children.Children[0].Name != "kube-system/" {
+		t.Fatalf("ListChildren() second page = %+v, want kube-system/", children)
+	}
+}
+
+func TestFakeClientGetHistory(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(t, 10)
+	for _, value := range []string{"v1", "v2", "v3", "v4"} {
+		mustPut(t, client, "/app/config", value)
+		mustPut(t, client, "/app/other", value)
+	}
+
+	// Point-in-time read between two versions
+	if kv, err := client.Get(ctx, "/app/config", 4); err != nil || kv.Value != "v2" || kv.ModRevision != 3 || kv.Version != 2 {
+		t.Fatalf("Get() at revision 4 = %+v, %v, want v2 written at revision 3", kv, err)
+	}
+
+	history, err := client.GetHistory(ctx, "/app/config", 0, 2)
+	if err != nil {
+		t.Fatalf("GetHistory() error = %v", err)
+	}
+	if len(history.Versions) != 2 || history.Versions[0].Value != "v4" || history.Versions[1].Value != "v3" || history.NextRevision != 4 {
+		t.Fatalf("GetHistory() first page = %+v, want v4 and v3 with the next page at revision 4", history)
+	}
+
+	// The walk stops at the compacted revisions
+	client.Compact(2)
+	history, err = client.GetHistory(ctx, "/app/config", history.NextRevision, 10)
+	if err != nil {
+		t.Fatalf("GetHistory() error = %v", err)
+	}
+	if len(history.Versions) != 1 || history.Versions[0].Value != "v2" || !history.Compacted || history.NextRevision != 0 {
+		t.Fatalf("GetHistory() second page = %+v, want v2 then compacted", history)
+	}
+
+	// A deleted key is walked back from the version its last deletion removed
+	mustPut(t, client, "/app/config", "v5")
+	if _, err := client.Delete(ctx, "/app/config"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	mustPut(t, client, "/app/other", "v5")
+	history, err = client.GetHistory(ctx, "/app/config", 0, 2)
+	if err != nil {
+		t.Fatalf("GetHistory() of a deleted key error = %v", err)
+	}
+	if len(history.Versions) != 2 || history.Versions[0].Value != "v5" || history.Versions[1].Value != "v4" {
+		t.Fatalf("GetHistory() of a deleted key = %+v, want v5 and v4", history)
+	}
+	if _, err := client.GetHistory(ctx, "/app/missing", 0, 2); !errors.Is(err, customerrors.ErrKeyNotFound) {
+		t.Fatalf("GetHistory() of a key never written error = %v, want ErrKeyNotFound", err)
+	}
+
+	if _, err := client.Get(ctx, "/app/config", 1); !errors.Is(err, customerrors.ErrRevisionCompacted) {
+		t.Fatalf("Get() at a compacted revision error = %v, want ErrRevisionCompacted", err)
+	}
+	if _, err := client.Get(ctx, "/app/config", 100); !errors.Is(err, customerrors.ErrInvalidRevision) {
+		t.Fatalf("Get() at a future revision error = %v, want ErrInvalidRevision", err)
+	}
+}
+
+func TestFakeClientTxn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := newFakeClient(t, 10)
+	mustPut(t, client, "/app/flag", "off")
+	mustPut(t, client, "/app/rollout", "0")
+	eventCh, _ := client.Watch(ctx, 0)
+
+	txn := etcd.Txn{
+		Compares: []etcd.Compare{
+			{Key: "/app/flag", Target: etcd.CompareValue, Op: etcd.CompareEqual, Value: "off"},
+			{Key: "/app/missing", Target: etcd.CompareExists, Exists: false},
+		},
+		Then: []etcd.Op{
+			{Type: etcd.OpPut, Key: "/app/flag", Value: "on"},
+			{Type: etcd.OpPut, Key: "/app/rollout", Value: "50"},
+			{Type: etcd.OpGet, Key: "/app/rollout"},
+		},
+		Else: []etcd.Op{{Type: etcd.OpGet, Key: "/app/flag"}},
+	}
+	result, err := client.Txn(ctx, txn)
+	if err != nil {
+		t.Fatalf("Txn() error = %v", err)
+	}
+	// A get sees the writes before it, and every write shares the revision of the transaction
+	if !result.Succeeded || result.Revision != 3 || len(result.Results) != 3 ||
+		result.Results[2].KV == nil || result.Results[2].KV.Value != "50" || result.Results[2].KV.ModRevision != 3 {
+		t.Fatalf("Txn() = %+v, want the then branch at revision 3", result)
+	}
+	for _, key := range []string{"/app/flag", "/app/rollout"} {
+		if event := receive(t, eventCh); event.Key != key || event.Revision != 3 {
+			t.Fatalf("watch event = %+v, want %s at revision 3", event, key)
+		}
+	}
+
+	// The flag is no longer off, so the else branch runs and nothing is written
+	result, err = client.Txn(ctx, txn)
+	if err != nil {
+		t.Fatalf("Txn() error = %v", err)
+	}
+	if result.Succeeded || result.Revision != 3 || len(result.Results) != 1 || result.Results[0].KV.Value != "on" {
+		t.Fatalf("Txn() = %+v, want the else branch reading on at revision 3", result)
+	}
+
+	duplicate := etcd.Txn{Then: []etcd.Op{
+		{Type: etcd.OpPut, Key: "/app/flag", Value: "on"},
+		{Type: etcd.OpDelete, Key: "/app/flag"},
+	}}
+	if _, err := client.Txn(ctx, duplicate); !errors.Is(err, customerrors.ErrInvalidTxn) {
+		t.Fatalf("Txn() writing a key twice error = %v, want ErrInvalidTxn", err)
+	}
+}
@@ -0,0 +1,83 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+)
+
+const (
+	DefaultHistoryLimit  = 100  // number of versions returned by GetHistory when no limit is given
+	MaxHistoryLimit      = 1000 // maximum number of versions returned by a single GetHistory call
+	deletionSearchWindow = 1000 // number of revisions first searched back for the deletion of a key, doubled on every miss
+)
+
+// KeyHistory is a page of the past versions of a key, newest first
+type KeyHistory struct {
+	Versions     []KeyValue
+	NextRevision int64 // revision to read the next page at, 0 if there is no older version
+	Compacted    bool  // whether the older versions have been compacted
+}
+
+// walkHistory reads the versions of a key backwards from revision, or from the latest revision if it is 0
+// A version read at some revision was written at its ModRevision, so the version before it is the one
+// read right before that revision, and so on until the creation of the key or a compacted revision
+// As a deletion leaves no version behind, a key deleted at revision is walked back from the version
+// its last deletion removed, found by lastDeletion, and the walk never goes past the last creation of the key
+func walkHistory(
+	ctx context.Context,
+	get func(ctx context.Context, key string, revision int64) (KeyValue, error),
+	lastDeletion func(ctx context.Context, key string, revision int64) (int64, error),
+	key string,
+	revision int64,
+	limit int64) (KeyHistory, error) {
+	history := KeyHistory{
+		Versions: make([]KeyValue, 0),
+	}
+
+	for int64(len(history.Versions)) < limit {
+		kv, err := get(ctx, key, revision)
+		if errors.Is(err, customerrors.ErrKeyNotFound) && len(history.Versions) == 0 {
+			kv, err = getDeleted(ctx, get, lastDeletion, key, revision)
+		}
+		if err != nil {
+			// Only the first read can fail on a compacted revision without hiding anything
+			if errors.Is(err, customerrors.ErrRevisionCompacted) && len(history.Versions) > 0 {
+				history.Compacted = true
+				history.NextRevision = 0
+				return history, nil
+			}
+			return KeyHistory{}, err
+		}
+		history.Versions = append(history.Versions, kv)
+
+		// The first version of the key
+		if kv.Version <= 1 {
+			history.NextRevision = 0
+			return history, nil
+		}
+		revision = kv.ModRevision - 1
+		history.NextRevision = revision
+	}
+
+	return history, nil
+}
+
+// getDeleted returns the version of a key removed by its last deletion at or before revision
+// It fails with customerrors.ErrKeyNotFound if the key has no deletion left before the compacted revisions
+func getDeleted(
+	ctx context.Context,
+	get func(ctx context.Context, key string, revision int64) (KeyValue, error),
+	lastDeletion func(ctx context.Context, key string, revision int64) (int64, error),
+	key string,
+	revision int64) (KeyValue, error) {
+	deletion, err := lastDeletion(ctx, key, revision)
+	if err != nil {
+		return KeyValue{}, err
+	}
+	if deletion == 0 {
+		return KeyValue{}, customerrors.ErrKeyNotFound
+	}
+	return get(ctx, key, deletion-1)
+}
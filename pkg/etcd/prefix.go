@@ -0,0 +1,33 @@
+package etcd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+)
+
+// PrefixPreview describes the keys a prefix delete would remove
+type PrefixPreview struct {
+	Count    int64    // number of keys under the prefix
+	Keys     []string // first keys under the prefix in key order, at most the requested sample size
+	Revision int64    // revision (v3) or index (v2) the keys were read at
+}
+
+// prefixChangedError reports that keys under prefix have changed since revision
+func prefixChangedError(prefix string, revision int64) error {
+	return fmt.Errorf("%w: keys under %s have changed since revision %d", customerrors.ErrKeyModified, prefix, revision)
+}
+
+// checkDeletePrefix fails unless the directory named by prefix is under the root prefix,
+// so that a prefix delete never reaches the keys outside the ones watched
+func checkDeletePrefix(prefix, rootPrefixEtcd string) error {
+	dir := DirPrefix(prefix)
+	if dir == "" {
+		return customerrors.ErrPrefixRequired
+	}
+	if !strings.HasPrefix(dir, rootPrefixEtcd) {
+		return fmt.Errorf("%w: %s is not under %s", customerrors.ErrPrefixOutsideRoot, dir, rootPrefixEtcd)
+	}
+	return nil
+}
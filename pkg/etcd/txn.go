@@ -0,0 +1,127 @@
+package etcd
+
+// CompareTarget is the attribute of a key a transaction compares
+type CompareTarget string
+
+const (
+	CompareValue          CompareTarget = "value"
+	CompareVersion        CompareTarget = "version"
+	CompareModRevision    CompareTarget = "mod_revision"
+	CompareCreateRevision CompareTarget = "create_revision"
+	CompareExists         CompareTarget = "exists"
+)
+
+// Valid reports whether the compare target is supported
+func (t CompareTarget) Valid() bool {
+	switch t {
+	case CompareValue, CompareVersion, CompareModRevision, CompareCreateRevision, CompareExists:
+		return true
+	}
+	return false
+}
+
+// CompareOp is the operator of a comparison
+type CompareOp string
+
+const (
+	CompareEqual    CompareOp = "="
+	CompareNotEqual CompareOp = "!="
+	CompareLess     CompareOp = "<"
+	CompareGreater  CompareOp = ">"
+)
+
+// Valid reports whether the operator is supported
+func (o CompareOp) Valid() bool {
+	switch o {
+	case CompareEqual, CompareNotEqual, CompareLess, CompareGreater:
+		return true
+	}
+	return false
+}
+
+// Compare is a condition of a transaction on a single key
+type Compare struct {
+	Key    string
+	Target CompareTarget
+	Op     CompareOp // ignored by the exists target
+	Value  string    // compared against by the value target
+	Number int64     // compared against by the version, mod_revision and create_revision targets
+	Exists bool      // whether the key must exist, for the exists target
+}
+
+// OpType is the type of an operation of a transaction
+type OpType string
+
+const (
+	OpPut    OpType = "put"
+	OpDelete OpType = "delete"
+	OpGet    OpType = "get"
+)
+
+// Valid reports whether the operation type is supported
+func (t OpType) Valid() bool {
+	switch t {
+	case OpPut, OpDelete, OpGet:
+		return true
+	}
+	return false
+}
+
+// Op is an operation of a transaction on a single key
+type Op struct {
+	Type  OpType
+	Key   string
+	Value string // value written by a put
+}
+
+// Txn runs the Then operations if every comparison holds, and the Else operations otherwise, atomically
+type Txn struct {
+	Compares []Compare
+	Then     []Op
+	Else     []Op
+}
+
+// TxnResult is the outcome of a transaction
+type TxnResult struct {
+	Succeeded bool       // whether the comparisons held and the Then operations ran
+	Revision  int64      // revision of the transaction, the revision it was read at if it wrote nothing
+	Results   []OpResult // results of the operations that ran, in order
+}
+
+// OpResult is the result of an operation of a transaction
+type OpResult struct {
+	Type    OpType
+	Key     string
+	KV      *KeyValue // key read by a get, nil if it does not exist
+	Deleted int64     // number of keys removed by a delete
+}
+
+// compareInt applies op to a and b
+func compareInt(a int64, op CompareOp, b int64) bool {
+	switch op {
+	case CompareEqual:
+		return a == b
+	case CompareNotEqual:
+		return a != b
+	case CompareLess:
+		return a < b
+	case CompareGreater:
+		return a > b
+	}
+	return false
+}
+
+// compareString applies op to a and b
+func compareString(a string, op CompareOp, b string) bool {
+	switch op {
+	case CompareEqual:
+		return a == b
+	case CompareNotEqual:
+		return a != b
+	case CompareLess:
+		return a < b
+	case CompareGreater:
+		return a > b
+	}
+	return false
+}
@@ -10,7 +10,89 @@ type KVStore interface {
 	Get(ctx context.Context, key string) (string, error)
 	Put(ctx context.Context, key, value string) error
 	PutBatch(ctx context.Context, kvs []common.KV) error
-	Search(ctx context.Context, searchStr string) ([]common.KV, error)
+	// Search returns a page of the key-value pairs matching searchStr
+	Search(ctx context.Context, searchStr string, opts SearchOptions) (SearchResults, error)
 	Delete(ctx context.Context, key string) error
+	DeleteBatch(ctx context.Context, keys []string) error
+	// List returns up to limit key-value pairs of the store in a stable order, starting after cursor,
+	// with the cursor of the next page, empty once the last page is returned
+	// The empty cursor starts at the first pair, cursors are opaque and only valid for the store that returned them
+	List(ctx context.Context, cursor string, limit int64) ([]common.KV, string, error)
+	// GetCheckpoint returns the last etcd revision applied to the store, 0 if there is none
+	GetCheckpoint(ctx context.Context) (int64, error)
+	SetCheckpoint(ctx context.Context, revision int64) error
+	// BeginRebuild starts building an empty copy of the store, searches keep being served by the live one
+	// Until the rebuild is committed or aborted, Put, PutBatch, Delete and DeleteBatch apply to both
+	BeginRebuild(ctx context.Context) error
+	// PutRebuildBatch stores key-value pairs in the copy being rebuilt only
+	PutRebuildBatch(ctx context.Context, kvs []common.KV) error
+	// CommitRebuild atomically replaces the live store with the rebuilt copy
+	CommitRebuild(ctx context.Context) error
+	AbortRebuild(ctx context.Context) error
+	// WaitForWrites blocks until every write accepted so far is visible in searches
+	// Returns an error if any of these writes failed
+	WaitForWrites(ctx context.Context) error
+	WriteStatus() WriteStatus
 	Close(ctx context.Context) error
 }
+
+// SearchScope selects the fields a search matches against
+type SearchScope string
+
+const (
+	SearchScopeKeys   SearchScope = "keys"
+	SearchScopeValues SearchScope = "values"
+	SearchScopeBoth   SearchScope = "both"
+)
+
+// Valid reports whether the scope is one of the supported scopes
+func (s SearchScope) Valid() bool {
+	switch s {
+	case SearchScopeKeys, SearchScopeValues, SearchScopeBoth:
+		return true
+	}
+	return false
+}
+
+const (
+	DefaultSearchLimit = 100  // number of hits returned when SearchOptions.Limit is 0
+	MaxSearchLimit     = 1000 // maximum number of hits returned by a single search
+)
+
+// SearchOptions selects how and on which fields a search matches, and the page of hits it returns
+type SearchOptions struct {
+	Mode   SearchMode  // defaults to SearchModeFuzzy
+	Scope  SearchScope // defaults to SearchScopeKeys
+	Offset int64       // number of hits to skip
+	Limit  int64       // maximum number of hits to return, defaults to DefaultSearchLimit
+
+	MaxScanKeys int64 // keys an exact search evaluated by a scan reads at most, defaults to DefaultMaxScanKeys
+}
+
+// SearchResults is a page of the hits of a search
+type SearchResults struct {
+	Hits               []SearchHit
+	EstimatedTotalHits int64 // estimated number of hits across all pages
+}
+
+// SearchHit is a key-value pair matching a search
+type SearchHit struct {
+	common.KV
+	MatchedFields []string // fields the search matched, lib.KEY_CONSTANT and/or lib.VALUE_CONSTANT
+}
+
+// keyCursor returns the cursor of the page after kvs, for the stores listing pairs in key order
+// after the last key of the previous page
+func keyCursor(kvs []common.KV, limit int64) string {
+	if len(kvs) == 0 || int64(len(kvs)) < limit {
+		return ""
+	}
+	return kvs[len(kvs)-1].Key
+}
+
+// WriteStatus describes the writes accepted by a store that applies them asynchronously
+type WriteStatus struct {
+	PendingWrites int    // writes accepted but not confirmed yet
+	FailedWrites  int64  // writes that were accepted but failed to be applied
+	LastError     string // error of the last failed write
+}
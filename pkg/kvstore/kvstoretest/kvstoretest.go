@@ -0,0 +1,552 @@
+// Package kvstoretest provides a conformance suite for kvstore.KVStore implementations
+//
+// A backend runs the suite from its own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		kvstoretest.Run(t, func(t *testing.T) kvstore.KVStore {
+//			store, err := NewMyStore(...)
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			t.Cleanup(func() { store.Close(context.Background()) })
+//			return store
+//		})
+//	}
+package kvstoretest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/etcdfinder/etcdfinder/internal/config"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+)
+
+// Factory returns an empty store, every test of the suite gets its own
+// The factory is responsible for closing the store, e.g. with t.Cleanup
+type Factory func(t *testing.T) kvstore.KVStore
+
+var loggerOnce sync.Once
+
+// Run runs the whole conformance suite against the stores returned by newStore
+func Run(t *testing.T, newStore Factory) {
+	// Stores log through the global logger
+	loggerOnce.Do(func() {
+		if logger.L == nil {
+			if err := logger.NewLogger(&config.Config{}); err != nil {
+				t.Fatalf("failed to create logger: %v", err)
+			}
+		}
+	})
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, store kvstore.KVStore)
+	}{
+		{"PutGetDelete", testPutGetDelete},
+		{"BatchIdempotency", testBatchIdempotency},
+		{"SpecialCharacters", testSpecialCharacters},
+		{"EmptyValue", testEmptyValue},
+		{"LargeValue", testLargeValue},
+		{"ConcurrentWriters", testConcurrentWriters},
+		{"List", testList},
+		{"SearchScope", testSearchScope},
+		{"SearchPagination", testSearchPagination},
+		{"SearchModes", testSearchModes},
+		{"Checkpoint", testCheckpoint},
+		{"Rebuild", testRebuild},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStore(t))
+		})
+	}
+}
+
+// waitForWrites makes the writes accepted so far visible, stores may apply them asynchronously
+func waitForWrites(t *testing.T, store kvstore.KVStore) {
+	t.Helper()
+	if err := store.WaitForWrites(context.Background()); err != nil {
+		t.Fatalf("WaitForWrites() error = %v", err)
+	}
+}
+
+func mustPutBatch(t *testing.T, store kvstore.KVStore, kvs []common.KV) {
+	t.Helper()
+	if err := store.PutBatch(context.Background(), kvs); err != nil {
+		t.Fatalf("PutBatch() error = %v", err)
+	}
+	waitForWrites(t, store)
+}
+
+func assertValue(t *testing.T, store kvstore.KVStore, key, want string) {
+	t.Helper()
+	got, err := store.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%q) error = %v", key, err)
+	}
+	if got != want {
+		t.Fatalf("Get(%q) = %q (%d bytes), want %q (%d bytes)", key, truncate(got), len(got), truncate(want), len(want))
+	}
+}
+
+func assertMissing(t *testing.T, store kvstore.KVStore, key string) {
+	t.Helper()
+	if got, err := store.Get(context.Background(), key); err == nil {
+		t.Fatalf("Get(%q) = %q, want an error for a missing key", key, got)
+	}
+}
+
+// listAll returns every key-value pair of the store, read in small pages
+func listAll(t *testing.T, store kvstore.KVStore) map[string]string {
+	t.Helper()
+	all := make(map[string]string)
+	cursor := ""
+	for {
+		kvs, next, err := store.List(context.Background(), cursor, 7)
+		if err != nil {
+			t.Fatalf("List(%q) error = %v", cursor, err)
+		}
+		for _, kv := range kvs {
+			if _, ok := all[kv.Key]; ok {
+				t.Fatalf("List() returned key %q twice", kv.Key)
+			}
+			all[kv.Key] = kv.Value
+		}
+		if next == "" {
+			return all
+		}
+		cursor = next
+	}
+}
+
+func search(t *testing.T, store kvstore.KVStore, searchStr string, opts kvstore.SearchOptions) kvstore.SearchResults {
+	t.Helper()
+	results, err := store.Search(context.Background(), searchStr, opts)
+	if err != nil {
+		t.Fatalf("Search(%q, %+v) error = %v", searchStr, opts, err)
+	}
+	return results
+}
+
+func hitKeys(results kvstore.SearchResults) []string {
+	keys := make([]string, 0, len(results.Hits))
+	for _, hit := range results.Hits {
+		keys = append(keys, hit.Key)
+	}
+	return keys
+}
+
+func findHit(results kvstore.SearchResults, key string) (kvstore.SearchHit, bool) {
+	for _, hit := range results.Hits {
+		if hit.Key == key {
+			return hit, true
+		}
+	}
+	return kvstore.SearchHit{}, false
+}
+
+func truncate(s string) string {
+	if len(s) > 64 {
+		return s[:64] + "..."
+	}
+	return s
+}
+
+func testPutGetDelete(t *testing.T, store kvstore.KVStore) {
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "/app/db/host", "db-1"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	waitForWrites(t, store)
+	assertValue(t, store, "/app/db/host", "db-1")
+
+	if err := store.Put(ctx, "/app/db/host", "db-2"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	waitForWrites(t, store)
+	assertValue(t, store, "/app/db/host", "db-2")
+
+	if err := store.Delete(ctx, "/app/db/host"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	waitForWrites(t, store)
+	assertMissing(t, store, "/app/db/host")
+
+	// Deleting a missing key is not an error, watch events can be replayed
+	if err := store.Delete(ctx, "/app/db/host"); err != nil {
+		t.Fatalf("Delete() of a missing key error = %v", err)
+	}
+	if err := store.DeleteBatch(ctx, []string{"/missing/1", "/missing/2"}); err != nil {
+		t.Fatalf("DeleteBatch() of missing keys error = %v", err)
+	}
+	waitForWrites(t, store)
+}
+
+func testBatchIdempotency(t *testing.T, store kvstore.KVStore) {
+	ctx := context.Background()
+	kvs := []common.KV{
+		{Key: "/batch/a", Value: "1"},
+		{Key: "/batch/b", Value: "2"},
+		{Key: "/batch/c", Value: "3"},
+	}
+
+	// Replaying a batch must leave the store unchanged
+	mustPutBatch(t, store, kvs)
+	mustPutBatch(t, store, kvs)
+	if got := listAll(t, store); len(got) != len(kvs) {
+		t.Fatalf("List() after replaying a batch returned %d keys, want %d", len(got), len(kvs))
+	}
+	for _, kv := range kvs {
+		assertValue(t, store, kv.Key, kv.Value)
+	}
+
+	if err := store.DeleteBatch(ctx, []string{"/batch/a", "/batch/b"}); err != nil {
+		t.Fatalf("DeleteBatch() error = %v", err)
+	}
+	if err := store.DeleteBatch(ctx, []string{"/batch/a", "/batch/b"}); err != nil {
+		t.Fatalf("DeleteBatch() replay error = %v", err)
+	}
+	waitForWrites(t, store)
+	assertMissing(t, store, "/batch/a")
+	assertMissing(t, store, "/batch/b")
+	assertValue(t, store, "/batch/c", "3")
+}
+
+func testSpecialCharacters(t *testing.T, store kvstore.KVStore) {
+	kvs := []common.KV{
+		{Key: "/app/config.yaml", Value: "dots"},
+		{Key: "/app/nested//double/slash/", Value: "slashes"},
+		{Key: "/app/with space/and-dash_underscore", Value: "spaces"},
+		{Key: "/ünïcödé/ключ/鍵", Value: "unicode"},
+		{Key: "/app/quote\"and'apostrophe", Value: "quotes"},
+		{Key: "/app/100%/50_percent", Value: "like wildcards"},
+	}
+	mustPutBatch(t, store, kvs)
+
+	for _, kv := range kvs {
+		assertValue(t, store, kv.Key, kv.Value)
+
+		// The exact key is always found by a prefix search
+		results := search(t, store, kv.Key, kvstore.SearchOptions{Mode: kvstore.SearchModePrefix})
+		if _, ok := findHit(results, kv.Key); !ok {
+			t.Errorf("prefix Search(%q) = %v, want it to contain the key", kv.Key, hitKeys(results))
+		}
+	}
+
+	for searchStr, key := range map[string]string{
+		"config":  "/app/config.yaml",
+		"ключ":    "/ünïcödé/ключ/鍵",
+		"percent": "/app/100%/50_percent",
+	} {
+		results := search(t, store, searchStr, kvstore.SearchOptions{Mode: kvstore.SearchModeFuzzy})
+		if _, ok := findHit(results, key); !ok {
+			t.Errorf("fuzzy Search(%q) = %v, want it to contain %q", searchStr, hitKeys(results), key)
+		}
+	}
+}
+
+func testEmptyValue(t *testing.T, store kvstore.KVStore) {
+	mustPutBatch(t, store, []common.KV{{Key: "/flags/empty", Value: ""}})
+	assertValue(t, store, "/flags/empty", "")
+
+	if got := listAll(t, store); len(got) != 1 {
+		t.Fatalf("List() returned %d keys, want the key with an empty value", len(got))
+	}
+	results := search(t, store, "/flags/", kvstore.SearchOptions{Mode: kvstore.SearchModePrefix})
+	if _, ok := findHit(results, "/flags/empty"); !ok {
+		t.Fatalf("Search() = %v, want it to contain the key with an empty value", hitKeys(results))
+	}
+}
+
+func testLargeValue(t *testing.T, store kvstore.KVStore) {
+	// etcd accepts requests up to 1.5 MiB by default
+	value := strings.Repeat("0123456789abcdef", 1<<16)
+	mustPutBatch(t, store, []common.KV{{Key: "/blobs/large", Value: value}})
+	assertValue(t, store, "/blobs/large", value)
+
+	got := listAll(t, store)
+	if got["/blobs/large"] != value {
+		t.Fatalf("List() returned a value of %d bytes, want %d bytes", len(got["/blobs/large"]), len(value))
+	}
+}
+
+func testConcurrentWriters(t *testing.T, store kvstore.KVStore) {
+	const writers, keysPerWriter = 8, 25
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, writers)
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range keysPerWriter {
+				key := fmt.Sprintf("/concurrent/%d/%d", w, k)
+				// Every writer also deletes a key of its own, and puts one key twice
+				if err := store.Put(ctx, key, "v1"); err != nil {
+					errCh <- err
+					return
+				}
+				if err := store.PutBatch(ctx, []common.KV{{Key: key, Value: "v2"}}); err != nil {
+					errCh <- err
+					return
+				}
+			}
+			if err := store.Delete(ctx, fmt.Sprintf("/concurrent/%d/0", w)); err != nil {
+				errCh <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Fatalf("concurrent write error = %v", err)
+	}
+	waitForWrites(t, store)
+
+	got := listAll(t, store)
+	if want := writers * (keysPerWriter - 1); len(got) != want {
+		t.Fatalf("List() returned %d keys, want %d", len(got), want)
+	}
+	for key, value := range got {
+		if value != "v2" {
+			t.Fatalf("key %q = %q, want the last written value v2", key, value)
+		}
+	}
+}
+
+func testList(t *testing.T, store kvstore.KVStore) {
+	kvs, next, err := store.List(context.Background(), "", 10)
+	if err != nil {
+		t.Fatalf("List() of an empty store error = %v", err)
+	}
+	if len(kvs) != 0 || next != "" {
+		t.Fatalf("List() of an empty store returned %d keys and cursor %q", len(kvs), next)
+	}
+
+	var batch []common.KV
+	for i := range 30 {
+		batch = append(batch, common.KV{Key: fmt.Sprintf("/list/%02d", i), Value: fmt.Sprint(i)})
+	}
+	mustPutBatch(t, store, batch)
+
+	got := listAll(t, store)
+	if len(got) != len(batch) {
+		t.Fatalf("List() returned %d keys, want %d", len(got), len(batch))
+	}
+	for _, kv := range batch {
+		if got[kv.Key] != kv.Value {
+			t.Fatalf("List() returned %q = %q, want %q", kv.Key, got[kv.Key], kv.Value)
+		}
+	}
+
+	// A scan started meanwhile does not move the cursor of another one
+	first, cursor, err := store.List(context.Background(), "", 10)
+	if err != nil || len(first) != 10 || cursor == "" {
+		t.Fatalf("List() = %d keys, cursor %q, error %v, want 10 keys and a cursor", len(first), cursor, err)
+	}
+	if _, _, err := store.List(context.Background(), "", 20); err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	second, _, err := store.List(context.Background(), cursor, 10)
+	if err != nil || len(second) != 10 {
+		t.Fatalf("List(%q) = %d keys, error %v, want 10 keys", cursor, len(second), err)
+	}
+	for _, kv := range second {
+		if slices.ContainsFunc(first, func(other common.KV) bool { return other.Key == kv.Key }) {
+			t.Fatalf("List(%q) returned key %q of the first page again", cursor, kv.Key)
+		}
+	}
+}
+
+func testSearchScope(t *testing.T, store kvstore.KVStore) {
+	mustPutBatch(t, store, []common.KV{
+		{Key: "/services/billing/endpoint", Value: "https://payments.internal"},
+		{Key: "/services/payments/endpoint", Value: "https://billing.internal"},
+	})
+
+	keys := search(t, store, "payments", kvstore.SearchOptions{Scope: kvstore.SearchScopeKeys})
+	if got := hitKeys(keys); !slices.Equal(got, []string{"/services/payments/endpoint"}) {
+		t.Errorf("Search(keys) = %v, want only the key containing the term", got)
+	}
+
+	values := search(t, store, "payments", kvstore.SearchOptions{Scope: kvstore.SearchScopeValues})
+	if got := hitKeys(values); !slices.Equal(got, []string{"/services/billing/endpoint"}) {
+		t.Errorf("Search(values) = %v, want only the key whose value contains the term", got)
+	}
+	if hit, ok := findHit(values, "/services/billing/endpoint"); ok && !slices.Equal(hit.MatchedFields, []string{"value"}) {
+		t.Errorf("Search(values) matched fields = %v, want [value]", hit.MatchedFields)
+	}
+
+	both := search(t, store, "payments", kvstore.SearchOptions{Scope: kvstore.SearchScopeBoth})
+	if len(both.Hits) != 2 {
+		t.Errorf("Search(both) = %v, want both keys", hitKeys(both))
+	}
+}
+
+func testSearchPagination(t *testing.T, store kvstore.KVStore) {
+	var batch []common.KV
+	for i := range 25 {
+		batch = append(batch, common.KV{Key: fmt.Sprintf("/pages/item%02d", i), Value: "x"})
+	}
+	mustPutBatch(t, store, batch)
+
+	seen := make(map[string]struct{})
+	for offset := int64(0); offset < 25; offset += 10 {
+		results := search(t, store, "/pages/", kvstore.SearchOptions{Mode: kvstore.SearchModePrefix, Offset: offset, Limit: 10})
+		if results.EstimatedTotalHits != 25 {
+			t.Fatalf("Search() estimated total hits = %d, want 25", results.EstimatedTotalHits)
+		}
+		if want := min(10, 25-int(offset)); len(results.Hits) != want {
+			t.Fatalf("Search() at offset %d returned %d hits, want %d", offset, len(results.Hits), want)
+		}
+		for _, key := range hitKeys(results) {
+			if _, ok := seen[key]; ok {
+				t.Fatalf("Search() returned %q on two pages", key)
+			}
+			seen[key] = struct{}{}
+		}
+	}
+}
+
+func testSearchModes(t *testing.T, store kvstore.KVStore) {
+	mustPutBatch(t, store, []common.KV{
+		{Key: "/services/api/replicas", Value: "3"},
+		{Key: "/services/web/replicas", Value: "2"},
+		{Key: "/services/web/extra/replicas", Value: "1"},
+		{Key: "/tenants/42/quota", Value: "10"},
+		{Key: "/tenants/abc/quota", Value: "20"},
+	})
+
+	for _, tt := range []struct {
+		mode      kvstore.SearchMode
+		searchStr string
+		want      []string
+	}{
+		{kvstore.SearchModePrefix, "/services/web/", []string{"/services/web/extra/replicas", "/services/web/replicas"}},
+		{kvstore.SearchModePrefix, "/services/w", []string{"/services/web/extra/replicas", "/services/web/replicas"}},
+		{kvstore.SearchModePrefix, "/tenants/42/quota", []string{"/tenants/42/quota"}},
+		{kvstore.SearchModeGlob, "/services/*/replicas", []string{"/services/api/replicas", "/services/web/replicas"}},
+		{kvstore.SearchModeRegex, "^/tenants/[0-9]+/quota$", []string{"/tenants/42/quota"}},
+	} {
+		results := search(t, store, tt.searchStr, kvstore.SearchOptions{Mode: tt.mode})
+		got := hitKeys(results)
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s Search(%q) = %v, want %v", tt.mode, tt.searchStr, got, tt.want)
+		}
+	}
+
+	// Only the keys under the literal prefix of a pattern count against the scan cap
+	for mode, searchStr := range map[kvstore.SearchMode]string{
+		kvstore.SearchModePrefix: "/tenants/",
+		kvstore.SearchModeGlob:   "/tenants/*/quota",
+		kvstore.SearchModeRegex:  "^/tenants/.+/quota$",
+	} {
+		results := search(t, store, searchStr, kvstore.SearchOptions{Mode: mode, MaxScanKeys: 2})
+		if got := hitKeys(results); len(got) != 2 {
+			t.Errorf("%s Search(%q) with MaxScanKeys 2 = %v, want both tenant quotas", mode, searchStr, got)
+		}
+	}
+
+	for mode, searchStr := range map[kvstore.SearchMode]string{
+		kvstore.SearchModeRegex: "^/tenants/[0-9+/quota$",
+		kvstore.SearchModeGlob:  "/services/[web/replicas",
+	} {
+		_, err := store.Search(context.Background(), searchStr, kvstore.SearchOptions{Mode: mode})
+		if !errors.Is(err, customerrors.ErrMalformedSearchString) {
+			t.Errorf("%s Search(%q) error = %v, want ErrMalformedSearchString", mode, searchStr, err)
+		}
+	}
+}
+
+func testCheckpoint(t *testing.T, store kvstore.KVStore) {
+	ctx := context.Background()
+
+	revision, err := store.GetCheckpoint(ctx)
+	if err != nil {
+		t.Fatalf("GetCheckpoint() error = %v", err)
+	}
+	if revision != 0 {
+		t.Fatalf("GetCheckpoint() of an empty store = %d, want 0", revision)
+	}
+
+	for _, want := range []int64{42, 1 << 40} {
+		if err := store.SetCheckpoint(ctx, want); err != nil {
+			t.Fatalf("SetCheckpoint(%d) error = %v", want, err)
+		}
+		got, err := store.GetCheckpoint(ctx)
+		if err != nil {
+			t.Fatalf("GetCheckpoint() error = %v", err)
+		}
+		if got != want {
+			t.Fatalf("GetCheckpoint() = %d, want %d", got, want)
+		}
+	}
+}
+
+func testRebuild(t *testing.T, store kvstore.KVStore) {
+	ctx := context.Background()
+	mustPutBatch(t, store, []common.KV{
+		{Key: "/rebuild/stale", Value: "old"},
+		{Key: "/rebuild/kept", Value: "old"},
+	})
+
+	// An aborted rebuild leaves the store untouched
+	if err := store.BeginRebuild(ctx); err != nil {
+		t.Fatalf("BeginRebuild() error = %v", err)
+	}
+	if err := store.PutRebuildBatch(ctx, []common.KV{{Key: "/rebuild/aborted", Value: "x"}}); err != nil {
+		t.Fatalf("PutRebuildBatch() error = %v", err)
+	}
+	if err := store.AbortRebuild(ctx); err != nil {
+		t.Fatalf("AbortRebuild() error = %v", err)
+	}
+	waitForWrites(t, store)
+	assertMissing(t, store, "/rebuild/aborted")
+	assertValue(t, store, "/rebuild/stale", "old")
+
+	if err := store.BeginRebuild(ctx); err != nil {
+		t.Fatalf("BeginRebuild() error = %v", err)
+	}
+	if err := store.PutRebuildBatch(ctx, []common.KV{{Key: "/rebuild/kept", Value: "snapshot"}}); err != nil {
+		t.Fatalf("PutRebuildBatch() error = %v", err)
+	}
+	// Writes during the rebuild apply to the live store and to the copy being rebuilt
+	if err := store.Put(ctx, "/rebuild/live", "during"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	waitForWrites(t, store)
+	assertValue(t, store, "/rebuild/live", "during")
+	assertValue(t, store, "/rebuild/kept", "old")
+
+	if err := store.CommitRebuild(ctx); err != nil {
+		t.Fatalf("CommitRebuild() error = %v", err)
+	}
+	waitForWrites(t, store)
+
+	want := map[string]string{
+		"/rebuild/kept": "snapshot",
+		"/rebuild/live": "during",
+	}
+	got := listAll(t, store)
+	if len(got) != len(want) {
+		t.Fatalf("List() after the rebuild = %v, want %v", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Fatalf("List() after the rebuild = %v, want %v", got, want)
+		}
+	}
+	assertMissing(t, store, "/rebuild/stale")
+}
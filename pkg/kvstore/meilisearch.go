@@ -4,20 +4,40 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/cespare/xxhash/v2"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
 	"github.com/etcdfinder/etcdfinder/internal/lib"
 	"github.com/etcdfinder/etcdfinder/pkg/common"
 	"github.com/etcdfinder/etcdfinder/pkg/logger"
 	"github.com/meilisearch/meilisearch-go"
 )
 
+const (
+	metaIndexSuffix   = "-meta"
+	shadowIndexSuffix = "-shadow"
+	checkpointDocID   = "checkpoint"
+	// meilisearchMaxTotalHits is the number of hits a full-text search can page through,
+	// Meilisearch stops at 1000 by default and reports no more hits past it
+	meilisearchMaxTotalHits = 10000
+)
+
 // MeilisearchStore implements the KVStore interface using Meilisearch
 type MeilisearchStore struct {
 	client           meilisearch.ServiceManager
 	indexName        string
+	metaIndexName    string // index holding the checkpoint document
+	shadowIndexName  string // index being rebuilt, swapped with the live index once complete
+	rebuilding       atomic.Bool
+	dirsIndexed      atomic.Bool // whether every document of the live index holds its dirs
 	matchingStrategy meilisearch.MatchingStrategy
+	tasks            *taskTracker
+	stopTasks        context.CancelFunc
 }
 
 func makeID(key string) string {
@@ -29,21 +49,96 @@ func createDocument(key string, value string) map[string]any {
 		lib.ID_CONSTANT:    makeID(key), // Meilisearch uses 'id' as the default primary key
 		lib.KEY_CONSTANT:   key,
 		lib.VALUE_CONSTANT: value,
+		lib.DIRS_CONSTANT:  keyDirs(key),
 	}
 }
 
+// keyDirs returns the directories of the key, the prefixes of it ending with a separator, e.g. / and /a/ for /a/b
+// Filtering on them reads the keys under a directory without scanning the whole index
+func keyDirs(key string) []string {
+	dirs := []string{}
+	for i := range len(key) {
+		if key[i] == '/' {
+			dirs = append(dirs, key[:i+1])
+		}
+	}
+	return dirs
+}
+
 // NewMeilisearchStore creates a new Meilisearch-backed KVStore
-func NewMeilisearchStore(host, indexName, matchingStrategy string) (KVStore, error) {
+// Document writes are enqueued as Meilisearch tasks, whose completion is polled every taskPollInterval milliseconds
+func NewMeilisearchStore(host, indexName, matchingStrategy string, taskPollInterval int64) (KVStore, error) {
+	if taskPollInterval <= 0 {
+		return nil, fmt.Errorf("taskPollInterval must be greater than 0")
+	}
+
 	client := meilisearch.New(host)
 
-	// Delete existing index to start fresh
-	if _, err := client.DeleteIndex(indexName); err != nil {
-		// Ignore error as index might not exist
-		// In a production app we might want to check the error type
-		logger.Errorf("Failed to delete existing index: %v", err)
+	dirsIndexed, err := hasDirs(client, indexName)
+	if err != nil {
+		return nil, err
+	}
+
+	// The index is kept across restarts, the ingestor resumes from the stored checkpoint
+	if err := configureIndex(client, indexName); err != nil {
+		logger.Errorf("Failed to configure index settings: %v", err)
 		return nil, err
 	}
 
+	// Without a checkpoint the ingestor rebuilds the index, which writes the dirs of every document
+	if !dirsIndexed {
+		logger.Infof("Index %s predates the %s attribute, dropping its checkpoint to rebuild it", indexName, lib.DIRS_CONSTANT)
+		if err := dropCheckpoint(client, indexName+metaIndexSuffix, time.Duration(taskPollInterval)*time.Millisecond); err != nil {
+			return nil, err
+		}
+	}
+
+	tasks := newTaskTracker(client, time.Duration(taskPollInterval)*time.Millisecond)
+	tasksCtx, stopTasks := context.WithCancel(context.Background())
+	go tasks.run(tasksCtx)
+
+	store := &MeilisearchStore{
+		client:           client,
+		indexName:        indexName,
+		metaIndexName:    indexName + metaIndexSuffix,
+		shadowIndexName:  indexName + shadowIndexSuffix,
+		matchingStrategy: meilisearch.MatchingStrategy(matchingStrategy),
+		tasks:            tasks,
+		stopTasks:        stopTasks,
+	}
+	store.dirsIndexed.Store(dirsIndexed)
+	return store, nil
+}
+
+// hasDirs reports whether the documents of the index hold their dirs, which is the case of a new index
+func hasDirs(client meilisearch.ServiceManager, indexName string) (bool, error) {
+	attributes, err := client.Index(indexName).GetFilterableAttributes()
+	if err != nil {
+		if msErr, ok := err.(*meilisearch.Error); ok && msErr.StatusCode == 404 {
+			return true, nil
+		}
+		return false, fmt.Errorf("failed to get filterable attributes: %w", err)
+	}
+	return attributes != nil && slices.Contains(*attributes, any(lib.DIRS_CONSTANT)), nil
+}
+
+// dropCheckpoint deletes the checkpoint document and waits for the delete to be applied
+func dropCheckpoint(client meilisearch.ServiceManager, metaIndexName string, pollInterval time.Duration) error {
+	task, err := client.Index(metaIndexName).DeleteDocument(checkpointDocID)
+	if err != nil {
+		if msErr, ok := err.(*meilisearch.Error); ok && msErr.StatusCode == 404 {
+			return nil
+		}
+		return fmt.Errorf("failed to delete checkpoint: %w", err)
+	}
+	if _, err := client.WaitForTask(task.TaskUID, pollInterval); err != nil {
+		return fmt.Errorf("failed to delete checkpoint: %w", err)
+	}
+	return nil
+}
+
+// configureIndex applies the search settings to the index, creating it if it does not exist
+func configureIndex(client meilisearch.ServiceManager, indexName string) error {
 	_, err := client.Index(indexName).UpdateSettings(&meilisearch.Settings{
 		RankingRules: []string{
 			"words",
@@ -55,21 +150,47 @@ func NewMeilisearchStore(host, indexName, matchingStrategy string) (KVStore, err
 		},
 		SearchableAttributes: []string{
 			lib.KEY_CONSTANT,
+			lib.VALUE_CONSTANT,
 		},
 		FilterableAttributes: []string{
 			lib.KEY_CONSTANT,
+			lib.DIRS_CONSTANT,
+		},
+		Pagination: &meilisearch.Pagination{
+			MaxTotalHits: meilisearchMaxTotalHits,
 		},
 	})
-	if err != nil {
-		logger.Errorf("Failed to configure index settings: %v", err)
-		return nil, err
+	return err
+}
+
+// writeIndexes returns the indexes document writes are applied to
+func (ms *MeilisearchStore) writeIndexes() []string {
+	if ms.rebuilding.Load() {
+		return []string{ms.indexName, ms.shadowIndexName}
 	}
+	return []string{ms.indexName}
+}
 
-	return &MeilisearchStore{
-		client:           client,
-		indexName:        indexName,
-		matchingStrategy: meilisearch.MatchingStrategy(matchingStrategy),
-	}, nil
+func (ms *MeilisearchStore) addDocuments(indexes []string, docs []map[string]any) error {
+	for _, index := range indexes {
+		task, err := ms.client.Index(index).AddDocuments(docs, nil)
+		if err != nil {
+			return fmt.Errorf("failed to add documents: %w", err)
+		}
+		ms.tasks.track(task)
+	}
+	return nil
+}
+
+func (ms *MeilisearchStore) deleteDocuments(indexes []string, ids []string) error {
+	for _, index := range indexes {
+		task, err := ms.client.Index(index).DeleteDocuments(ids)
+		if err != nil {
+			return fmt.Errorf("failed to delete documents: %w", err)
+		}
+		ms.tasks.track(task)
+	}
+	return nil
 }
 
 // Get retrieves the value for a given key
@@ -91,11 +212,7 @@ func (ms *MeilisearchStore) Get(ctx context.Context, key string) (string, error)
 // Put stores or updates a key-value pair
 func (ms *MeilisearchStore) Put(ctx context.Context, key string, value string) error {
 	doc := createDocument(key, value)
-	_, err := ms.client.Index(ms.indexName).AddDocuments([]map[string]any{doc}, nil)
-	if err != nil {
-		return fmt.Errorf("failed to add document: %w", err)
-	}
-	return nil
+	return ms.addDocuments(ms.writeIndexes(), []map[string]any{doc})
 }
 
 // PutBatch stores or updates a batch of key-value pairs
@@ -104,28 +221,46 @@ func (ms *MeilisearchStore) PutBatch(ctx context.Context, kvs []common.KV) error
 	for _, kv := range kvs {
 		items = append(items, createDocument(kv.Key, kv.Value))
 	}
-	_, err := ms.client.Index(ms.indexName).AddDocuments(items, nil)
-	if err != nil {
-		return fmt.Errorf("failed to add documents: %w", err)
-	}
-	return nil
+	return ms.addDocuments(ms.writeIndexes(), items)
 }
 
 // Search searches for keys or values matching the search string
-func (ms *MeilisearchStore) Search(ctx context.Context, searchStr string) ([]common.KV, error) {
+func (ms *MeilisearchStore) Search(ctx context.Context, searchStr string, opts SearchOptions) (SearchResults, error) {
+	// Meilisearch has no pattern matching, exact modes are evaluated against the documents
+	// under the directory of the key prefix of the pattern, or against every document if it has none
+	if opts.Mode.Exact() {
+		prefix := scanPrefix(searchStr, opts)
+		if dir := prefix[:strings.LastIndex(prefix, "/")+1]; dir != "" && ms.dirsIndexed.Load() {
+			return scanSearch(ctx, ms.listDir(dir), searchStr, opts)
+		}
+		return scanSearch(ctx, ms.List, searchStr, opts)
+	}
+
+	// Past the cap Meilisearch silently returns no hits, which would read as the end of the results
+	if opts.Offset >= meilisearchMaxTotalHits {
+		return SearchResults{}, fmt.Errorf("%w: full-text results end at offset %d", customerrors.ErrInvalidPagination, meilisearchMaxTotalHits)
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
 	searchRes, err := ms.client.Index(ms.indexName).Search(searchStr, &meilisearch.SearchRequest{
-		Limit:            100, // Set a reasonable limit
-		MatchingStrategy: ms.matchingStrategy,
+		Offset:               opts.Offset,
+		Limit:                min(limit, MaxSearchLimit),
+		MatchingStrategy:     ms.matchingStrategy,
+		AttributesToSearchOn: searchAttributes(opts.Scope),
+		ShowMatchesPosition:  true,
 	})
 	if err != nil {
 		if msErr, ok := err.(*meilisearch.Error); ok && msErr.StatusCode == 404 {
 			logger.Infof("Index not found during search, returning empty results: %v", err)
-			return []common.KV{}, nil
+			return SearchResults{Hits: []SearchHit{}}, nil
 		}
-		return nil, fmt.Errorf("search failed: %w", err)
+		return SearchResults{}, fmt.Errorf("search failed: %w", err)
 	}
 
-	var kvs []common.KV
+	hits := []SearchHit{}
 	for _, hit := range searchRes.Hits {
 		// hit is map[string]json.RawMessage
 		var key, value string
@@ -143,28 +278,217 @@ func (ms *MeilisearchStore) Search(ctx context.Context, searchStr string) ([]com
 			}
 		}
 
-		if key != "" && value != "" {
-			kvs = append(kvs, common.KV{
-				Key:   key,
-				Value: value,
+		// Values can legitimately be empty
+		if key != "" {
+			hits = append(hits, SearchHit{
+				KV: common.KV{
+					Key:   key,
+					Value: value,
+				},
+				MatchedFields: matchedFields(hit),
 			})
 		}
 	}
 
-	return kvs, nil
+	return SearchResults{
+		Hits:               hits,
+		EstimatedTotalHits: searchRes.EstimatedTotalHits,
+	}, nil
+}
+
+// searchAttributes returns the document attributes searched for the scope
+func searchAttributes(scope SearchScope) []string {
+	switch scope {
+	case SearchScopeValues:
+		return []string{lib.VALUE_CONSTANT}
+	case SearchScopeBoth:
+		return []string{lib.KEY_CONSTANT, lib.VALUE_CONSTANT}
+	default:
+		return []string{lib.KEY_CONSTANT}
+	}
+}
+
+// matchedFields returns the attributes of the hit the search matched, read from its _matchesPosition
+func matchedFields(hit meilisearch.Hit) []string {
+	rawPositions, ok := hit["_matchesPosition"]
+	if !ok {
+		return nil
+	}
+	var positions map[string]json.RawMessage
+	if err := json.Unmarshal(rawPositions, &positions); err != nil {
+		return nil
+	}
+
+	var fields []string
+	for _, field := range []string{lib.KEY_CONSTANT, lib.VALUE_CONSTANT} {
+		if _, ok := positions[field]; ok {
+			fields = append(fields, field)
+		}
+	}
+	return fields
 }
 
 // Delete removes a key-value pair
 func (ms *MeilisearchStore) Delete(ctx context.Context, key string) error {
-	_, err := ms.client.Index(ms.indexName).DeleteDocument(makeID(key))
+	return ms.deleteDocuments(ms.writeIndexes(), []string{makeID(key)})
+}
+
+// DeleteBatch removes a batch of key-value pairs
+func (ms *MeilisearchStore) DeleteBatch(ctx context.Context, keys []string) error {
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, makeID(key))
+	}
+	return ms.deleteDocuments(ms.writeIndexes(), ids)
+}
+
+// List returns a page of the documents of the index
+func (ms *MeilisearchStore) List(ctx context.Context, cursor string, limit int64) ([]common.KV, string, error) {
+	return ms.listDocuments(ctx, cursor, limit, nil)
+}
+
+// listDir returns a List of the documents under the directory dir only
+func (ms *MeilisearchStore) listDir(dir string) func(ctx context.Context, cursor string, limit int64) ([]common.KV, string, error) {
+	// Filter strings escape double quotes and backslashes with a backslash
+	filter := fmt.Sprintf(`%s = "%s"`, lib.DIRS_CONSTANT, strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(dir))
+	return func(ctx context.Context, cursor string, limit int64) ([]common.KV, string, error) {
+		return ms.listDocuments(ctx, cursor, limit, filter)
+	}
+}
+
+// listDocuments returns a page of the documents matching filter, every document if it is nil
+func (ms *MeilisearchStore) listDocuments(ctx context.Context, cursor string, limit int64, filter any) ([]common.KV, string, error) {
+	// The documents are listed in the order of their ids, the cursor is the offset of the next page
+	var offset int64
+	if cursor != "" {
+		var err error
+		if offset, err = strconv.ParseInt(cursor, 10, 64); err != nil || offset < 0 {
+			return nil, "", fmt.Errorf("invalid list cursor %q", cursor)
+		}
+	}
+
+	var resp meilisearch.DocumentsResult
+	err := ms.client.Index(ms.indexName).GetDocuments(&meilisearch.DocumentsQuery{
+		Offset: offset,
+		Limit:  limit,
+		Fields: []string{lib.KEY_CONSTANT, lib.VALUE_CONSTANT},
+		Filter: filter,
+	}, &resp)
 	if err != nil {
-		return fmt.Errorf("failed to delete document: %w", err)
+		if msErr, ok := err.(*meilisearch.Error); ok && msErr.StatusCode == 404 {
+			return []common.KV{}, "", nil
+		}
+		return nil, "", fmt.Errorf("failed to get documents: %w", err)
+	}
+
+	kvs := make([]common.KV, 0, len(resp.Results))
+	if err := resp.Results.DecodeInto(&kvs); err != nil {
+		return nil, "", fmt.Errorf("failed to decode documents: %w", err)
+	}
+	if int64(len(kvs)) < limit {
+		return kvs, "", nil
+	}
+	return kvs, strconv.FormatInt(offset+int64(len(kvs)), 10), nil
+}
+
+// GetCheckpoint returns the revision stored in the checkpoint document of the meta index
+func (ms *MeilisearchStore) GetCheckpoint(ctx context.Context) (int64, error) {
+	var doc map[string]any
+	err := ms.client.Index(ms.metaIndexName).GetDocument(checkpointDocID, &meilisearch.DocumentQuery{}, &doc)
+	if err != nil {
+		if msErr, ok := err.(*meilisearch.Error); ok && msErr.StatusCode == 404 {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to get checkpoint: %w", err)
+	}
+
+	// JSON numbers are decoded as float64
+	revision, ok := doc[lib.REVISION_CONSTANT].(float64)
+	if !ok {
+		return 0, fmt.Errorf("revision field not found or not a number in checkpoint document")
+	}
+	return int64(revision), nil
+}
+
+// SetCheckpoint stores the revision in the checkpoint document of the meta index
+// Meilisearch processes tasks in enqueue order, so the checkpoint is never applied before the documents written until now
+func (ms *MeilisearchStore) SetCheckpoint(ctx context.Context, revision int64) error {
+	doc := map[string]any{
+		lib.ID_CONSTANT:       checkpointDocID,
+		lib.REVISION_CONSTANT: revision,
+	}
+	_, err := ms.client.Index(ms.metaIndexName).AddDocuments([]map[string]any{doc}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to set checkpoint: %w", err)
+	}
+	return nil
+}
+
+// BeginRebuild creates an empty shadow index with the settings of the live index
+// Document writes are applied to both indexes until the rebuild is committed or aborted
+func (ms *MeilisearchStore) BeginRebuild(ctx context.Context) error {
+	// Drop the leftovers of an interrupted rebuild
+	if _, err := ms.client.DeleteIndex(ms.shadowIndexName); err != nil {
+		return fmt.Errorf("failed to delete shadow index: %w", err)
+	}
+	if err := configureIndex(ms.client, ms.shadowIndexName); err != nil {
+		return fmt.Errorf("failed to configure shadow index: %w", err)
+	}
+	ms.rebuilding.Store(true)
+	return nil
+}
+
+// PutRebuildBatch stores a batch of key-value pairs in the shadow index only
+func (ms *MeilisearchStore) PutRebuildBatch(ctx context.Context, kvs []common.KV) error {
+	items := make([]map[string]any, 0, len(kvs))
+	for _, kv := range kvs {
+		items = append(items, createDocument(kv.Key, kv.Value))
+	}
+	return ms.addDocuments([]string{ms.shadowIndexName}, items)
+}
+
+// CommitRebuild swaps the shadow index with the live index and deletes the previous documents
+// Meilisearch processes tasks in enqueue order, so the swap happens after every write enqueued so far
+func (ms *MeilisearchStore) CommitRebuild(ctx context.Context) error {
+	task, err := ms.client.SwapIndexes([]*meilisearch.SwapIndexesParams{
+		{Indexes: []string{ms.indexName, ms.shadowIndexName}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to swap indexes: %w", err)
+	}
+	ms.tasks.track(task)
+	ms.rebuilding.Store(false)
+	ms.dirsIndexed.Store(true)
+
+	// After the swap, the shadow index holds the previous documents of the live index
+	if _, err := ms.client.DeleteIndex(ms.shadowIndexName); err != nil {
+		return fmt.Errorf("failed to delete shadow index: %w", err)
 	}
 	return nil
 }
 
+// AbortRebuild stops writing to the shadow index and deletes it
+func (ms *MeilisearchStore) AbortRebuild(ctx context.Context) error {
+	ms.rebuilding.Store(false)
+	if _, err := ms.client.DeleteIndex(ms.shadowIndexName); err != nil {
+		return fmt.Errorf("failed to delete shadow index: %w", err)
+	}
+	return nil
+}
+
+// WaitForWrites blocks until the Meilisearch tasks of the document writes enqueued so far have completed
+func (ms *MeilisearchStore) WaitForWrites(ctx context.Context) error {
+	return ms.tasks.wait(ctx)
+}
+
+// WriteStatus returns the state of the Meilisearch tasks of the document writes
+func (ms *MeilisearchStore) WriteStatus() WriteStatus {
+	return ms.tasks.status()
+}
+
 // Close closes the Meilisearch client
 func (ms *MeilisearchStore) Close(ctx context.Context) error {
 	// Meilisearch client doesn't need explicit closing as it uses http.Client
+	ms.stopTasks()
 	return nil
 }
@@ -0,0 +1,148 @@
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+	"github.com/meilisearch/meilisearch-go"
+)
+
+// maxTasksPerPoll is the number of task uids sent in a single GetTasks call
+const maxTasksPerPoll = 100
+
+// taskTracker confirms the completion of the Meilisearch tasks enqueued by the store
+// Meilisearch writes are asynchronous, a failed task would otherwise go unnoticed
+type taskTracker struct {
+	client       meilisearch.ServiceManager
+	pollInterval time.Duration
+
+	mu        sync.Mutex
+	pending   map[int64]*trackedTask
+	failed    int64
+	lastError string
+}
+
+type trackedTask struct {
+	done chan struct{} // closed once the task has succeeded or failed
+	err  error
+}
+
+func newTaskTracker(client meilisearch.ServiceManager, pollInterval time.Duration) *taskTracker {
+	return &taskTracker{
+		client:       client,
+		pollInterval: pollInterval,
+		pending:      make(map[int64]*trackedTask),
+	}
+}
+
+// track registers an enqueued task so that its completion is confirmed
+func (t *taskTracker) track(info *meilisearch.TaskInfo) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending[info.TaskUID] = &trackedTask{
+		done: make(chan struct{}),
+	}
+}
+
+// run polls the status of the pending tasks every pollInterval until ctx is cancelled
+func (t *taskTracker) run(ctx context.Context) {
+	ticker := time.NewTicker(t.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := t.poll(ctx); err != nil {
+				logger.Warnf("Failed to poll Meilisearch tasks: %v", err)
+			}
+		}
+	}
+}
+
+func (t *taskTracker) poll(ctx context.Context) error {
+	t.mu.Lock()
+	uids := make([]int64, 0, len(t.pending))
+	for uid := range t.pending {
+		uids = append(uids, uid)
+	}
+	t.mu.Unlock()
+
+	for start := 0; start < len(uids); start += maxTasksPerPoll {
+		chunk := uids[start:min(start+maxTasksPerPoll, len(uids))]
+		res, err := t.client.GetTasksWithContext(ctx, &meilisearch.TasksQuery{
+			UIDS:  chunk,
+			Limit: int64(len(chunk)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get tasks: %w", err)
+		}
+
+		for _, task := range res.Results {
+			switch task.Status {
+			case meilisearch.TaskStatusSucceeded:
+				t.complete(task.UID, nil)
+			case meilisearch.TaskStatusFailed, meilisearch.TaskStatusCanceled:
+				err := fmt.Errorf("task %d %s: %s", task.UID, task.Status, task.Error.Message)
+				logger.Errorf("Meilisearch write failed, the index may have drifted from etcd: %v", err)
+				t.complete(task.UID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (t *taskTracker) complete(uid int64, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tt, ok := t.pending[uid]
+	if !ok {
+		return
+	}
+	delete(t.pending, uid)
+	if err != nil {
+		t.failed++
+		t.lastError = err.Error()
+	}
+	tt.err = err
+	close(tt.done)
+}
+
+// wait blocks until all the tasks tracked so far have completed
+// Returns the error of the first failed task, if any
+func (t *taskTracker) wait(ctx context.Context) error {
+	t.mu.Lock()
+	tasks := make([]*trackedTask, 0, len(t.pending))
+	for _, tt := range t.pending {
+		tasks = append(tasks, tt)
+	}
+	t.mu.Unlock()
+
+	for _, tt := range tasks {
+		select {
+		case <-tt.done:
+			if tt.err != nil {
+				return tt.err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (t *taskTracker) status() WriteStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return WriteStatus{
+		PendingWrites: len(t.pending),
+		FailedWrites:  t.failed,
+		LastError:     t.lastError,
+	}
+}
@@ -0,0 +1,31 @@
+package kvstore_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore/kvstoretest"
+)
+
+// The Meilisearch conformance tests run against the server at MEILISEARCH_HOST, e.g. http://localhost:7700
+func TestMeilisearchStoreConformance(t *testing.T) {
+	host := os.Getenv("MEILISEARCH_HOST")
+	if host == "" {
+		t.Skip("MEILISEARCH_HOST is not set")
+	}
+
+	kvstoretest.Run(t, func(t *testing.T) kvstore.KVStore {
+		// Every test gets its own index
+		indexName := fmt.Sprintf("conformance-%d", time.Now().UnixNano())
+		store, err := kvstore.NewMeilisearchStore(host, indexName, "all", 50)
+		if err != nil {
+			t.Fatalf("NewMeilisearchStore() error = %v", err)
+		}
+		t.Cleanup(func() { store.Close(context.Background()) }) //nolint
+		return store
+	})
+}
@@ -0,0 +1,286 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+)
+
+// MemoryStore implements the KVStore interface with an in-process n-gram index
+// If a path is set, the documents and the checkpoint are persisted together to that file every
+// time the checkpoint is stored, so a restart resumes from a consistent state
+type MemoryStore struct {
+	path string // file the store is persisted to, empty to keep it in memory only
+
+	mu         sync.Mutex
+	live       *memoryIndex
+	shadow     *memoryIndex // index being rebuilt, nil if there is no rebuild in progress
+	checkpoint int64
+	dirty      bool // whether the store has changed since it was last persisted
+}
+
+// memorySnapshot is the on-disk format of a MemoryStore
+type memorySnapshot struct {
+	Revision  int64       `json:"revision"`
+	Documents []common.KV `json:"documents"`
+}
+
+// NewMemoryStore creates a new in-process KVStore, loading it from path if the file exists
+func NewMemoryStore(path string) (KVStore, error) {
+	ms := &MemoryStore{
+		path: path,
+		live: newMemoryIndex(),
+	}
+	if path == "" {
+		return ms, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			logger.Infof("No in-memory store snapshot found at %s, starting empty", path)
+			return ms, nil
+		}
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var snapshot memorySnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot %s: %w", path, err)
+	}
+	for _, kv := range snapshot.Documents {
+		ms.live.put(kv.Key, kv.Value)
+	}
+	ms.checkpoint = snapshot.Revision
+	logger.Infof("Loaded %d keys at revision %d from %s", len(snapshot.Documents), snapshot.Revision, path)
+
+	return ms, nil
+}
+
+// indexes returns the indexes document writes are applied to
+func (ms *MemoryStore) indexes() []*memoryIndex {
+	if ms.shadow != nil {
+		return []*memoryIndex{ms.live, ms.shadow}
+	}
+	return []*memoryIndex{ms.live}
+}
+
+// Get retrieves a value by key
+func (ms *MemoryStore) Get(ctx context.Context, key string) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	value, ok := ms.live.docs[key]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", customerrors.ErrKeyNotFound, key)
+	}
+	return value, nil
+}
+
+// Put stores or updates a key-value pair
+func (ms *MemoryStore) Put(ctx context.Context, key string, value string) error {
+	return ms.PutBatch(ctx, []common.KV{{Key: key, Value: value}})
+}
+
+// PutBatch stores or updates a batch of key-value pairs
+func (ms *MemoryStore) PutBatch(ctx context.Context, kvs []common.KV) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, index := range ms.indexes() {
+		for _, kv := range kvs {
+			index.put(kv.Key, kv.Value)
+		}
+	}
+	ms.dirty = true
+	return nil
+}
+
+// Search searches for keys or values matching the search string
+func (ms *MemoryStore) Search(ctx context.Context, searchStr string, opts SearchOptions) (SearchResults, error) {
+	var match Matcher
+	if opts.Mode.Exact() {
+		var err error
+		if match, err = CompileMatcher(opts.Mode, searchStr); err != nil {
+			return SearchResults{}, err
+		}
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if match == nil {
+		return pageHits(ms.live.search(searchStr, opts.Scope), opts), nil
+	}
+
+	hits := []SearchHit{}
+	for _, key := range ms.live.keys() {
+		kv := common.KV{
+			Key:   key,
+			Value: ms.live.docs[key],
+		}
+		if fields := matchKV(match, opts.Scope, kv); len(fields) > 0 {
+			hits = append(hits, SearchHit{
+				KV:            kv,
+				MatchedFields: fields,
+			})
+		}
+	}
+	return pageHits(hits, opts), nil
+}
+
+// Delete removes a key-value pair
+func (ms *MemoryStore) Delete(ctx context.Context, key string) error {
+	return ms.DeleteBatch(ctx, []string{key})
+}
+
+// DeleteBatch removes a batch of key-value pairs
+func (ms *MemoryStore) DeleteBatch(ctx context.Context, keys []string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, index := range ms.indexes() {
+		for _, key := range keys {
+			index.delete(key)
+		}
+	}
+	ms.dirty = true
+	return nil
+}
+
+// List returns a page of the key-value pairs in key order, the cursor is the last key of the previous page
+func (ms *MemoryStore) List(ctx context.Context, cursor string, limit int64) ([]common.KV, string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	kvs := ms.live.list(cursor, limit)
+	return kvs, keyCursor(kvs, limit), nil
+}
+
+// GetCheckpoint returns the revision the store was last persisted at
+func (ms *MemoryStore) GetCheckpoint(ctx context.Context) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.checkpoint, nil
+}
+
+// SetCheckpoint stores the revision and persists the store if a path is set
+func (ms *MemoryStore) SetCheckpoint(ctx context.Context, revision int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.checkpoint != revision {
+		ms.checkpoint = revision
+		ms.dirty = true
+	}
+	return ms.persist()
+}
+
+// BeginRebuild starts writing to an empty shadow index alongside the live one
+func (ms *MemoryStore) BeginRebuild(ctx context.Context) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.shadow = newMemoryIndex()
+	return nil
+}
+
+// PutRebuildBatch stores a batch of key-value pairs in the shadow index only
+func (ms *MemoryStore) PutRebuildBatch(ctx context.Context, kvs []common.KV) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.shadow == nil {
+		return fmt.Errorf("no rebuild in progress")
+	}
+	for _, kv := range kvs {
+		ms.shadow.put(kv.Key, kv.Value)
+	}
+	return nil
+}
+
+// CommitRebuild replaces the live index with the shadow index
+func (ms *MemoryStore) CommitRebuild(ctx context.Context) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.shadow == nil {
+		return fmt.Errorf("no rebuild in progress")
+	}
+	ms.live = ms.shadow
+	ms.shadow = nil
+	ms.dirty = true
+	return nil
+}
+
+// AbortRebuild discards the shadow index
+func (ms *MemoryStore) AbortRebuild(ctx context.Context) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.shadow = nil
+	return nil
+}
+
+// WaitForWrites returns immediately, writes are visible as soon as they return
+func (ms *MemoryStore) WaitForWrites(ctx context.Context) error {
+	return nil
+}
+
+// WriteStatus returns an empty status, writes are applied synchronously and cannot fail
+func (ms *MemoryStore) WriteStatus() WriteStatus {
+	return WriteStatus{}
+}
+
+// Close persists the store if a path is set
+func (ms *MemoryStore) Close(ctx context.Context) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.persist()
+}
+
+// persist writes the live index and the checkpoint to the file of the store
+// The file is replaced atomically, so a crash never leaves a partially written snapshot
+// Must be called with mu held
+func (ms *MemoryStore) persist() error {
+	if ms.path == "" || !ms.dirty {
+		return nil
+	}
+
+	snapshot := memorySnapshot{
+		Revision:  ms.checkpoint,
+		Documents: ms.live.list("", int64(len(ms.live.docs))),
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(ms.path), filepath.Base(ms.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) //nolint
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() //nolint
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close() //nolint
+		return fmt.Errorf("failed to sync snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close snapshot file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), ms.path); err != nil {
+		return fmt.Errorf("failed to replace snapshot: %w", err)
+	}
+
+	ms.dirty = false
+	return nil
+}
@@ -0,0 +1,246 @@
+package kvstore
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+)
+
+// gramSize is the length of the n-grams the in-memory index is built from
+const gramSize = 3
+
+// memoryIndex is an in-memory n-gram index of key-value pairs
+// Every lowercased key and value is split into overlapping n-grams, a search term is
+// looked up through its own n-grams and the candidates are then checked by substring
+type memoryIndex struct {
+	docs       map[string]string
+	keyGrams   map[string]map[string]struct{} // n-gram -> keys containing it in the key
+	valueGrams map[string]map[string]struct{} // n-gram -> keys containing it in the value
+	sortedKeys []string                       // keys in lexical order, nil when outdated
+}
+
+func newMemoryIndex() *memoryIndex {
+	return &memoryIndex{
+		docs:       make(map[string]string),
+		keyGrams:   make(map[string]map[string]struct{}),
+		valueGrams: make(map[string]map[string]struct{}),
+	}
+}
+
+// grams returns the distinct n-grams of the lowercased string
+func grams(s string) []string {
+	s = strings.ToLower(s)
+	if len(s) < gramSize {
+		return nil
+	}
+	seen := make(map[string]struct{}, len(s)-gramSize+1)
+	out := make([]string, 0, len(s)-gramSize+1)
+	for i := 0; i+gramSize <= len(s); i++ {
+		g := s[i : i+gramSize]
+		if _, ok := seen[g]; !ok {
+			seen[g] = struct{}{}
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+func addGrams(index map[string]map[string]struct{}, key, s string) {
+	for _, g := range grams(s) {
+		keys, ok := index[g]
+		if !ok {
+			keys = make(map[string]struct{})
+			index[g] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+func removeGrams(index map[string]map[string]struct{}, key, s string) {
+	for _, g := range grams(s) {
+		if keys, ok := index[g]; ok {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(index, g)
+			}
+		}
+	}
+}
+
+func (m *memoryIndex) put(key, value string) {
+	old, exists := m.docs[key]
+	if exists {
+		if old == value {
+			return
+		}
+		removeGrams(m.valueGrams, key, old)
+	} else {
+		addGrams(m.keyGrams, key, key)
+		m.sortedKeys = nil
+	}
+	addGrams(m.valueGrams, key, value)
+	m.docs[key] = value
+}
+
+func (m *memoryIndex) delete(key string) {
+	value, ok := m.docs[key]
+	if !ok {
+		return
+	}
+	removeGrams(m.keyGrams, key, key)
+	removeGrams(m.valueGrams, key, value)
+	delete(m.docs, key)
+	m.sortedKeys = nil
+}
+
+func (m *memoryIndex) keys() []string {
+	if m.sortedKeys == nil {
+		m.sortedKeys = make([]string, 0, len(m.docs))
+		for key := range m.docs {
+			m.sortedKeys = append(m.sortedKeys, key)
+		}
+		sort.Strings(m.sortedKeys)
+	}
+	return m.sortedKeys
+}
+
+// list returns up to limit key-value pairs in key order with a key after the given one
+func (m *memoryIndex) list(after string, limit int64) []common.KV {
+	keys := m.keys()
+	start := sort.Search(len(keys), func(i int) bool { return keys[i] > after })
+	end := min(start+int(limit), len(keys))
+
+	kvs := make([]common.KV, 0, end-start)
+	for _, key := range keys[start:end] {
+		kvs = append(kvs, common.KV{
+			Key:   key,
+			Value: m.docs[key],
+		})
+	}
+	return kvs
+}
+
+// candidates returns the keys that contain every n-gram of term in the field the index covers
+// Returns nil, false if the term is too short to be looked up, every key is then a candidate
+func candidates(index map[string]map[string]struct{}, term string) (map[string]struct{}, bool) {
+	termGrams := grams(term)
+	if len(termGrams) == 0 {
+		return nil, false
+	}
+
+	// Start from the rarest n-gram so that the intersection stays small
+	sort.Slice(termGrams, func(a, b int) bool {
+		return len(index[termGrams[a]]) < len(index[termGrams[b]])
+	})
+	out := make(map[string]struct{}, len(index[termGrams[0]]))
+	for key := range index[termGrams[0]] {
+		out[key] = struct{}{}
+	}
+	for _, g := range termGrams[1:] {
+		for key := range out {
+			if _, ok := index[g][key]; !ok {
+				delete(out, key)
+			}
+		}
+	}
+	return out, true
+}
+
+// search returns the key-value pairs where every term of searchStr is found in one of the fields
+// selected by scope, ignoring case. Hits matching on the key come first, then hits are sorted by key
+func (m *memoryIndex) search(searchStr string, scope SearchScope) []SearchHit {
+	terms := strings.Fields(strings.ToLower(searchStr))
+	searchKeys := scope != SearchScopeValues
+	searchValues := scope == SearchScopeValues || scope == SearchScopeBoth
+
+	// Narrow down the keys to check with the n-grams of the terms
+	var keys map[string]struct{}
+	for _, term := range terms {
+		termKeys := make(map[string]struct{})
+		indexed := true
+		for _, field := range []struct {
+			enabled bool
+			index   map[string]map[string]struct{}
+		}{{searchKeys, m.keyGrams}, {searchValues, m.valueGrams}} {
+			if !field.enabled {
+				continue
+			}
+			found, ok := candidates(field.index, term)
+			if !ok {
+				indexed = false
+				break
+			}
+			for key := range found {
+				termKeys[key] = struct{}{}
+			}
+		}
+		if !indexed {
+			continue
+		}
+		if keys == nil {
+			keys = termKeys
+			continue
+		}
+		for key := range keys {
+			if _, ok := termKeys[key]; !ok {
+				delete(keys, key)
+			}
+		}
+	}
+	if keys == nil {
+		// No term could be looked up, every key is a candidate
+		keys = make(map[string]struct{}, len(m.docs))
+		for key := range m.docs {
+			keys[key] = struct{}{}
+		}
+	}
+
+	hits := []SearchHit{}
+	for key := range keys {
+		value := m.docs[key]
+		lowerKey, lowerValue := strings.ToLower(key), strings.ToLower(value)
+
+		var keyMatched, valueMatched bool
+		matched := true
+		for _, term := range terms {
+			inKey := searchKeys && strings.Contains(lowerKey, term)
+			inValue := searchValues && strings.Contains(lowerValue, term)
+			if !inKey && !inValue {
+				matched = false
+				break
+			}
+			keyMatched = keyMatched || inKey
+			valueMatched = valueMatched || inValue
+		}
+		if !matched {
+			continue
+		}
+
+		var fields []string
+		if keyMatched {
+			fields = append(fields, lib.KEY_CONSTANT)
+		}
+		if valueMatched {
+			fields = append(fields, lib.VALUE_CONSTANT)
+		}
+		hits = append(hits, SearchHit{
+			KV: common.KV{
+				Key:   key,
+				Value: value,
+			},
+			MatchedFields: fields,
+		})
+	}
+
+	sort.Slice(hits, func(a, b int) bool {
+		aKey := len(hits[a].MatchedFields) > 0 && hits[a].MatchedFields[0] == lib.KEY_CONSTANT
+		bKey := len(hits[b].MatchedFields) > 0 && hits[b].MatchedFields[0] == lib.KEY_CONSTANT
+		if aKey != bKey {
+			return aKey
+		}
+		return hits[a].Key < hits[b].Key
+	})
+	return hits
+}
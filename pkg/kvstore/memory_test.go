@@ -0,0 +1,32 @@
+package kvstore_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore/kvstoretest"
+)
+
+func TestMemoryStoreConformance(t *testing.T) {
+	kvstoretest.Run(t, func(t *testing.T) kvstore.KVStore {
+		store, err := kvstore.NewMemoryStore("")
+		if err != nil {
+			t.Fatalf("NewMemoryStore() error = %v", err)
+		}
+		t.Cleanup(func() { store.Close(context.Background()) }) //nolint
+		return store
+	})
+}
+
+func TestPersistedMemoryStoreConformance(t *testing.T) {
+	kvstoretest.Run(t, func(t *testing.T) kvstore.KVStore {
+		store, err := kvstore.NewMemoryStore(filepath.Join(t.TempDir(), "index.json"))
+		if err != nil {
+			t.Fatalf("NewMemoryStore() error = %v", err)
+		}
+		t.Cleanup(func() { store.Close(context.Background()) }) //nolint
+		return store
+	})
+}
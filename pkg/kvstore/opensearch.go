@@ -0,0 +1,607 @@
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+)
+
+// The documents live in one of two concrete indexes, the index name itself is an alias
+// pointing to the live one. A rebuild fills the other index and moves the alias to it
+var opensearchIndexSuffixes = [2]string{"-a", "-b"}
+
+// errOpenSearchNotFound is returned by the OpenSearch requests answered with 404 Not Found
+var errOpenSearchNotFound = errors.New("not found")
+
+// OpenSearchConfig holds the connection and analysis settings of an OpenSearchStore
+type OpenSearchConfig struct {
+	Host          string // base URL of the cluster, e.g. http://localhost:9200
+	IndexName     string // alias searched, the documents are stored in <IndexName>-a or <IndexName>-b
+	Username      string // basic auth username, empty to disable basic auth
+	Password      string
+	KeyMinGram    int64  // minimum length of the edge n-grams keys are indexed with
+	KeyMaxGram    int64  // maximum length of the edge n-grams keys are indexed with
+	ValueAnalyzer string // analyzer of the values, e.g. standard
+}
+
+// OpenSearchStore implements the KVStore interface using the OpenSearch or Elasticsearch REST API
+type OpenSearchStore struct {
+	client        *http.Client
+	config        OpenSearchConfig
+	metaIndexName string // index holding the checkpoint document
+
+	mu        sync.RWMutex
+	live      string // concrete index the alias points to
+	shadow    string // concrete index being rebuilt, empty if there is no rebuild in progress
+	failed    int64
+	lastError string
+}
+
+// NewOpenSearchStore creates a new OpenSearch-backed KVStore
+// The client is used for every request, which lets tests point the store at a local HTTP stand-in
+func NewOpenSearchStore(ctx context.Context, client *http.Client, config OpenSearchConfig) (KVStore, error) {
+	if config.Host == "" || config.IndexName == "" {
+		return nil, fmt.Errorf("host and index name are required")
+	}
+	if config.KeyMinGram <= 0 || config.KeyMaxGram < config.KeyMinGram {
+		return nil, fmt.Errorf("keyMinGram must be greater than 0 and not greater than keyMaxGram")
+	}
+	if config.ValueAnalyzer == "" {
+		config.ValueAnalyzer = "standard"
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	config.Host = strings.TrimSuffix(config.Host, "/")
+
+	o := &OpenSearchStore{
+		client:        client,
+		config:        config,
+		metaIndexName: config.IndexName + metaIndexSuffix,
+	}
+
+	// The alias is kept across restarts, the ingestor resumes from the stored checkpoint
+	live, err := o.aliasTarget(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if live == "" {
+		live = config.IndexName + opensearchIndexSuffixes[0]
+		logger.Infof("Creating OpenSearch index %s behind alias %s", live, config.IndexName)
+		if err := o.createIndex(ctx, live); err != nil {
+			return nil, err
+		}
+		if err := o.updateAliases(ctx, map[string]any{"add": map[string]string{"index": live, "alias": config.IndexName}}); err != nil {
+			return nil, err
+		}
+	}
+	o.live = live
+
+	return o, nil
+}
+
+// do sends a JSON request and decodes the JSON response into out, if not nil
+func (o *OpenSearchStore) do(ctx context.Context, method, path string, body any, out any) error {
+	var reader io.Reader
+	contentType := "application/json"
+	switch b := body.(type) {
+	case nil:
+	case *bytes.Buffer:
+		// Bulk requests are sent as pre-encoded newline delimited JSON
+		reader = b
+		contentType = "application/x-ndjson"
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, o.config.Host+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if reader != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if o.config.Username != "" {
+		req.SetBasicAuth(o.config.Username, o.config.Password)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close() //nolint
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response of %s %s: %w", method, path, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s %s: %w", method, path, errOpenSearchNotFound)
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%s %s failed with status %d: %s", method, path, resp.StatusCode, respBody)
+	}
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return fmt.Errorf("failed to decode response of %s %s: %w", method, path, err)
+		}
+	}
+	return nil
+}
+
+// aliasTarget returns the concrete index the alias points to, empty if the alias does not exist
+func (o *OpenSearchStore) aliasTarget(ctx context.Context) (string, error) {
+	var resp map[string]json.RawMessage
+	err := o.do(ctx, http.MethodGet, "/_alias/"+url.PathEscape(o.config.IndexName), nil, &resp)
+	if err != nil {
+		if errors.Is(err, errOpenSearchNotFound) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get alias: %w", err)
+	}
+	for index := range resp {
+		return index, nil
+	}
+	return "", nil
+}
+
+func (o *OpenSearchStore) updateAliases(ctx context.Context, actions ...map[string]any) error {
+	if err := o.do(ctx, http.MethodPost, "/_aliases", map[string]any{"actions": actions}, nil); err != nil {
+		return fmt.Errorf("failed to update alias: %w", err)
+	}
+	return nil
+}
+
+// createIndex creates an index with the analyzers and mappings of the store
+// Keys are indexed as edge n-grams of their words, so that a search matches any key segment by its beginning
+func (o *OpenSearchStore) createIndex(ctx context.Context, index string) error {
+	body := map[string]any{
+		"settings": map[string]any{
+			"analysis": map[string]any{
+				"tokenizer": map[string]any{
+					"key_edge_ngram": map[string]any{
+						"type":        "edge_ngram",
+						"min_gram":    o.config.KeyMinGram,
+						"max_gram":    o.config.KeyMaxGram,
+						"token_chars": []string{"letter", "digit"},
+					},
+					"key_words": map[string]any{
+						"type":    "pattern",
+						"pattern": `[^\p{L}\p{N}]+`,
+					},
+				},
+				"analyzer": map[string]any{
+					"key_index": map[string]any{
+						"type":      "custom",
+						"tokenizer": "key_edge_ngram",
+						"filter":    []string{"lowercase"},
+					},
+					"key_search": map[string]any{
+						"type":      "custom",
+						"tokenizer": "key_words",
+						"filter":    []string{"lowercase"},
+					},
+				},
+			},
+		},
+		"mappings": map[string]any{
+			"properties": map[string]any{
+				lib.KEY_CONSTANT: map[string]any{
+					"type":            "text",
+					"analyzer":        "key_index",
+					"search_analyzer": "key_search",
+					"fields": map[string]any{
+						"raw": map[string]any{"type": "keyword"},
+					},
+				},
+				lib.VALUE_CONSTANT: map[string]any{
+					"type":     "text",
+					"analyzer": o.config.ValueAnalyzer,
+				},
+			},
+		},
+	}
+	if err := o.do(ctx, http.MethodPut, "/"+url.PathEscape(index), body, nil); err != nil {
+		return fmt.Errorf("failed to create index %s: %w", index, err)
+	}
+	return nil
+}
+
+func (o *OpenSearchStore) deleteIndex(ctx context.Context, index string) error {
+	err := o.do(ctx, http.MethodDelete, "/"+url.PathEscape(index), nil, nil)
+	if err != nil && !errors.Is(err, errOpenSearchNotFound) {
+		return fmt.Errorf("failed to delete index %s: %w", index, err)
+	}
+	return nil
+}
+
+// writeIndexes returns the concrete indexes document writes are applied to
+func (o *OpenSearchStore) writeIndexes() []string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if o.shadow != "" {
+		return []string{o.live, o.shadow}
+	}
+	return []string{o.live}
+}
+
+type bulkResponse struct {
+	Errors bool                            `json:"errors"`
+	Items  []map[string]bulkResponseResult `json:"items"`
+}
+
+type bulkResponseResult struct {
+	ID     string `json:"_id"`
+	Status int    `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// bulk applies the puts and deletes to every index with a single bulk request
+// A document the cluster rejects is counted as a failed write instead of failing the whole batch
+func (o *OpenSearchStore) bulk(ctx context.Context, indexes []string, puts []common.KV, deletes []string) error {
+	if len(puts) == 0 && len(deletes) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, index := range indexes {
+		for _, key := range deletes {
+			if err := enc.Encode(map[string]any{"delete": map[string]string{"_index": index, "_id": makeID(key)}}); err != nil {
+				return fmt.Errorf("failed to encode bulk request: %w", err)
+			}
+		}
+		for _, kv := range puts {
+			if err := enc.Encode(map[string]any{"index": map[string]string{"_index": index, "_id": makeID(kv.Key)}}); err != nil {
+				return fmt.Errorf("failed to encode bulk request: %w", err)
+			}
+			if err := enc.Encode(map[string]string{lib.KEY_CONSTANT: kv.Key, lib.VALUE_CONSTANT: kv.Value}); err != nil {
+				return fmt.Errorf("failed to encode bulk request: %w", err)
+			}
+		}
+	}
+
+	var resp bulkResponse
+	if err := o.do(ctx, http.MethodPost, "/_bulk", &body, &resp); err != nil {
+		return fmt.Errorf("failed to write documents: %w", err)
+	}
+	if !resp.Errors {
+		return nil
+	}
+
+	for _, item := range resp.Items {
+		for action, result := range item {
+			// Deleting a document that does not exist is not a failure
+			if result.Error == nil || (action == "delete" && result.Status == http.StatusNotFound) {
+				continue
+			}
+			err := fmt.Sprintf("%s of document %s failed: %s: %s", action, result.ID, result.Error.Type, result.Error.Reason)
+			logger.Errorf("OpenSearch write failed, the index may have drifted from etcd: %s", err)
+
+			o.mu.Lock()
+			o.failed++
+			o.lastError = err
+			o.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// Get retrieves a value by key
+func (o *OpenSearchStore) Get(ctx context.Context, key string) (string, error) {
+	var resp struct {
+		Source common.KV `json:"_source"`
+	}
+	path := "/" + url.PathEscape(o.config.IndexName) + "/_doc/" + url.PathEscape(makeID(key))
+	if err := o.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		if errors.Is(err, errOpenSearchNotFound) {
+			return "", fmt.Errorf("%w: %s", customerrors.ErrKeyNotFound, key)
+		}
+		return "", fmt.Errorf("failed to get document: %w", err)
+	}
+	return resp.Source.Value, nil
+}
+
+// Put stores or updates a key-value pair
+func (o *OpenSearchStore) Put(ctx context.Context, key string, value string) error {
+	return o.bulk(ctx, o.writeIndexes(), []common.KV{{Key: key, Value: value}}, nil)
+}
+
+// PutBatch stores or updates a batch of key-value pairs
+func (o *OpenSearchStore) PutBatch(ctx context.Context, kvs []common.KV) error {
+	return o.bulk(ctx, o.writeIndexes(), kvs, nil)
+}
+
+type searchResponse struct {
+	Hits struct {
+		Total struct {
+			Value int64 `json:"value"`
+		} `json:"total"`
+		Hits []struct {
+			Source    common.KV           `json:"_source"`
+			Highlight map[string][]string `json:"highlight"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// Search searches for keys or values matching the search string
+func (o *OpenSearchStore) Search(ctx context.Context, searchStr string, opts SearchOptions) (SearchResults, error) {
+	// Exact modes are evaluated against the documents under the key prefix of the pattern,
+	// OpenSearch regular expressions are not RE2
+	if opts.Mode.Exact() {
+		return scanSearch(ctx, o.listPrefix(scanPrefix(searchStr, opts)), searchStr, opts)
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	var should []map[string]any
+	if opts.Scope != SearchScopeValues {
+		should = append(should, map[string]any{
+			"match": map[string]any{
+				lib.KEY_CONSTANT: map[string]any{"query": searchStr, "operator": "and"},
+			},
+		})
+	}
+	if opts.Scope == SearchScopeValues || opts.Scope == SearchScopeBoth {
+		should = append(should, map[string]any{
+			"match": map[string]any{
+				lib.VALUE_CONSTANT: map[string]any{"query": searchStr, "operator": "and", "fuzziness": "AUTO"},
+			},
+		})
+	}
+	query := map[string]any{"match_all": map[string]any{}}
+	if strings.TrimSpace(searchStr) != "" {
+		query = map[string]any{"bool": map[string]any{"should": should, "minimum_should_match": 1}}
+	}
+
+	body := map[string]any{
+		"from":             opts.Offset,
+		"size":             min(limit, MaxSearchLimit),
+		"query":            query,
+		"track_total_hits": true,
+		"highlight": map[string]any{
+			"fields": map[string]any{
+				lib.KEY_CONSTANT:   map[string]any{},
+				lib.VALUE_CONSTANT: map[string]any{},
+			},
+		},
+	}
+
+	var resp searchResponse
+	if err := o.do(ctx, http.MethodPost, "/"+url.PathEscape(o.config.IndexName)+"/_search", body, &resp); err != nil {
+		if errors.Is(err, errOpenSearchNotFound) {
+			logger.Infof("Index not found during search, returning empty results: %v", err)
+			return SearchResults{Hits: []SearchHit{}}, nil
+		}
+		return SearchResults{}, fmt.Errorf("search failed: %w", err)
+	}
+
+	hits := make([]SearchHit, 0, len(resp.Hits.Hits))
+	for _, hit := range resp.Hits.Hits {
+		var fields []string
+		for _, field := range []string{lib.KEY_CONSTANT, lib.VALUE_CONSTANT} {
+			if _, ok := hit.Highlight[field]; ok {
+				fields = append(fields, field)
+			}
+		}
+		hits = append(hits, SearchHit{
+			KV:            hit.Source,
+			MatchedFields: fields,
+		})
+	}
+
+	return SearchResults{
+		Hits:               hits,
+		EstimatedTotalHits: resp.Hits.Total.Value,
+	}, nil
+}
+
+// Delete removes a key-value pair
+func (o *OpenSearchStore) Delete(ctx context.Context, key string) error {
+	return o.bulk(ctx, o.writeIndexes(), nil, []string{key})
+}
+
+// DeleteBatch removes a batch of key-value pairs
+func (o *OpenSearchStore) DeleteBatch(ctx context.Context, keys []string) error {
+	return o.bulk(ctx, o.writeIndexes(), nil, keys)
+}
+
+// List returns a page of the documents of the index in key order, the cursor is the last key of the previous page
+// Pages are read with search_after, so a scan is not bounded by the max_result_window of the index
+func (o *OpenSearchStore) List(ctx context.Context, cursor string, limit int64) ([]common.KV, string, error) {
+	return o.listPrefix("")(ctx, cursor, limit)
+}
+
+// listPrefix returns a List of the documents whose key starts with prefix only
+func (o *OpenSearchStore) listPrefix(prefix string) func(ctx context.Context, cursor string, limit int64) ([]common.KV, string, error) {
+	return func(ctx context.Context, cursor string, limit int64) ([]common.KV, string, error) {
+		return o.list(ctx, prefix, cursor, limit)
+	}
+}
+
+// list returns a page of the documents whose key starts with prefix in key order
+func (o *OpenSearchStore) list(ctx context.Context, prefix, cursor string, limit int64) ([]common.KV, string, error) {
+	body := map[string]any{
+		"size":    limit,
+		"sort":    []map[string]string{{lib.KEY_CONSTANT + ".raw": "asc"}},
+		"_source": []string{lib.KEY_CONSTANT, lib.VALUE_CONSTANT},
+	}
+	if prefix != "" {
+		body["query"] = map[string]any{"prefix": map[string]string{lib.KEY_CONSTANT + ".raw": prefix}}
+	}
+	if cursor != "" {
+		body["search_after"] = []string{cursor}
+	}
+
+	var resp searchResponse
+	if err := o.do(ctx, http.MethodPost, "/"+url.PathEscape(o.config.IndexName)+"/_search", body, &resp); err != nil {
+		if errors.Is(err, errOpenSearchNotFound) {
+			return []common.KV{}, "", nil
+		}
+		return nil, "", fmt.Errorf("failed to get documents: %w", err)
+	}
+
+	kvs := make([]common.KV, 0, len(resp.Hits.Hits))
+	for _, hit := range resp.Hits.Hits {
+		kvs = append(kvs, hit.Source)
+	}
+	return kvs, keyCursor(kvs, limit), nil
+}
+
+// GetCheckpoint returns the revision stored in the checkpoint document of the meta index
+func (o *OpenSearchStore) GetCheckpoint(ctx context.Context) (int64, error) {
+	var resp struct {
+		Source struct {
+			Revision int64 `json:"revision"`
+		} `json:"_source"`
+	}
+	path := "/" + url.PathEscape(o.metaIndexName) + "/_doc/" + checkpointDocID
+	if err := o.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		if errors.Is(err, errOpenSearchNotFound) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to get checkpoint: %w", err)
+	}
+	return resp.Source.Revision, nil
+}
+
+// SetCheckpoint stores the revision in the checkpoint document of the meta index
+func (o *OpenSearchStore) SetCheckpoint(ctx context.Context, revision int64) error {
+	path := "/" + url.PathEscape(o.metaIndexName) + "/_doc/" + checkpointDocID
+	if err := o.do(ctx, http.MethodPut, path, map[string]int64{lib.REVISION_CONSTANT: revision}, nil); err != nil {
+		return fmt.Errorf("failed to set checkpoint: %w", err)
+	}
+	return nil
+}
+
+// BeginRebuild creates an empty shadow index, the concrete index the alias does not point to
+func (o *OpenSearchStore) BeginRebuild(ctx context.Context) error {
+	o.mu.RLock()
+	live := o.live
+	o.mu.RUnlock()
+
+	shadow := o.config.IndexName + opensearchIndexSuffixes[0]
+	if live == shadow {
+		shadow = o.config.IndexName + opensearchIndexSuffixes[1]
+	}
+
+	// Drop the leftovers of an interrupted rebuild
+	if err := o.deleteIndex(ctx, shadow); err != nil {
+		return err
+	}
+	if err := o.createIndex(ctx, shadow); err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.shadow = shadow
+	return nil
+}
+
+// PutRebuildBatch stores a batch of key-value pairs in the shadow index only
+func (o *OpenSearchStore) PutRebuildBatch(ctx context.Context, kvs []common.KV) error {
+	o.mu.RLock()
+	shadow := o.shadow
+	o.mu.RUnlock()
+	if shadow == "" {
+		return fmt.Errorf("no rebuild in progress")
+	}
+	return o.bulk(ctx, []string{shadow}, kvs, nil)
+}
+
+// CommitRebuild atomically moves the alias to the shadow index and deletes the previous index
+func (o *OpenSearchStore) CommitRebuild(ctx context.Context) error {
+	o.mu.RLock()
+	live, shadow := o.live, o.shadow
+	o.mu.RUnlock()
+	if shadow == "" {
+		return fmt.Errorf("no rebuild in progress")
+	}
+
+	// The documents written so far must be searchable once the alias points to the shadow index
+	if err := o.refresh(ctx, shadow); err != nil {
+		return err
+	}
+	err := o.updateAliases(ctx,
+		map[string]any{"remove": map[string]string{"index": live, "alias": o.config.IndexName}},
+		map[string]any{"add": map[string]string{"index": shadow, "alias": o.config.IndexName}},
+	)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	o.live = shadow
+	o.shadow = ""
+	o.mu.Unlock()
+
+	return o.deleteIndex(ctx, live)
+}
+
+// AbortRebuild stops writing to the shadow index and deletes it
+func (o *OpenSearchStore) AbortRebuild(ctx context.Context) error {
+	o.mu.Lock()
+	shadow := o.shadow
+	o.shadow = ""
+	o.mu.Unlock()
+	if shadow == "" {
+		return nil
+	}
+	return o.deleteIndex(ctx, shadow)
+}
+
+func (o *OpenSearchStore) refresh(ctx context.Context, indexes ...string) error {
+	escaped := make([]string, 0, len(indexes))
+	for _, index := range indexes {
+		escaped = append(escaped, url.PathEscape(index))
+	}
+	if err := o.do(ctx, http.MethodPost, "/"+strings.Join(escaped, ",")+"/_refresh", nil, nil); err != nil {
+		return fmt.Errorf("failed to refresh index: %w", err)
+	}
+	return nil
+}
+
+// WaitForWrites refreshes the indexes so that every write accepted so far is visible in searches
+// Bulk requests are synchronous, failed writes have already been reported by WriteStatus
+func (o *OpenSearchStore) WaitForWrites(ctx context.Context) error {
+	return o.refresh(ctx, o.writeIndexes()...)
+}
+
+// WriteStatus returns the number of documents the cluster rejected
+func (o *OpenSearchStore) WriteStatus() WriteStatus {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return WriteStatus{
+		FailedWrites: o.failed,
+		LastError:    o.lastError,
+	}
+}
+
+// Close releases the idle connections of the HTTP client
+func (o *OpenSearchStore) Close(ctx context.Context) error {
+	o.client.CloseIdleConnections()
+	return nil
+}
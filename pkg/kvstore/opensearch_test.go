@@ -0,0 +1,529 @@
+package kvstore_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/config"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore/kvstoretest"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+)
+
+// The OpenSearch conformance tests run against the cluster at OPENSEARCH_HOST, e.g. http://localhost:9200
+func TestOpenSearchStoreConformance(t *testing.T) {
+	host := os.Getenv("OPENSEARCH_HOST")
+	if host == "" {
+		t.Skip("OPENSEARCH_HOST is not set")
+	}
+
+	kvstoretest.Run(t, func(t *testing.T) kvstore.KVStore {
+		// Every test gets its own alias and indexes
+		store, err := kvstore.NewOpenSearchStore(context.Background(), &http.Client{Timeout: 30 * time.Second}, kvstore.OpenSearchConfig{
+			Host:          host,
+			IndexName:     fmt.Sprintf("conformance-%d", time.Now().UnixNano()),
+			KeyMinGram:    2,
+			KeyMaxGram:    20,
+			ValueAnalyzer: "standard",
+		})
+		if err != nil {
+			t.Fatalf("NewOpenSearchStore() error = %v", err)
+		}
+		t.Cleanup(func() { store.Close(context.Background()) }) //nolint
+		return store
+	})
+}
+
+// openSearchStandIn is an in-memory stand-in of the OpenSearch REST API, covering the requests of the store
+// Full-text queries match the documents whose field contains every term of the query, ignoring case
+type openSearchStandIn struct {
+	mu      sync.Mutex
+	indexes map[string]map[string]json.RawMessage // documents of every concrete index by id
+	aliases map[string]string                     // concrete index of every alias
+	status  int                                   // status every request fails with, 0 to serve them
+	reject  string                                // value of the documents bulk requests reject
+}
+
+func newOpenSearchStandIn(t *testing.T) (*openSearchStandIn, *httptest.Server) {
+	t.Helper()
+	if logger.L == nil {
+		if err := logger.NewLogger(&config.Config{}); err != nil {
+			t.Fatalf("failed to create logger: %v", err)
+		}
+	}
+	s := &openSearchStandIn{
+		indexes: make(map[string]map[string]json.RawMessage),
+		aliases: make(map[string]string),
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+func (s *openSearchStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.status != 0 {
+		writeOpenSearchError(w, s.status, "cluster_block_exception", "index is read-only")
+		return
+	}
+
+	segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && segments[0] == "_alias":
+		index, ok := s.aliases[segments[1]]
+		if !ok {
+			writeOpenSearchError(w, http.StatusNotFound, "aliases_not_found_exception", "alias missing")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{index: map[string]any{"aliases": map[string]any{segments[1]: map[string]any{}}}})
+	case r.Method == http.MethodPost && segments[0] == "_aliases":
+		s.updateAliases(w, r)
+	case r.Method == http.MethodPost && segments[0] == "_bulk":
+		s.bulk(w, r)
+	case len(segments) == 1 && r.Method == http.MethodPut:
+		if _, ok := s.indexes[segments[0]]; ok {
+			writeOpenSearchError(w, http.StatusBadRequest, "resource_already_exists_exception", "index already exists")
+			return
+		}
+		s.indexes[segments[0]] = make(map[string]json.RawMessage)
+		writeJSON(w, http.StatusOK, map[string]any{"acknowledged": true})
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		if _, ok := s.indexes[segments[0]]; !ok {
+			writeOpenSearchError(w, http.StatusNotFound, "index_not_found_exception", "no such index")
+			return
+		}
+		delete(s.indexes, segments[0])
+		for alias, index := range s.aliases {
+			if index == segments[0] {
+				delete(s.aliases, alias)
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"acknowledged": true})
+	case len(segments) == 2 && segments[1] == "_refresh":
+		writeJSON(w, http.StatusOK, map[string]any{})
+	case len(segments) == 2 && segments[1] == "_search":
+		s.search(w, r, segments[0])
+	case len(segments) == 3 && segments[1] == "_doc" && r.Method == http.MethodGet:
+		doc, ok := s.index(segments[0])[segments[2]]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]any{"found": false})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"found": true, "_source": doc})
+	case len(segments) == 3 && segments[1] == "_doc" && r.Method == http.MethodPut:
+		// Writing a document creates its index, like the checkpoint in the meta index
+		var doc json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+			writeOpenSearchError(w, http.StatusBadRequest, "parse_exception", err.Error())
+			return
+		}
+		if _, ok := s.indexes[segments[0]]; !ok {
+			s.indexes[segments[0]] = make(map[string]json.RawMessage)
+		}
+		s.indexes[segments[0]][segments[2]] = doc
+		writeJSON(w, http.StatusOK, map[string]any{"result": "created"})
+	default:
+		writeOpenSearchError(w, http.StatusBadRequest, "illegal_argument_exception", "unsupported request "+r.Method+" "+r.URL.Path)
+	}
+}
+
+// index returns the documents of the index or alias, nil if it does not exist
+func (s *openSearchStandIn) index(name string) map[string]json.RawMessage {
+	if index, ok := s.aliases[name]; ok {
+		name = index
+	}
+	return s.indexes[name]
+}
+
+func (s *openSearchStandIn) updateAliases(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Actions []map[string]struct {
+			Index string `json:"index"`
+			Alias string `json:"alias"`
+		} `json:"actions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenSearchError(w, http.StatusBadRequest, "parse_exception", err.Error())
+		return
+	}
+	for _, action := range req.Actions {
+		if remove, ok := action["remove"]; ok && s.aliases[remove.Alias] == remove.Index {
+			delete(s.aliases, remove.Alias)
+		}
+		if add, ok := action["add"]; ok {
+			s.aliases[add.Alias] = add.Index
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"acknowledged": true})
+}
+
+func (s *openSearchStandIn) bulk(w http.ResponseWriter, r *http.Request) {
+	dec := json.NewDecoder(r.Body)
+	var items []map[string]any
+	failed := false
+	for dec.More() {
+		var action map[string]struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		if err := dec.Decode(&action); err != nil {
+			writeOpenSearchError(w, http.StatusBadRequest, "parse_exception", err.Error())
+			return
+		}
+		for name, target := range action {
+			docs := s.indexes[target.Index]
+			result := map[string]any{"_index": target.Index, "_id": target.ID, "status": http.StatusOK}
+			switch name {
+			case "index":
+				var doc json.RawMessage
+				if err := dec.Decode(&doc); err != nil {
+					writeOpenSearchError(w, http.StatusBadRequest, "parse_exception", err.Error())
+					return
+				}
+				var kv common.KV
+				json.Unmarshal(doc, &kv) //nolint
+				switch {
+				case docs == nil:
+					result["status"] = http.StatusNotFound
+					result["error"] = map[string]string{"type": "index_not_found_exception", "reason": "no such index"}
+					failed = true
+				case s.reject != "" && kv.Value == s.reject:
+					result["status"] = http.StatusBadRequest
+					result["error"] = map[string]string{"type": "mapper_parsing_exception", "reason": "failed to parse field [value]"}
+					failed = true
+				default:
+					docs[target.ID] = doc
+				}
+			case "delete":
+				if _, ok := docs[target.ID]; !ok {
+					result["status"] = http.StatusNotFound
+					result["result"] = "not_found"
+					failed = true
+					break
+				}
+				delete(docs, target.ID)
+			}
+			items = append(items, map[string]any{name: result})
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"errors": failed, "items": items})
+}
+
+func (s *openSearchStandIn) search(w http.ResponseWriter, r *http.Request, name string) {
+	var req struct {
+		From        int                 `json:"from"`
+		Size        int                 `json:"size"`
+		Sort        []map[string]string `json:"sort"`
+		SearchAfter []string            `json:"search_after"`
+		Query       struct {
+			Prefix map[string]string `json:"prefix"`
+			Bool   struct {
+				Should []struct {
+					Match map[string]struct {
+						Query string `json:"query"`
+					} `json:"match"`
+				} `json:"should"`
+			} `json:"bool"`
+		} `json:"query"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenSearchError(w, http.StatusBadRequest, "parse_exception", err.Error())
+		return
+	}
+	docs := s.index(name)
+	if docs == nil {
+		writeOpenSearchError(w, http.StatusNotFound, "index_not_found_exception", "no such index")
+		return
+	}
+	if req.From+req.Size > 10000 {
+		writeOpenSearchError(w, http.StatusBadRequest, "illegal_argument_exception", "Result window is too large")
+		return
+	}
+
+	kvs := make([]common.KV, 0, len(docs))
+	for _, doc := range docs {
+		var kv common.KV
+		json.Unmarshal(doc, &kv) //nolint
+		kvs = append(kvs, kv)
+	}
+	slices.SortFunc(kvs, func(a, b common.KV) int { return strings.Compare(a.Key, b.Key) })
+
+	var hits []map[string]any
+	for _, kv := range kvs {
+		if len(req.SearchAfter) > 0 && kv.Key <= req.SearchAfter[0] {
+			continue
+		}
+		if prefix, ok := req.Query.Prefix["key.raw"]; ok && !strings.HasPrefix(kv.Key, prefix) {
+			continue
+		}
+		highlight := make(map[string][]string)
+		for _, should := range req.Query.Bool.Should {
+			for field, match := range should.Match {
+				text := kv.Key
+				if field == "value" {
+					text = kv.Value
+				}
+				if containsTerms(text, match.Query) {
+					highlight[field] = []string{text}
+				}
+			}
+		}
+		if len(req.Query.Bool.Should) > 0 && len(highlight) == 0 {
+			continue
+		}
+		hits = append(hits, map[string]any{"_source": kv, "highlight": highlight})
+	}
+
+	total := len(hits)
+	hits = hits[min(req.From, total):min(req.From+req.Size, total)]
+	writeJSON(w, http.StatusOK, map[string]any{"hits": map[string]any{"total": map[string]int{"value": total}, "hits": hits}})
+}
+
+// containsTerms reports whether text contains every term of query, ignoring case
+func containsTerms(text, query string) bool {
+	for _, term := range strings.Fields(strings.ToLower(query)) {
+		if !strings.Contains(strings.ToLower(text), term) {
+			return false
+		}
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body) //nolint
+}
+
+func writeOpenSearchError(w http.ResponseWriter, status int, errType, reason string) {
+	writeJSON(w, status, map[string]any{
+		"error":  map[string]string{"type": errType, "reason": reason},
+		"status": status,
+	})
+}
+
+func newOpenSearchTestStore(t *testing.T, host string) kvstore.KVStore {
+	t.Helper()
+	store, err := kvstore.NewOpenSearchStore(context.Background(), nil, kvstore.OpenSearchConfig{
+		Host:       host,
+		IndexName:  "keys",
+		KeyMinGram: 2,
+		KeyMaxGram: 20,
+	})
+	if err != nil {
+		t.Fatalf("NewOpenSearchStore() error = %v", err)
+	}
+	t.Cleanup(func() { store.Close(context.Background()) }) //nolint
+	return store
+}
+
+func TestOpenSearchStoreBulk(t *testing.T) {
+	ctx := context.Background()
+	standIn, server := newOpenSearchStandIn(t)
+	store := newOpenSearchTestStore(t, server.URL)
+
+	if standIn.aliases["keys"] != "keys-a" {
+		t.Fatalf("alias keys points to %q, want keys-a", standIn.aliases["keys"])
+	}
+
+	err := store.PutBatch(ctx, []common.KV{
+		{Key: "/app/db/host", Value: "db.internal"},
+		{Key: "/app/db/port", Value: "5432"},
+	})
+	if err != nil {
+		t.Fatalf("PutBatch() error = %v", err)
+	}
+	if err := store.DeleteBatch(ctx, []string{"/app/db/port", "/app/never/written"}); err != nil {
+		t.Fatalf("DeleteBatch() error = %v", err)
+	}
+	if value, err := store.Get(ctx, "/app/db/host"); err != nil || value != "db.internal" {
+		t.Fatalf("Get() = %q, %v, want db.internal", value, err)
+	}
+	if _, err := store.Get(ctx, "/app/db/port"); !errors.Is(err, customerrors.ErrKeyNotFound) {
+		t.Fatalf("Get() of a deleted key error = %v, want ErrKeyNotFound", err)
+	}
+	// Deleting a document that does not exist is not a failed write
+	if status := store.WriteStatus(); status.FailedWrites != 0 {
+		t.Fatalf("WriteStatus() = %+v, want no failed write", status)
+	}
+
+	// A document the cluster rejects is counted as a failed write, the rest of the batch is written
+	standIn.reject = "rejected"
+	err = store.PutBatch(ctx, []common.KV{
+		{Key: "/app/bad", Value: "rejected"},
+		{Key: "/app/good", Value: "accepted"},
+	})
+	if err != nil {
+		t.Fatalf("PutBatch() with a rejected document error = %v", err)
+	}
+	status := store.WriteStatus()
+	if status.FailedWrites != 1 || !strings.Contains(status.LastError, "mapper_parsing_exception") {
+		t.Fatalf("WriteStatus() = %+v, want the rejected document", status)
+	}
+	if value, err := store.Get(ctx, "/app/good"); err != nil || value != "accepted" {
+		t.Fatalf("Get() = %q, %v, want accepted", value, err)
+	}
+}
+
+func TestOpenSearchStoreRebuild(t *testing.T) {
+	ctx := context.Background()
+	standIn, server := newOpenSearchStandIn(t)
+	store := newOpenSearchTestStore(t, server.URL)
+
+	if err := store.PutBatch(ctx, []common.KV{{Key: "/stale", Value: "v"}, {Key: "/kept", Value: "old"}}); err != nil {
+		t.Fatalf("PutBatch() error = %v", err)
+	}
+	if err := store.BeginRebuild(ctx); err != nil {
+		t.Fatalf("BeginRebuild() error = %v", err)
+	}
+	if err := store.PutRebuildBatch(ctx, []common.KV{{Key: "/kept", Value: "new"}}); err != nil {
+		t.Fatalf("PutRebuildBatch() error = %v", err)
+	}
+	// Writes during the rebuild go to both indexes
+	if err := store.Put(ctx, "/live", "v"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if value, err := store.Get(ctx, "/kept"); err != nil || value != "old" {
+		t.Fatalf("Get() during the rebuild = %q, %v, want the live value old", value, err)
+	}
+
+	if err := store.CommitRebuild(ctx); err != nil {
+		t.Fatalf("CommitRebuild() error = %v", err)
+	}
+	if standIn.aliases["keys"] != "keys-b" {
+		t.Fatalf("alias keys points to %q after the rebuild, want keys-b", standIn.aliases["keys"])
+	}
+	if _, ok := standIn.indexes["keys-a"]; ok {
+		t.Fatal("the previous index keys-a was not deleted")
+	}
+	if value, err := store.Get(ctx, "/kept"); err != nil || value != "new" {
+		t.Fatalf("Get() after the rebuild = %q, %v, want new", value, err)
+	}
+	if _, err := store.Get(ctx, "/live"); err != nil {
+		t.Fatalf("Get() of a key written during the rebuild error = %v", err)
+	}
+	if _, err := store.Get(ctx, "/stale"); !errors.Is(err, customerrors.ErrKeyNotFound) {
+		t.Fatalf("Get() of a key missing from the rebuild error = %v, want ErrKeyNotFound", err)
+	}
+
+	// The alias is kept across restarts, the next rebuild goes back to keys-a
+	restarted := newOpenSearchTestStore(t, server.URL)
+	if err := restarted.BeginRebuild(ctx); err != nil {
+		t.Fatalf("BeginRebuild() error = %v", err)
+	}
+	if _, ok := standIn.indexes["keys-a"]; !ok {
+		t.Fatal("BeginRebuild() after a restart did not create keys-a")
+	}
+	if err := restarted.AbortRebuild(ctx); err != nil {
+		t.Fatalf("AbortRebuild() error = %v", err)
+	}
+	if _, ok := standIn.indexes["keys-a"]; ok || standIn.aliases["keys"] != "keys-b" {
+		t.Fatalf("AbortRebuild() left indexes %v and alias %q, want keys-a deleted and keys-b live", standIn.indexes, standIn.aliases["keys"])
+	}
+}
+
+func TestOpenSearchStoreSearch(t *testing.T) {
+	ctx := context.Background()
+	standIn, server := newOpenSearchStandIn(t)
+	store := newOpenSearchTestStore(t, server.URL)
+
+	var batch []common.KV
+	for i := range 25 {
+		batch = append(batch, common.KV{Key: fmt.Sprintf("/services/billing/%02d", i), Value: "https://payments.internal"})
+	}
+	batch = append(batch, common.KV{Key: "/services/payments/endpoint", Value: "https://billing.internal"})
+	if err := store.PutBatch(ctx, batch); err != nil {
+		t.Fatalf("PutBatch() error = %v", err)
+	}
+
+	results, err := store.Search(ctx, "payments", kvstore.SearchOptions{Scope: kvstore.SearchScopeKeys})
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if len(results.Hits) != 1 || results.Hits[0].Key != "/services/payments/endpoint" || !slices.Equal(results.Hits[0].MatchedFields, []string{"key"}) {
+		t.Fatalf("Search(keys) = %+v, want the payments key", results.Hits)
+	}
+
+	page, err := store.Search(ctx, "payments", kvstore.SearchOptions{Scope: kvstore.SearchScopeValues, Offset: 20, Limit: 10})
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if len(page.Hits) != 5 || page.EstimatedTotalHits != 25 {
+		t.Fatalf("Search(values) page = %d hits of %d, want 5 of 25", len(page.Hits), page.EstimatedTotalHits)
+	}
+
+	// Exact modes scan the documents with List, whatever the size of the index
+	prefix, err := store.Search(ctx, "/services/billing/2", kvstore.SearchOptions{Mode: kvstore.SearchModePrefix})
+	if err != nil {
+		t.Fatalf("Search(prefix) error = %v", err)
+	}
+	if got := len(prefix.Hits); got != 5 {
+		t.Fatalf("Search(prefix) = %d hits, want 5", got)
+	}
+
+	// A missing index is an empty index
+	standIn.mu.Lock()
+	delete(standIn.indexes, "keys-a")
+	delete(standIn.aliases, "keys")
+	standIn.mu.Unlock()
+	empty, err := store.Search(ctx, "payments", kvstore.SearchOptions{})
+	if err != nil || len(empty.Hits) != 0 {
+		t.Fatalf("Search() of a missing index = %+v, %v, want no hit", empty, err)
+	}
+	if kvs, next, err := store.List(ctx, "", 10); err != nil || len(kvs) != 0 || next != "" {
+		t.Fatalf("List() of a missing index = %v, %q, %v, want nothing", kvs, next, err)
+	}
+	if revision, err := store.GetCheckpoint(ctx); err != nil || revision != 0 {
+		t.Fatalf("GetCheckpoint() without a checkpoint = %d, %v, want 0", revision, err)
+	}
+}
+
+func TestOpenSearchStoreErrors(t *testing.T) {
+	ctx := context.Background()
+	standIn, server := newOpenSearchStandIn(t)
+	store := newOpenSearchTestStore(t, server.URL)
+
+	if err := store.SetCheckpoint(ctx, 42); err != nil {
+		t.Fatalf("SetCheckpoint() error = %v", err)
+	}
+	if revision, err := store.GetCheckpoint(ctx); err != nil || revision != 42 {
+		t.Fatalf("GetCheckpoint() = %d, %v, want 42", revision, err)
+	}
+
+	standIn.mu.Lock()
+	standIn.status = http.StatusServiceUnavailable
+	standIn.mu.Unlock()
+
+	if _, err := store.Get(ctx, "/app/key"); err == nil || errors.Is(err, customerrors.ErrKeyNotFound) {
+		t.Fatalf("Get() on a failing cluster error = %v, want a request error", err)
+	}
+	if err := store.Put(ctx, "/app/key", "v"); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("Put() on a failing cluster error = %v, want the status", err)
+	}
+	if _, err := store.Search(ctx, "key", kvstore.SearchOptions{}); err == nil {
+		t.Fatal("Search() on a failing cluster succeeded")
+	}
+	if _, err := store.GetCheckpoint(ctx); err == nil {
+		t.Fatal("GetCheckpoint() on a failing cluster succeeded")
+	}
+	if err := store.BeginRebuild(ctx); err == nil {
+		t.Fatal("BeginRebuild() on a failing cluster succeeded")
+	}
+	if _, err := kvstore.NewOpenSearchStore(ctx, nil, kvstore.OpenSearchConfig{Host: server.URL, IndexName: "other", KeyMinGram: 2, KeyMaxGram: 20}); err == nil {
+		t.Fatal("NewOpenSearchStore() on a failing cluster succeeded")
+	}
+}
@@ -0,0 +1,196 @@
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+)
+
+// DefaultMaxScanKeys is the number of keys under the key prefix of its pattern an exact search evaluated by a scan reads at most
+const DefaultMaxScanKeys = 100000
+
+// SearchMode selects how the search string is matched
+type SearchMode string
+
+const (
+	SearchModeFuzzy  SearchMode = "fuzzy"  // typo tolerant full-text search, the default
+	SearchModePrefix SearchMode = "prefix" // exact prefix
+	SearchModeGlob   SearchMode = "glob"   // path.Match pattern, * and ? do not match /
+	SearchModeRegex  SearchMode = "regex"  // RE2 regular expression, unanchored
+)
+
+// Valid reports whether the mode is one of the supported modes
+func (m SearchMode) Valid() bool {
+	switch m {
+	case SearchModeFuzzy, SearchModePrefix, SearchModeGlob, SearchModeRegex:
+		return true
+	}
+	return false
+}
+
+// Exact reports whether the mode is evaluated exactly instead of by the full-text engine
+func (m SearchMode) Exact() bool {
+	return m == SearchModePrefix || m == SearchModeGlob || m == SearchModeRegex
+}
+
+// Matcher reports whether a key or value matches an exact search pattern
+type Matcher func(s string) bool
+
+// CompileMatcher compiles the pattern of an exact search mode
+// Returns customerrors.ErrMalformedSearchString if the pattern is invalid
+func CompileMatcher(mode SearchMode, pattern string) (Matcher, error) {
+	switch mode {
+	case SearchModePrefix:
+		return func(s string) bool {
+			return strings.HasPrefix(s, pattern)
+		}, nil
+	case SearchModeGlob:
+		// Match reports a malformed pattern whatever the string it is matched against
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%w: invalid glob pattern %q: %v", customerrors.ErrMalformedSearchString, pattern, err)
+		}
+		return func(s string) bool {
+			ok, _ := path.Match(pattern, s)
+			return ok
+		}, nil
+	case SearchModeRegex:
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid regular expression %q: %v", customerrors.ErrMalformedSearchString, pattern, err)
+		}
+		return re.MatchString, nil
+	default:
+		return nil, fmt.Errorf("search mode %q is not an exact mode", mode)
+	}
+}
+
+// matchKV returns the fields of kv selected by scope that the matcher matches
+func matchKV(match Matcher, scope SearchScope, kv common.KV) []string {
+	var fields []string
+	if scope != SearchScopeValues && match(kv.Key) {
+		fields = append(fields, lib.KEY_CONSTANT)
+	}
+	if (scope == SearchScopeValues || scope == SearchScopeBoth) && match(kv.Value) {
+		fields = append(fields, lib.VALUE_CONSTANT)
+	}
+	return fields
+}
+
+// keyPrefix returns the prefix every key matched by the pattern starts with, empty if there is none
+func keyPrefix(mode SearchMode, pattern string) string {
+	switch mode {
+	case SearchModePrefix:
+		return pattern
+	case SearchModeGlob:
+		// Everything before the first special character of path.Match is matched literally
+		if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+			return pattern[:i]
+		}
+		return pattern
+	case SearchModeRegex:
+		// Only a pattern anchored at the start of the text has a prefix, unanchored ones match anywhere
+		re, err := syntax.Parse(pattern, syntax.Perl)
+		if err != nil {
+			return ""
+		}
+		re = re.Simplify()
+		if re.Op != syntax.OpConcat || len(re.Sub) == 0 || re.Sub[0].Op != syntax.OpBeginText {
+			return ""
+		}
+		var prefix strings.Builder
+		for _, sub := range re.Sub[1:] {
+			if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+				break
+			}
+			prefix.WriteString(string(sub.Rune))
+		}
+		return prefix.String()
+	}
+	return ""
+}
+
+// scanPrefix returns the key prefix the scan of an exact search can be narrowed to, empty if every key must be read
+// Values can match whatever their key, so only a search of the keys alone is narrowed
+func scanPrefix(searchStr string, opts SearchOptions) string {
+	if opts.Scope == SearchScopeValues || opts.Scope == SearchScopeBoth {
+		return ""
+	}
+	return keyPrefix(opts.Mode, searchStr)
+}
+
+// scanSearch evaluates an exact search by scanning every key-value pair returned by list,
+// which may skip the keys outside scanPrefix
+// Hits are sorted by key, so pages are stable across calls
+// The scan fails with customerrors.ErrSearchTooBroad once it has read more than opts.MaxScanKeys keys under scanPrefix
+func scanSearch(
+	ctx context.Context,
+	list func(ctx context.Context, cursor string, limit int64) ([]common.KV, string, error),
+	searchStr string,
+	opts SearchOptions) (SearchResults, error) {
+	match, err := CompileMatcher(opts.Mode, searchStr)
+	if err != nil {
+		return SearchResults{}, err
+	}
+
+	prefix := scanPrefix(searchStr, opts)
+	maxScanKeys := opts.MaxScanKeys
+	if maxScanKeys <= 0 {
+		maxScanKeys = DefaultMaxScanKeys
+	}
+
+	var hits []SearchHit
+	var scanned int64
+	cursor := ""
+	for {
+		kvs, next, err := list(ctx, cursor, MaxSearchLimit)
+		if err != nil {
+			return SearchResults{}, err
+		}
+		for _, kv := range kvs {
+			if !strings.HasPrefix(kv.Key, prefix) {
+				continue
+			}
+			if scanned++; scanned > maxScanKeys {
+				return SearchResults{}, fmt.Errorf("%w: more than %d keys under %q to scan", customerrors.ErrSearchTooBroad, maxScanKeys, prefix)
+			}
+			if fields := matchKV(match, opts.Scope, kv); len(fields) > 0 {
+				hits = append(hits, SearchHit{
+					KV:            kv,
+					MatchedFields: fields,
+				})
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	sort.Slice(hits, func(a, b int) bool {
+		return hits[a].Key < hits[b].Key
+	})
+	return pageHits(hits, opts), nil
+}
+
+// pageHits returns the page of hits selected by opts
+func pageHits(hits []SearchHit, opts SearchOptions) SearchResults {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	start := min(opts.Offset, int64(len(hits)))
+	end := min(start+min(limit, MaxSearchLimit), int64(len(hits)))
+
+	return SearchResults{
+		Hits:               hits[start:end],
+		EstimatedTotalHits: int64(len(hits)),
+	}
+}
@@ -0,0 +1,505 @@
+package kvstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	_ "modernc.org/sqlite" // registers the pure-Go sqlite driver
+)
+
+// sqliteTables names the tables holding one copy of the documents
+// The documents table is the source of the key lookups and listings, the fts table indexes
+// the same rows, sharing their rowid, with the trigram tokenizer for substring matching
+type sqliteTables struct {
+	documents string
+	fts       string
+}
+
+var (
+	sqliteLiveTables   = sqliteTables{documents: "documents", fts: "documents_fts"}
+	sqliteShadowTables = sqliteTables{documents: "rebuild_documents", fts: "rebuild_documents_fts"}
+)
+
+// SQLiteStore implements the KVStore interface using SQLite FTS5
+// The documents and the checkpoint are stored in the same database file, so they survive restarts together
+type SQLiteStore struct {
+	db *sql.DB
+
+	mu         sync.RWMutex
+	rebuilding bool // whether writes are applied to the shadow tables as well
+}
+
+// NewSQLiteStore opens or creates the SQLite database at path
+func NewSQLiteStore(ctx context.Context, path string) (KVStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// SQLite allows a single writer, a single connection also keeps the rebuild swap atomic for readers
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS meta (name TEXT PRIMARY KEY, value INTEGER NOT NULL)`); err != nil {
+		db.Close() //nolint
+		return nil, fmt.Errorf("failed to create meta table: %w", err)
+	}
+	if err := createSQLiteTables(ctx, db, sqliteLiveTables); err != nil {
+		db.Close() //nolint
+		return nil, err
+	}
+
+	return &SQLiteStore{
+		db: db,
+	}, nil
+}
+
+func createSQLiteTables(ctx context.Context, db *sql.DB, tables sqliteTables) error {
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (key TEXT PRIMARY KEY, value TEXT NOT NULL)`, tables.documents),
+		fmt.Sprintf(`CREATE VIRTUAL TABLE IF NOT EXISTS %s USING fts5(key, value, tokenize = 'trigram')`, tables.fts),
+	}
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("failed to create tables: %w", err)
+		}
+	}
+	return nil
+}
+
+func dropSQLiteTables(ctx context.Context, exec interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}, tables sqliteTables) error {
+	for _, table := range []string{tables.documents, tables.fts} {
+		if _, err := exec.ExecContext(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s`, table)); err != nil {
+			return fmt.Errorf("failed to drop table %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// write applies the puts and deletes in a single transaction, to the live tables and to the shadow
+// tables if a rebuild is in progress, or to the shadow tables only if shadowOnly is set
+func (ss *SQLiteStore) write(ctx context.Context, shadowOnly bool, puts []common.KV, deletes []string) error {
+	// The lock keeps the shadow tables from being renamed while they are written to
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	var tablesList []sqliteTables
+	switch {
+	case shadowOnly && !ss.rebuilding:
+		return fmt.Errorf("no rebuild in progress")
+	case shadowOnly:
+		tablesList = []sqliteTables{sqliteShadowTables}
+	case ss.rebuilding:
+		tablesList = []sqliteTables{sqliteLiveTables, sqliteShadowTables}
+	default:
+		tablesList = []sqliteTables{sqliteLiveTables}
+	}
+
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint
+
+	for _, tables := range tablesList {
+		deleteFTS, err := tx.PrepareContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE rowid = ?`, tables.fts))
+		if err != nil {
+			return fmt.Errorf("failed to prepare statement: %w", err)
+		}
+		defer deleteFTS.Close() //nolint
+
+		for _, key := range deletes {
+			var rowid int64
+			err := tx.QueryRowContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE key = ? RETURNING rowid`, tables.documents), key).Scan(&rowid)
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to delete document: %w", err)
+			}
+			if _, err := deleteFTS.ExecContext(ctx, rowid); err != nil {
+				return fmt.Errorf("failed to delete document: %w", err)
+			}
+		}
+
+		upsert, err := tx.PrepareContext(ctx, fmt.Sprintf(
+			`INSERT INTO %s (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value RETURNING rowid`,
+			tables.documents))
+		if err != nil {
+			return fmt.Errorf("failed to prepare statement: %w", err)
+		}
+		defer upsert.Close() //nolint
+		insertFTS, err := tx.PrepareContext(ctx, fmt.Sprintf(`INSERT INTO %s (rowid, key, value) VALUES (?, ?, ?)`, tables.fts))
+		if err != nil {
+			return fmt.Errorf("failed to prepare statement: %w", err)
+		}
+		defer insertFTS.Close() //nolint
+
+		for _, kv := range puts {
+			var rowid int64
+			if err := upsert.QueryRowContext(ctx, kv.Key, kv.Value).Scan(&rowid); err != nil {
+				return fmt.Errorf("failed to put document: %w", err)
+			}
+			if _, err := deleteFTS.ExecContext(ctx, rowid); err != nil {
+				return fmt.Errorf("failed to put document: %w", err)
+			}
+			if _, err := insertFTS.ExecContext(ctx, rowid, kv.Key, kv.Value); err != nil {
+				return fmt.Errorf("failed to put document: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Get retrieves a value by key
+func (ss *SQLiteStore) Get(ctx context.Context, key string) (string, error) {
+	var value string
+	err := ss.db.QueryRowContext(ctx, `SELECT value FROM documents WHERE key = ?`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("%w: %s", customerrors.ErrKeyNotFound, key)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get document: %w", err)
+	}
+	return value, nil
+}
+
+// Put stores or updates a key-value pair
+func (ss *SQLiteStore) Put(ctx context.Context, key string, value string) error {
+	return ss.write(ctx, false, []common.KV{{Key: key, Value: value}}, nil)
+}
+
+// PutBatch stores or updates a batch of key-value pairs in a single transaction
+func (ss *SQLiteStore) PutBatch(ctx context.Context, kvs []common.KV) error {
+	return ss.write(ctx, false, kvs, nil)
+}
+
+// Search searches for keys or values matching the search string
+// Terms of at least three characters are matched through the trigram index and ranked with bm25,
+// shorter terms are matched with LIKE. Every term must be found, ignoring case
+func (ss *SQLiteStore) Search(ctx context.Context, searchStr string, opts SearchOptions) (SearchResults, error) {
+	if opts.Mode == SearchModePrefix && opts.Scope != SearchScopeValues && opts.Scope != SearchScopeBoth {
+		return ss.searchPrefix(ctx, searchStr, opts)
+	}
+	if opts.Mode.Exact() {
+		return scanSearch(ctx, ss.listPrefix(scanPrefix(searchStr, opts)), searchStr, opts)
+	}
+
+	var columns []string
+	if opts.Scope != SearchScopeValues {
+		columns = append(columns, lib.KEY_CONSTANT)
+	}
+	if opts.Scope == SearchScopeValues || opts.Scope == SearchScopeBoth {
+		columns = append(columns, lib.VALUE_CONSTANT)
+	}
+
+	terms := strings.Fields(strings.ToLower(searchStr))
+	var phrases []string
+	var conditions []string
+	var args []any
+	for _, term := range terms {
+		if len(term) >= gramSize {
+			phrases = append(phrases, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+			continue
+		}
+		var likes []string
+		for _, column := range columns {
+			likes = append(likes, column+` LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+escapeLike(term)+"%")
+		}
+		conditions = append(conditions, "("+strings.Join(likes, " OR ")+")")
+	}
+	order := "key"
+	if len(phrases) > 0 {
+		match := "{" + strings.Join(columns, " ") + "} : (" + strings.Join(phrases, " AND ") + ")"
+		conditions = append([]string{"documents_fts MATCH ?"}, conditions...)
+		args = append([]any{match}, args...)
+		order = "rank, key"
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int64
+	if err := ss.db.QueryRowContext(ctx, `SELECT count(*) FROM documents_fts`+where, args...).Scan(&total); err != nil {
+		return SearchResults{}, fmt.Errorf("search failed: %w", err)
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	rows, err := ss.db.QueryContext(ctx,
+		`SELECT key, value FROM documents_fts`+where+` ORDER BY `+order+` LIMIT ? OFFSET ?`,
+		append(args, min(limit, MaxSearchLimit), opts.Offset)...)
+	if err != nil {
+		return SearchResults{}, fmt.Errorf("search failed: %w", err)
+	}
+	kvs, err := scanKVs(rows)
+	if err != nil {
+		return SearchResults{}, fmt.Errorf("search failed: %w", err)
+	}
+
+	hits := make([]SearchHit, 0, len(kvs))
+	for _, kv := range kvs {
+		hits = append(hits, SearchHit{
+			KV:            kv,
+			MatchedFields: containsFields(kv, terms, opts.Scope),
+		})
+	}
+	return SearchResults{
+		Hits:               hits,
+		EstimatedTotalHits: total,
+	}, nil
+}
+
+// searchPrefix returns the keys starting with prefix using the primary key index
+func (ss *SQLiteStore) searchPrefix(ctx context.Context, prefix string, opts SearchOptions) (SearchResults, error) {
+	where := ` WHERE key >= ?`
+	args := []any{prefix}
+	if end, ok := prefixEnd(prefix); ok {
+		where += ` AND key < ?`
+		args = append(args, end)
+	}
+
+	var total int64
+	if err := ss.db.QueryRowContext(ctx, `SELECT count(*) FROM documents`+where, args...).Scan(&total); err != nil {
+		return SearchResults{}, fmt.Errorf("search failed: %w", err)
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	rows, err := ss.db.QueryContext(ctx,
+		`SELECT key, value FROM documents`+where+` ORDER BY key LIMIT ? OFFSET ?`,
+		append(args, min(limit, MaxSearchLimit), opts.Offset)...)
+	if err != nil {
+		return SearchResults{}, fmt.Errorf("search failed: %w", err)
+	}
+	kvs, err := scanKVs(rows)
+	if err != nil {
+		return SearchResults{}, fmt.Errorf("search failed: %w", err)
+	}
+
+	hits := make([]SearchHit, 0, len(kvs))
+	for _, kv := range kvs {
+		hits = append(hits, SearchHit{
+			KV:            kv,
+			MatchedFields: []string{lib.KEY_CONSTANT},
+		})
+	}
+	return SearchResults{
+		Hits:               hits,
+		EstimatedTotalHits: total,
+	}, nil
+}
+
+// prefixEnd returns the smallest string greater than every string starting with prefix
+// Returns false if there is none, i.e. the prefix is empty or only made of 0xff bytes
+func prefixEnd(prefix string) (string, bool) {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1]), true
+		}
+	}
+	return "", false
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// containsFields returns the fields of kv selected by scope that contain one of the lowercased terms
+func containsFields(kv common.KV, terms []string, scope SearchScope) []string {
+	var fields []string
+	for _, field := range []struct {
+		name    string
+		value   string
+		enabled bool
+	}{
+		{lib.KEY_CONSTANT, kv.Key, scope != SearchScopeValues},
+		{lib.VALUE_CONSTANT, kv.Value, scope == SearchScopeValues || scope == SearchScopeBoth},
+	} {
+		if !field.enabled {
+			continue
+		}
+		lower := strings.ToLower(field.value)
+		for _, term := range terms {
+			if strings.Contains(lower, term) {
+				fields = append(fields, field.name)
+				break
+			}
+		}
+	}
+	return fields
+}
+
+func scanKVs(rows *sql.Rows) ([]common.KV, error) {
+	defer rows.Close() //nolint
+
+	kvs := []common.KV{}
+	for rows.Next() {
+		var kv common.KV
+		if err := rows.Scan(&kv.Key, &kv.Value); err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, kv)
+	}
+	return kvs, rows.Err()
+}
+
+// Delete removes a key-value pair
+func (ss *SQLiteStore) Delete(ctx context.Context, key string) error {
+	return ss.write(ctx, false, nil, []string{key})
+}
+
+// DeleteBatch removes a batch of key-value pairs in a single transaction
+func (ss *SQLiteStore) DeleteBatch(ctx context.Context, keys []string) error {
+	return ss.write(ctx, false, nil, keys)
+}
+
+// List returns a page of the key-value pairs in key order, the cursor is the last key of the previous page
+// Seeking on the primary key keeps every page as cheap as the first one
+func (ss *SQLiteStore) List(ctx context.Context, cursor string, limit int64) ([]common.KV, string, error) {
+	return ss.listPrefix("")(ctx, cursor, limit)
+}
+
+// listPrefix returns a List of the documents whose key starts with prefix only
+func (ss *SQLiteStore) listPrefix(prefix string) func(ctx context.Context, cursor string, limit int64) ([]common.KV, string, error) {
+	where := ` WHERE key > ? AND key >= ?`
+	args := []any{prefix}
+	if end, ok := prefixEnd(prefix); ok {
+		where += ` AND key < ?`
+		args = append(args, end)
+	}
+	return func(ctx context.Context, cursor string, limit int64) ([]common.KV, string, error) {
+		rows, err := ss.db.QueryContext(ctx,
+			`SELECT key, value FROM documents`+where+` ORDER BY key LIMIT ?`,
+			append(append([]any{cursor}, args...), limit)...)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to list documents: %w", err)
+		}
+		kvs, err := scanKVs(rows)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to list documents: %w", err)
+		}
+		return kvs, keyCursor(kvs, limit), nil
+	}
+}
+
+// GetCheckpoint returns the revision stored in the meta table
+func (ss *SQLiteStore) GetCheckpoint(ctx context.Context) (int64, error) {
+	var revision int64
+	err := ss.db.QueryRowContext(ctx, `SELECT value FROM meta WHERE name = ?`, lib.REVISION_CONSTANT).Scan(&revision)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get checkpoint: %w", err)
+	}
+	return revision, nil
+}
+
+// SetCheckpoint stores the revision in the meta table
+func (ss *SQLiteStore) SetCheckpoint(ctx context.Context, revision int64) error {
+	_, err := ss.db.ExecContext(ctx,
+		`INSERT INTO meta (name, value) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET value = excluded.value`,
+		lib.REVISION_CONSTANT, revision)
+	if err != nil {
+		return fmt.Errorf("failed to set checkpoint: %w", err)
+	}
+	return nil
+}
+
+// BeginRebuild creates empty shadow tables, writes are applied to both sets of tables until the rebuild ends
+func (ss *SQLiteStore) BeginRebuild(ctx context.Context) error {
+	// Drop the leftovers of an interrupted rebuild
+	if err := dropSQLiteTables(ctx, ss.db, sqliteShadowTables); err != nil {
+		return err
+	}
+	if err := createSQLiteTables(ctx, ss.db, sqliteShadowTables); err != nil {
+		return err
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.rebuilding = true
+	return nil
+}
+
+// PutRebuildBatch stores a batch of key-value pairs in the shadow tables only
+func (ss *SQLiteStore) PutRebuildBatch(ctx context.Context, kvs []common.KV) error {
+	return ss.write(ctx, true, kvs, nil)
+}
+
+// CommitRebuild replaces the live tables with the shadow tables in a single transaction
+func (ss *SQLiteStore) CommitRebuild(ctx context.Context) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint
+
+	if err := dropSQLiteTables(ctx, tx, sqliteLiveTables); err != nil {
+		return err
+	}
+	for _, rename := range [][2]string{
+		{sqliteShadowTables.documents, sqliteLiveTables.documents},
+		{sqliteShadowTables.fts, sqliteLiveTables.fts},
+	} {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s RENAME TO %s`, rename[0], rename[1])); err != nil {
+			return fmt.Errorf("failed to rename table %s: %w", rename[0], err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	ss.rebuilding = false
+	return nil
+}
+
+// AbortRebuild stops writing to the shadow tables and drops them
+func (ss *SQLiteStore) AbortRebuild(ctx context.Context) error {
+	ss.mu.Lock()
+	ss.rebuilding = false
+	ss.mu.Unlock()
+	return dropSQLiteTables(ctx, ss.db, sqliteShadowTables)
+}
+
+// WaitForWrites returns immediately, writes are committed before they return
+func (ss *SQLiteStore) WaitForWrites(ctx context.Context) error {
+	return nil
+}
+
+// WriteStatus returns an empty status, writes are applied synchronously
+func (ss *SQLiteStore) WriteStatus() WriteStatus {
+	return WriteStatus{}
+}
+
+// Close closes the database
+func (ss *SQLiteStore) Close(ctx context.Context) error {
+	return ss.db.Close()
+}
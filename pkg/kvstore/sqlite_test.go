@@ -0,0 +1,21 @@
+package kvstore_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore/kvstoretest"
+)
+
+func TestSQLiteStoreConformance(t *testing.T) {
+	kvstoretest.Run(t, func(t *testing.T) kvstore.KVStore {
+		store, err := kvstore.NewSQLiteStore(context.Background(), filepath.Join(t.TempDir(), "etcdfinder.db"))
+		if err != nil {
+			t.Fatalf("NewSQLiteStore() error = %v", err)
+		}
+		t.Cleanup(func() { store.Close(context.Background()) }) //nolint
+		return store
+	})
+}